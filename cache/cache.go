@@ -0,0 +1,408 @@
+// Package cache keeps resized receipt images so repeated requests for the
+// same rendition skip decoding and resizing.
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Key identifies a derived image. Variant encodes everything that affects
+// the output, such as the source image, dimensions, mode and format.
+type Key struct {
+	ReceiptID int
+	Variant   string
+}
+
+type Entry struct {
+	ContentType string
+	Data        []byte
+}
+
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	DiskHits  uint64 `json:"diskHits"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"maxBytes"`
+
+	DiskEvictions uint64 `json:"diskEvictions"`
+	DiskEntries   int    `json:"diskEntries"`
+	DiskBytes     int64  `json:"diskBytes"`
+	DiskMaxBytes  int64  `json:"diskMaxBytes"`
+}
+
+// Cache is an in-memory LRU bounded by the total size of the cached images,
+// optionally backed by a directory. Every entry is written through to the
+// directory, which is a second LRU with its own byte budget, so entries
+// evicted from memory, or lost on a restart, can still be served from disk.
+// A nil *Cache is valid and caches nothing.
+type Cache struct {
+	maxBytes int64
+	dir      string
+
+	mu        sync.Mutex
+	ll        *list.List
+	items     map[Key]*list.Element
+	byReceipt map[int]map[Key]struct{}
+	size      int64
+
+	// diskMu guards the disk index and is held while files are renamed into
+	// place or removed, so the index matches the directory
+	diskMaxBytes int64
+	diskMu       sync.Mutex
+	diskLL       *list.List
+	diskItems    map[string]*list.Element
+	diskSize     int64
+
+	hits, misses, diskHits, evictions, diskEvictions atomic.Uint64
+}
+
+type item struct {
+	key   Key
+	entry Entry
+}
+
+type diskItem struct {
+	path      string
+	receiptID int
+	size      int64
+}
+
+// New creates a cache holding up to maxBytes of image data in memory. If dir
+// is not empty it is created and used as a second, disk backed tier holding
+// up to diskMaxBytes. Files left in dir by an earlier run are indexed by
+// their modification time and trimmed to the budget.
+func New(maxBytes int64, dir string, diskMaxBytes int64) (*Cache, error) {
+	c := &Cache{
+		maxBytes:     maxBytes,
+		dir:          dir,
+		ll:           list.New(),
+		items:        map[Key]*list.Element{},
+		byReceipt:    map[int]map[Key]struct{}{},
+		diskMaxBytes: diskMaxBytes,
+		diskLL:       list.New(),
+		diskItems:    map[string]*list.Element{},
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		if err := c.loadDisk(); err != nil {
+			return nil, fmt.Errorf("failed to read cache directory: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Cache) Get(key Key) (Entry, bool) {
+	if c == nil {
+		return Entry{}, false
+	}
+
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		entry := el.Value.(*item).entry
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return entry, true
+	}
+	c.mu.Unlock()
+
+	if entry, ok := c.readDisk(key); ok {
+		c.hits.Add(1)
+		c.diskHits.Add(1)
+		c.add(key, entry)
+		return entry, true
+	}
+
+	c.misses.Add(1)
+	return Entry{}, false
+}
+
+func (c *Cache) Set(key Key, entry Entry) {
+	if c == nil {
+		return
+	}
+
+	c.add(key, entry)
+	c.writeDisk(key, entry)
+}
+
+// InvalidateReceipt drops every cached image derived from the receipt.
+func (c *Cache) InvalidateReceipt(receiptID int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	for key := range c.byReceipt[receiptID] {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+	}
+	delete(c.byReceipt, receiptID)
+	c.mu.Unlock()
+
+	if c.dir == "" {
+		return
+	}
+
+	c.diskMu.Lock()
+	defer c.diskMu.Unlock()
+
+	for el := c.diskLL.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*diskItem).receiptID == receiptID {
+			c.forgetDisk(el)
+		}
+		el = next
+	}
+	if err := os.RemoveAll(c.receiptDir(receiptID)); err != nil {
+		log.Printf("failed to remove cached images of receipt %d: %v", receiptID, err)
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+
+	c.mu.Lock()
+	stats := Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		DiskHits:  c.diskHits.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   c.ll.Len(),
+		Bytes:     c.size,
+		MaxBytes:  c.maxBytes,
+	}
+	c.mu.Unlock()
+
+	if c.dir != "" {
+		c.diskMu.Lock()
+		stats.DiskEvictions = c.diskEvictions.Load()
+		stats.DiskEntries = c.diskLL.Len()
+		stats.DiskBytes = c.diskSize
+		stats.DiskMaxBytes = c.diskMaxBytes
+		c.diskMu.Unlock()
+	}
+
+	return stats
+}
+
+// add puts the entry in memory, evicting the least recently used entries
+// to stay within the byte budget. Entries larger than the budget are skipped.
+func (c *Cache) add(key Key, entry Entry) {
+	n := int64(len(entry.Data))
+	if n > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	for c.size+n > c.maxBytes && c.ll.Len() > 0 {
+		c.removeElement(c.ll.Back())
+		c.evictions.Add(1)
+	}
+
+	c.items[key] = c.ll.PushFront(&item{key: key, entry: entry})
+	c.size += n
+
+	if c.byReceipt[key.ReceiptID] == nil {
+		c.byReceipt[key.ReceiptID] = map[Key]struct{}{}
+	}
+	c.byReceipt[key.ReceiptID][key] = struct{}{}
+}
+
+// removeElement must be called with c.mu held.
+func (c *Cache) removeElement(el *list.Element) {
+	it := c.ll.Remove(el).(*item)
+	delete(c.items, it.key)
+	c.size -= int64(len(it.entry.Data))
+
+	if keys := c.byReceipt[it.key.ReceiptID]; keys != nil {
+		delete(keys, it.key)
+		if len(keys) == 0 {
+			delete(c.byReceipt, it.key.ReceiptID)
+		}
+	}
+}
+
+func (c *Cache) receiptDir(receiptID int) string {
+	return filepath.Join(c.dir, strconv.Itoa(receiptID))
+}
+
+func (c *Cache) diskPath(key Key) string {
+	sum := sha256.Sum256([]byte(key.Variant))
+	return filepath.Join(c.receiptDir(key.ReceiptID), hex.EncodeToString(sum[:]))
+}
+
+// Disk entries hold the content type on the first line followed by the data.
+func (c *Cache) readDisk(key Key) (Entry, bool) {
+	if c.dir == "" {
+		return Entry{}, false
+	}
+
+	p := c.diskPath(key)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	c.diskMu.Lock()
+	if el, ok := c.diskItems[p]; ok {
+		c.diskLL.MoveToFront(el)
+	}
+	c.diskMu.Unlock()
+
+	r := bufio.NewReader(bytes.NewReader(b))
+	contentType, err := r.ReadString('\n')
+	if err != nil {
+		return Entry{}, false
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	return Entry{ContentType: contentType[:len(contentType)-1], Data: data}, true
+}
+
+// writeDisk stores the entry and evicts the least recently used files to
+// stay within the disk budget. Entries larger than the budget are skipped.
+func (c *Cache) writeDisk(key Key, entry Entry) {
+	n := int64(len(entry.ContentType) + 1 + len(entry.Data))
+	if c.dir == "" || n > c.diskMaxBytes {
+		return
+	}
+
+	p := c.diskPath(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		log.Printf("failed to write cached image: %v", err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		log.Printf("failed to write cached image: %v", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = fmt.Fprintf(tmp, "%s\n", entry.ContentType)
+	if err == nil {
+		_, err = tmp.Write(entry.Data)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Printf("failed to write cached image: %v", err)
+		return
+	}
+
+	c.diskMu.Lock()
+	defer c.diskMu.Unlock()
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		log.Printf("failed to write cached image: %v", err)
+		return
+	}
+	if el, ok := c.diskItems[p]; ok {
+		c.forgetDisk(el)
+	}
+	c.diskItems[p] = c.diskLL.PushFront(&diskItem{path: p, receiptID: key.ReceiptID, size: n})
+	c.diskSize += n
+	c.trimDisk()
+}
+
+// loadDisk indexes the files already in the directory, oldest last, and
+// removes leftover temporary files.
+func (c *Cache) loadDisk() error {
+	var found []*diskItem
+	modTimes := map[*diskItem]int64{}
+
+	err := filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".tmp-") {
+			return os.Remove(p)
+		}
+
+		receiptID, err := strconv.Atoi(filepath.Base(filepath.Dir(p)))
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		it := &diskItem{path: p, receiptID: receiptID, size: info.Size()}
+		found = append(found, it)
+		modTimes[it] = info.ModTime().UnixNano()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(found, func(i, j int) bool { return modTimes[found[i]] > modTimes[found[j]] })
+
+	c.diskMu.Lock()
+	defer c.diskMu.Unlock()
+
+	for _, it := range found {
+		c.diskItems[it.path] = c.diskLL.PushBack(it)
+		c.diskSize += it.size
+	}
+	c.trimDisk()
+	return nil
+}
+
+// trimDisk must be called with c.diskMu held.
+func (c *Cache) trimDisk() {
+	for c.diskSize > c.diskMaxBytes && c.diskLL.Len() > 0 {
+		el := c.diskLL.Back()
+		if err := os.Remove(el.Value.(*diskItem).path); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to evict cached image: %v", err)
+		}
+		c.forgetDisk(el)
+		c.diskEvictions.Add(1)
+	}
+}
+
+// forgetDisk drops a file from the index. It must be called with c.diskMu
+// held and leaves the file itself alone.
+func (c *Cache) forgetDisk(el *list.Element) {
+	it := c.diskLL.Remove(el).(*diskItem)
+	delete(c.diskItems, it.path)
+	c.diskSize -= it.size
+}
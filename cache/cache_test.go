@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("should evict the least recently used entries", func(t *testing.T) {
+		c, err := New(10, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, b, d := Key{1, "a"}, Key{1, "b"}, Key{2, "d"}
+		c.Set(a, Entry{Data: make([]byte, 4)})
+		c.Set(b, Entry{Data: make([]byte, 4)})
+		c.Get(a) // a is now more recent than b
+		c.Set(d, Entry{Data: make([]byte, 4)})
+
+		if _, ok := c.Get(b); ok {
+			t.Error("expected b to be evicted")
+		}
+		if _, ok := c.Get(a); !ok {
+			t.Error("expected a to be cached")
+		}
+
+		stats := c.Stats()
+		if stats.Bytes != 8 || stats.Evictions != 1 || stats.Hits != 2 || stats.Misses != 1 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	})
+
+	t.Run("should invalidate every variant of a receipt", func(t *testing.T) {
+		c, err := New(100, t.TempDir(), 1<<10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Set(Key{1, "small"}, Entry{Data: []byte("s")})
+		c.Set(Key{1, "large"}, Entry{Data: []byte("l")})
+		c.Set(Key{2, "small"}, Entry{Data: []byte("o")})
+
+		c.InvalidateReceipt(1)
+
+		if _, ok := c.Get(Key{1, "small"}); ok {
+			t.Error("expected receipt 1 to be invalidated")
+		}
+		if _, ok := c.Get(Key{2, "small"}); !ok {
+			t.Error("expected receipt 2 to stay cached")
+		}
+	})
+
+	t.Run("should fall back to the disk tier", func(t *testing.T) {
+		c, err := New(4, t.TempDir(), 1<<10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Set(Key{1, "a"}, Entry{ContentType: "image/png", Data: []byte("aaaa")})
+		c.Set(Key{1, "b"}, Entry{ContentType: "image/png", Data: []byte("bbbb")})
+
+		entry, ok := c.Get(Key{1, "a"})
+		if !ok || entry.ContentType != "image/png" || !bytes.Equal(entry.Data, []byte("aaaa")) {
+			t.Errorf("expected a from disk, got %+v, %t", entry, ok)
+		}
+		if c.Stats().DiskHits != 1 {
+			t.Errorf("expected one disk hit, got %+v", c.Stats())
+		}
+	})
+
+	t.Run("should keep the disk tier within its budget", func(t *testing.T) {
+		dir := t.TempDir()
+
+		// Each entry takes 5 bytes on disk, the content type line and 4 bytes
+		c, err := New(4, dir, 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Set(Key{1, "a"}, Entry{Data: []byte("aaaa")})
+		c.Set(Key{1, "b"}, Entry{Data: []byte("bbbb")})
+		c.Get(Key{1, "a"}) // a is now more recent than b on disk
+		c.Set(Key{2, "c"}, Entry{Data: []byte("cccc")})
+
+		stats := c.Stats()
+		if stats.DiskEntries != 2 || stats.DiskBytes != 10 || stats.DiskEvictions != 1 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+		if _, err := os.Stat(c.diskPath(Key{1, "b"})); !os.IsNotExist(err) {
+			t.Errorf("expected b to be removed from disk, got %v", err)
+		}
+
+		// A new cache picks up what is left on disk
+		reopened, err := New(4, dir, 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats := reopened.Stats(); stats.DiskEntries != 2 || stats.DiskBytes != 10 {
+			t.Errorf("expected the disk tier to be reloaded, got %+v", stats)
+		}
+		if _, ok := reopened.Get(Key{1, "a"}); !ok {
+			t.Error("expected a to be served from disk after a restart")
+		}
+	})
+
+	t.Run("should do nothing when nil", func(t *testing.T) {
+		var c *Cache
+		c.Set(Key{1, "a"}, Entry{Data: []byte("a")})
+		if _, ok := c.Get(Key{1, "a"}); ok {
+			t.Error("expected a nil cache to miss")
+		}
+	})
+}
@@ -3,25 +3,42 @@ package api
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/cache"
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/ocr"
+	"github.com/groshiniprasad/uploady/queue"
+	"github.com/groshiniprasad/uploady/services/job"
+	"github.com/groshiniprasad/uploady/services/rate"
 	"github.com/groshiniprasad/uploady/services/receipt"
+	"github.com/groshiniprasad/uploady/services/upload"
 	"github.com/groshiniprasad/uploady/services/user"
+	"github.com/groshiniprasad/uploady/storage"
 )
 
 type APIServer struct {
 	addr       string
 	db         *sql.DB
+	blobs      storage.BlobStore
 	httpServer *http.Server
+
+	// cancel stops the background workers started by Run
+	cancel context.CancelFunc
+	// jobs runs the background jobs, drained on shutdown
+	jobs *queue.Pool
 }
 
 // NewAPIServer creates a new instance of APIServer
-func NewAPIServer(addr string, db *sql.DB) *APIServer {
+func NewAPIServer(addr string, db *sql.DB, blobs storage.BlobStore) *APIServer {
 	return &APIServer{
-		addr: addr,
-		db:   db,
+		addr:  addr,
+		db:    db,
+		blobs: blobs,
 	}
 }
 
@@ -38,10 +55,63 @@ func (s *APIServer) Run() error {
 	userHandler := user.NewHandler(userStore)
 	userHandler.RegisterRoutes(subrouter)
 
+	imageCache, err := cache.New(configs.Envs.ImageCacheBytes, configs.Envs.ImageCacheDir, configs.Envs.ImageCacheDiskBytes)
+	if err != nil {
+		return err
+	}
+
+	jobStore := job.NewStore(s.db)
+	jobHandler := job.NewHandler(jobStore, userStore)
+	jobHandler.RegisterRoutes(subrouter)
+
+	// Read the receipts' fields from their images when OCR is set up
+	extractor, err := ocr.New(configs.Envs)
+	if err != nil {
+		return err
+	}
+
+	rateStore := rate.NewStore(s.db)
+	rateHandler := rate.NewHandler(rateStore, userStore)
+	rateHandler.RegisterRoutes(subrouter)
+
 	receiptStore := receipt.NewStore(s.db)
-	receiptHandler := receipt.NewHandler(receiptStore, userStore)
+	receiptHandler := receipt.NewHandler(receiptStore, userStore, s.blobs, imageCache, jobStore, extractor, rateStore)
 	receiptHandler.RegisterRoutes(subrouter)
 
+	uploadStore := upload.NewStore(s.db)
+	uploadHandler := upload.NewHandler(uploadStore, userStore, receiptHandler, configs.Envs.UploadDir)
+	uploadHandler.RegisterRoutes(subrouter)
+
+	// Start the background workers
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	purger := receipt.NewPurger(
+		receiptStore,
+		s.blobs,
+		imageCache,
+		time.Duration(configs.Envs.TrashRetentionInSeconds)*time.Second,
+		time.Duration(configs.Envs.TrashPurgeIntervalInSeconds)*time.Second,
+	)
+	go purger.Run(ctx)
+
+	expirer := upload.NewExpirer(
+		uploadHandler,
+		time.Duration(configs.Envs.UploadMaxAgeInSeconds)*time.Second,
+		time.Duration(configs.Envs.UploadExpiryIntervalInSeconds)*time.Second,
+	)
+	go expirer.Run(ctx)
+
+	s.jobs = queue.New(
+		jobStore,
+		int(configs.Envs.JobWorkers),
+		time.Duration(configs.Envs.JobPollIntervalInSeconds)*time.Second,
+		time.Duration(configs.Envs.JobLeaseInSeconds)*time.Second,
+		time.Duration(configs.Envs.JobRetryBackoffInSeconds)*time.Second,
+	)
+	s.jobs.Register(receipt.JobProcessReceipt, receiptHandler.ProcessReceiptJob)
+	s.jobs.Start(ctx)
+
 	// Initialize the HTTP server
 	s.httpServer = &http.Server{
 		Addr:    s.addr,
@@ -58,8 +128,23 @@ func (s *APIServer) Run() error {
 	return nil
 }
 
-// Shutdown gracefully shuts down the server with a timeout
+// Shutdown gracefully shuts down the server with a timeout. Requests in
+// flight are finished first, then the background jobs that are running; jobs
+// still running when ctx is done are picked up again after a restart.
 func (s *APIServer) Shutdown(ctx context.Context) error {
 	log.Println("Shutting down server...")
-	return s.httpServer.Shutdown(ctx)
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	if s.jobs != nil {
+		log.Println("Waiting for background jobs to finish...")
+		if err := s.jobs.Drain(ctx); err != nil {
+			return fmt.Errorf("background jobs did not finish: %w", err)
+		}
+	}
+	return nil
 }
@@ -14,7 +14,7 @@ import (
 	"github.com/groshiniprasad/uploady/cmd/api"
 	"github.com/groshiniprasad/uploady/configs"
 	"github.com/groshiniprasad/uploady/db"
-	"github.com/groshiniprasad/uploady/utils"
+	"github.com/groshiniprasad/uploady/storage"
 )
 
 func main() {
@@ -38,9 +38,15 @@ func main() {
 
 	fmt.Println("Database successfully connected!")
 
+	// Initialize the blob store for receipt images
+	blobs, err := storage.New(configs.Envs)
+	if err != nil {
+		log.Fatalf("Could not initialize storage: %v", err)
+	}
+
 	// Initialize DB connection
 	// Setup API server
-	server := api.NewAPIServer(fmt.Sprintf(":%s", configs.Envs.Port), database)
+	server := api.NewAPIServer(fmt.Sprintf(":%s", configs.Envs.Port), database, blobs)
 
 	// Start server in a goroutine to allow for graceful shutdown
 	go func() {
@@ -49,8 +55,6 @@ func main() {
 		}
 	}()
 
-	utils.CreateUploadsDir()
-
 	// Create a channel to listen for interrupt or terminate signals
 	shutdownChan := make(chan os.Signal, 1)
 	signal.Notify(shutdownChan, os.Interrupt, syscall.SIGTERM)
@@ -59,8 +63,9 @@ func main() {
 	<-shutdownChan
 	log.Println("Shutdown signal received, shutting down gracefully...")
 
-	// Create a deadline for the shutdown (e.g., 5 seconds)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Create a deadline for the shutdown, leaving time for running
+	// background jobs to finish
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(configs.Envs.ShutdownTimeoutInSeconds)*time.Second)
 	defer cancel()
 
 	// Shutdown the server gracefully, ensuring no new connections are accepted
@@ -25,6 +25,8 @@ func main() {
 		Net:                  "tcp",
 		AllowNativePasswords: true,
 		ParseTime:            true,
+		// Some migrations run more than one statement
+		MultiStatements: true,
 	}
 
 	// Open the database connection
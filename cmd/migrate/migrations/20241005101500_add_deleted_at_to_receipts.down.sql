@@ -0,0 +1,3 @@
+ALTER TABLE receipts
+    DROP INDEX `idx_receipts_deletedAt`,
+    DROP COLUMN `deletedAt`;
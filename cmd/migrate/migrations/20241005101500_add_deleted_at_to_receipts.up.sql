@@ -0,0 +1,3 @@
+ALTER TABLE receipts
+    ADD COLUMN `deletedAt` TIMESTAMP NULL DEFAULT NULL,
+    ADD INDEX `idx_receipts_deletedAt` (`deletedAt`);
@@ -0,0 +1,3 @@
+UPDATE receipts SET `imageKey` = CONCAT('./uploads/', `imageKey`);
+
+ALTER TABLE receipts RENAME COLUMN `imageKey` TO `imagePath`;
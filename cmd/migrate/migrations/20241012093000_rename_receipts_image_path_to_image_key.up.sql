@@ -0,0 +1,4 @@
+ALTER TABLE receipts RENAME COLUMN `imagePath` TO `imageKey`;
+
+-- Files were saved as ./uploads/<name>; the local blob store is rooted at ./uploads
+UPDATE receipts SET `imageKey` = SUBSTRING(`imageKey`, LENGTH('./uploads/') + 1) WHERE `imageKey` LIKE './uploads/%';
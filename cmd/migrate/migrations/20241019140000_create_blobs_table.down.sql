@@ -0,0 +1,7 @@
+ALTER TABLE receipts
+    DROP INDEX `idx_receipts_imageDigest_userId`,
+    DROP COLUMN `contentType`,
+    DROP COLUMN `imageSize`,
+    DROP COLUMN `imageDigest`;
+
+DROP TABLE IF EXISTS blobs;
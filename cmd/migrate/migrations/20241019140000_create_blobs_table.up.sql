@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS blobs (
+    `digest` CHAR(64) NOT NULL,
+    `objectKey` VARCHAR(255) NOT NULL,
+    `refCount` INT UNSIGNED NOT NULL DEFAULT 0,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (digest)
+);
+
+ALTER TABLE receipts
+    ADD COLUMN `imageDigest` CHAR(64) NULL,
+    ADD COLUMN `imageSize` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN `contentType` VARCHAR(255) NOT NULL DEFAULT '',
+    ADD INDEX `idx_receipts_imageDigest_userId` (`imageDigest`, `userId`);
@@ -0,0 +1 @@
+ALTER TABLE receipts DROP COLUMN `phash`;
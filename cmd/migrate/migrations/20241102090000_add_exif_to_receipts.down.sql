@@ -0,0 +1,5 @@
+ALTER TABLE receipts
+  DROP COLUMN `orientation`,
+  DROP COLUMN `capturedAt`,
+  DROP COLUMN `cameraWidth`,
+  DROP COLUMN `cameraHeight`;
@@ -0,0 +1,5 @@
+ALTER TABLE receipts
+  ADD COLUMN `orientation` TINYINT UNSIGNED NOT NULL DEFAULT 1,
+  ADD COLUMN `capturedAt` DATETIME NULL,
+  ADD COLUMN `cameraWidth` INT UNSIGNED NOT NULL DEFAULT 0,
+  ADD COLUMN `cameraHeight` INT UNSIGNED NOT NULL DEFAULT 0;
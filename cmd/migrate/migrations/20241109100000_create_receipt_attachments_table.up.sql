@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS receipt_attachments (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `receiptId` INT UNSIGNED NOT NULL,
+    `position` INT UNSIGNED NOT NULL,
+    `imageKey` VARCHAR(255) NOT NULL,
+    `imageDigest` CHAR(64) NULL,
+    `imageSize` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `contentType` VARCHAR(255) NOT NULL DEFAULT '',
+    `phash` BIGINT UNSIGNED NULL,
+    `orientation` TINYINT UNSIGNED NOT NULL DEFAULT 1,
+    `uploadedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+    INDEX `idx_receipt_attachments_receiptId_position` (`receiptId`, `position`),
+    FOREIGN KEY (`receiptId`) REFERENCES receipts(`id`) ON DELETE CASCADE
+);
+
+-- Every existing image becomes its receipt's primary attachment
+INSERT INTO receipt_attachments (receiptId, position, imageKey, imageDigest, imageSize, contentType, phash, orientation, uploadedAt)
+SELECT id, 0, imageKey, imageDigest, imageSize, contentType, phash, orientation, createdAt FROM receipts;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS uploads (
+    `id` CHAR(32) NOT NULL,
+    `userId` INT UNSIGNED NOT NULL,
+    `uploadLength` BIGINT UNSIGNED NOT NULL,
+    `uploadOffset` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `metadata` TEXT NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `kind` VARCHAR(64) NOT NULL,
+    `payload` JSON NOT NULL,
+    `status` VARCHAR(16) NOT NULL DEFAULT 'queued',
+    `attempts` INT UNSIGNED NOT NULL DEFAULT 0,
+    `maxAttempts` INT UNSIGNED NOT NULL DEFAULT 5,
+    `lastError` TEXT,
+    `result` JSON NULL,
+    `runAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `lockedAt` TIMESTAMP NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `finishedAt` TIMESTAMP NULL,
+
+    PRIMARY KEY (id),
+    INDEX `idx_jobs_status_runAt` (`status`, `runAt`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+-- Receipts uploaded so far were processed in the request
+ALTER TABLE receipts
+  ADD COLUMN `processingStatus` VARCHAR(16) NOT NULL DEFAULT 'done';
@@ -0,0 +1,3 @@
+-- Values read from the receipt's image by OCR
+ALTER TABLE receipts
+  ADD COLUMN `suggestions` JSON NULL;
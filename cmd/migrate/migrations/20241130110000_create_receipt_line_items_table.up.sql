@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS receipt_line_items (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `receiptId` INT UNSIGNED NOT NULL,
+    `description` VARCHAR(255) NOT NULL,
+    `quantity` DECIMAL(10, 3) NOT NULL DEFAULT 1,
+    `unitPrice` DECIMAL(10, 2) NOT NULL,
+    `taxRate` DECIMAL(5, 2) NOT NULL DEFAULT 0,
+    `category` VARCHAR(64) NOT NULL DEFAULT '',
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+    INDEX `idx_receipt_line_items_receiptId` (`receiptId`),
+    FOREIGN KEY (`receiptId`) REFERENCES receipts(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+ALTER TABLE receipt_line_items
+  MODIFY COLUMN `unitPrice` DECIMAL(10, 2) NOT NULL;
+
+ALTER TABLE receipts
+  DROP COLUMN `currency`,
+  MODIFY COLUMN `amount` DECIMAL(10, 2) NOT NULL;
@@ -0,0 +1,8 @@
+-- Receipts uploaded so far are in the default currency. Amounts get a third
+-- decimal place for currencies such as the Kuwaiti dinar.
+ALTER TABLE receipts
+  ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `amount`,
+  MODIFY COLUMN `amount` DECIMAL(13, 3) NOT NULL;
+
+ALTER TABLE receipt_line_items
+  MODIFY COLUMN `unitPrice` DECIMAL(13, 3) NOT NULL;
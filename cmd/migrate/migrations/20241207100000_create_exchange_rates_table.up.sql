@@ -0,0 +1,12 @@
+-- One unit of `base` buys `rate` units of `quote` from `date` on, until the
+-- next rate of the pair.
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    `date` DATE NOT NULL,
+    `base` CHAR(3) NOT NULL,
+    `quote` CHAR(3) NOT NULL,
+    `rate` DECIMAL(20, 10) NOT NULL,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`base`, `quote`, `date`),
+    INDEX `idx_exchange_rates_date` (`date`)
+);
@@ -0,0 +1,5 @@
+-- Amounts are converted into the user's home currency in listings and
+-- summaries. Admins manage the exchange rates.
+ALTER TABLE users
+  ADD COLUMN `homeCurrency` CHAR(3) NOT NULL DEFAULT 'USD',
+  ADD COLUMN `isAdmin` BOOLEAN NOT NULL DEFAULT FALSE;
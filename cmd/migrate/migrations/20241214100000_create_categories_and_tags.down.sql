@@ -0,0 +1,7 @@
+ALTER TABLE receipts
+  DROP FOREIGN KEY `fk_receipts_categoryId`,
+  DROP COLUMN `categoryId`;
+
+DROP TABLE IF EXISTS receipt_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS categories;
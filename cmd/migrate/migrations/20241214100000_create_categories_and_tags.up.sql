@@ -0,0 +1,41 @@
+-- Categories nest under a parent of the same user. A receipt has at most one
+-- category and any number of tags.
+CREATE TABLE IF NOT EXISTS categories (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `parentId` INT UNSIGNED NULL,
+    `name` VARCHAR(64) NOT NULL,
+    `colour` CHAR(7) NOT NULL DEFAULT '',
+    `icon` VARCHAR(64) NOT NULL DEFAULT '',
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+    INDEX `idx_categories_userId` (`userId`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`),
+    FOREIGN KEY (`parentId`) REFERENCES categories(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `name` VARCHAR(64) NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+    UNIQUE KEY `uq_tags_userId_name` (`userId`, `name`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS receipt_tags (
+    `receiptId` INT UNSIGNED NOT NULL,
+    `tagId` INT UNSIGNED NOT NULL,
+
+    PRIMARY KEY (`receiptId`, `tagId`),
+    INDEX `idx_receipt_tags_tagId` (`tagId`),
+    FOREIGN KEY (`receiptId`) REFERENCES receipts(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`tagId`) REFERENCES tags(`id`) ON DELETE CASCADE
+);
+
+ALTER TABLE receipts
+  ADD COLUMN `categoryId` INT UNSIGNED NULL AFTER `description`,
+  ADD CONSTRAINT `fk_receipts_categoryId` FOREIGN KEY (`categoryId`) REFERENCES categories(`id`) ON DELETE SET NULL;
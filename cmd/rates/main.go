@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/db"
+	"github.com/groshiniprasad/uploady/services/rate"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+// Imports exchange rates from a CSV file with date,base,quote,rate columns
+// or from a reference rates file of the European Central Bank, replacing
+// the rates already stored for the same pair and day
+func main() {
+	format := flag.String("format", "", `"csv" or "ecb", by default from the file extension`)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-format csv|ecb] FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rates, err := readRates(flag.Arg(0), *format)
+	if err != nil {
+		log.Fatalf("Could not read the rates: %v", err)
+	}
+
+	// MySQL connection configuration
+	cfg := mysql.Config{
+		User:                 configs.Envs.DBUser,
+		Passwd:               configs.Envs.DBPassword,
+		Addr:                 configs.Envs.DBAddress,
+		DBName:               configs.Envs.DBName,
+		Net:                  "tcp",
+		AllowNativePasswords: true,
+		ParseTime:            true,
+	}
+
+	database, err := db.NewMySQLStorage(cfg)
+	if err != nil {
+		log.Fatalf("Could not connect to MySQL: %v", err)
+	}
+	defer database.Close()
+
+	if err := rate.NewStore(database).UpsertExchangeRates(rates); err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+	log.Printf("Imported %d rates.", len(rates))
+}
+
+func readRates(path string, format string) ([]types.ExchangeRate, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if format == "" {
+		format = "csv"
+		if strings.EqualFold(filepath.Ext(path), ".xml") {
+			format = "ecb"
+		}
+	}
+
+	var read func(io.Reader) ([]types.ExchangeRate, error)
+	switch format {
+	case "csv":
+		read = rate.ReadCSV
+	case "ecb":
+		read = rate.ReadECB
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	return read(file)
+}
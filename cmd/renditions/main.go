@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/db"
+	"github.com/groshiniprasad/uploady/services/receipt"
+	"github.com/groshiniprasad/uploady/storage"
+)
+
+// Generates the renditions that are missing for the receipts already stored,
+// e.g. after upgrading or adding a rendition to RENDITIONS
+func main() {
+	force := flag.Bool("force", false, "render existing renditions again, e.g. after changing their size")
+	flag.Parse()
+
+	// MySQL connection configuration
+	cfg := mysql.Config{
+		User:                 configs.Envs.DBUser,
+		Passwd:               configs.Envs.DBPassword,
+		Addr:                 configs.Envs.DBAddress,
+		DBName:               configs.Envs.DBName,
+		Net:                  "tcp",
+		AllowNativePasswords: true,
+		ParseTime:            true,
+	}
+
+	database, err := db.NewMySQLStorage(cfg)
+	if err != nil {
+		log.Fatalf("Could not connect to MySQL: %v", err)
+	}
+	defer database.Close()
+
+	blobs, err := storage.New(configs.Envs)
+	if err != nil {
+		log.Fatalf("Could not initialize storage: %v", err)
+	}
+
+	// Stop between attachments on Ctrl+C, a later run picks up from there
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backfill := receipt.NewRenditionBackfill(receipt.NewStore(database), blobs)
+	stored, err := backfill.Run(ctx, *force)
+	if err != nil {
+		log.Fatalf("Backfill failed after storing %d renditions: %v", stored, err)
+	}
+	log.Printf("Stored %d renditions.", stored)
+}
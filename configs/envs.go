@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/groshiniprasad/uploady/money"
 	"github.com/lpernett/godotenv"
 )
 
@@ -17,6 +19,90 @@ type Config struct {
 	DBName                 string
 	JWTSecret              string
 	JWTExpirationInSeconds int64
+
+	// Trashed receipts are purged for good once they are older than the
+	// retention period. The purger checks every PurgeInterval seconds.
+	TrashRetentionInSeconds     int64
+	TrashPurgeIntervalInSeconds int64
+
+	// StorageBackend selects where receipt images are kept: "local" or "s3"
+	StorageBackend  string
+	StorageLocalDir string
+	S3Endpoint      string
+	S3Region        string
+	S3Bucket        string
+	S3AccessKey     string
+	S3SecretKey     string
+	S3UsePathStyle  bool
+
+	// Receipts whose perceptual hashes differ in at most this many bits are
+	// considered photos of the same receipt
+	PHashThreshold int64
+
+	// DefaultCurrency is the currency of receipts uploaded without one
+	DefaultCurrency money.Currency
+	// Amounts are converted at the latest exchange rate up to this many days
+	// before their date, covering weekends and holidays without rates
+	ExchangeRateMaxAgeInDays int64
+
+	// Upper bound for the width and height of resized receipt images
+	MaxResizeDimension int64
+
+	// Images are only decoded up to MaxImagePixels pixels and MaxImageDimension
+	// pixels in width and height, and at most MaxConcurrentDecodes at a time
+	MaxImagePixels       int64
+	MaxImageDimension    int64
+	MaxConcurrentDecodes int64
+
+	// Resized images are cached in memory up to ImageCacheBytes, and on disk
+	// under ImageCacheDir up to ImageCacheDiskBytes when it is set
+	ImageCacheBytes     int64
+	ImageCacheDir       string
+	ImageCacheDiskBytes int64
+
+	// How long clients may reuse a receipt image without revalidating it
+	ImageMaxAgeInSeconds int64
+
+	// Resumable uploads are written under UploadDir until they are finalized.
+	// Uploads older than UploadMaxAge are removed, checked every
+	// UploadExpiryInterval seconds.
+	UploadDir                     string
+	UploadMaxAgeInSeconds         int64
+	UploadExpiryIntervalInSeconds int64
+
+	// Background jobs run on JobWorkers workers, polling for new jobs every
+	// JobPollInterval seconds. A failed job is retried up to JobMaxAttempts
+	// times, waiting JobRetryBackoff seconds, doubled on every attempt.
+	JobWorkers               int64
+	JobPollIntervalInSeconds int64
+	JobMaxAttempts           int64
+	JobRetryBackoffInSeconds int64
+	// A job running for longer than its lease is presumed abandoned by a
+	// crashed worker and claimed again
+	JobLeaseInSeconds int64
+
+	// How long a shutdown waits for requests and background jobs to finish
+	ShutdownTimeoutInSeconds int64
+
+	// OCR reads suggestions for the receipt fields from the image. OCREngine
+	// is "tesseract" or empty to disable it
+	OCREngine           string
+	TesseractPath       string
+	OCRLanguage         string
+	OCRTimeoutInSeconds int64
+
+	// Renditions are the named image sizes generated after an upload and
+	// stored next to the original
+	Renditions []Rendition
+}
+
+// Rendition is a named image size. The image is scaled to fit within Size
+// pixels on its longest side, or kept at its full size when Size is zero.
+type Rendition struct {
+	Name string
+	Size int64
+	// Enhance crops, straightens and sharpens the contrast of the receipt
+	Enhance bool
 }
 
 var Envs = initConfig()
@@ -31,7 +117,7 @@ func initConfig() Config {
 	}
 
 	// Return the configuration struct with environment variables or default values
-	return Config{
+	cfg := Config{
 		Port:                   getEnv("PORT", "8080"),
 		DBUser:                 getEnv("DB_USER", "root"),
 		DBPassword:             getEnv("DB_PASSWORD", "mypassword"),
@@ -39,7 +125,69 @@ func initConfig() Config {
 		DBName:                 getEnv("DB_NAME", "uploady"),
 		JWTSecret:              getEnv("JWT_SECRET", "kya-secret-chahiye-aapko?"),
 		JWTExpirationInSeconds: getEnvAsInt("JWT_EXPIRATION_IN_SECONDS", 3600*24*7),
+
+		TrashRetentionInSeconds:     getEnvAsInt("TRASH_RETENTION_IN_SECONDS", 3600*24*30),
+		TrashPurgeIntervalInSeconds: getEnvAsInt("TRASH_PURGE_INTERVAL_IN_SECONDS", 3600),
+
+		StorageBackend:  getEnv("STORAGE_BACKEND", "local"),
+		StorageLocalDir: getEnv("STORAGE_LOCAL_DIR", "./uploads"),
+		S3Endpoint:      getEnv("S3_ENDPOINT", ""),
+		S3Region:        getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:        getEnv("S3_BUCKET", ""),
+		S3AccessKey:     getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:     getEnv("S3_SECRET_KEY", ""),
+		S3UsePathStyle:  getEnvAsBool("S3_USE_PATH_STYLE", true),
+
+		PHashThreshold: getEnvAsInt("PHASH_THRESHOLD", 10),
+
+		DefaultCurrency:          getEnvAsCurrency("DEFAULT_CURRENCY", "USD"),
+		ExchangeRateMaxAgeInDays: getEnvAsInt("EXCHANGE_RATE_MAX_AGE_IN_DAYS", 7),
+
+		MaxResizeDimension: getEnvAsInt("MAX_RESIZE_DIMENSION", 4096),
+
+		MaxImagePixels:       getEnvAsInt("MAX_IMAGE_PIXELS", 50_000_000),
+		MaxImageDimension:    getEnvAsInt("MAX_IMAGE_DIMENSION", 20000),
+		MaxConcurrentDecodes: getEnvAsInt("MAX_CONCURRENT_DECODES", 4),
+
+		ImageCacheBytes:     getEnvAsInt("IMAGE_CACHE_BYTES", 64<<20),
+		ImageCacheDir:       getEnv("IMAGE_CACHE_DIR", ""),
+		ImageCacheDiskBytes: getEnvAsInt("IMAGE_CACHE_DISK_BYTES", 1<<30),
+
+		ImageMaxAgeInSeconds: getEnvAsInt("IMAGE_MAX_AGE_IN_SECONDS", 3600*24),
+
+		UploadDir:                     getEnv("UPLOAD_DIR", "./partial-uploads"),
+		UploadMaxAgeInSeconds:         getEnvAsInt("UPLOAD_MAX_AGE_IN_SECONDS", 3600*24),
+		UploadExpiryIntervalInSeconds: getEnvAsInt("UPLOAD_EXPIRY_INTERVAL_IN_SECONDS", 3600),
+
+		JobWorkers:               getEnvAsInt("JOB_WORKERS", 4),
+		JobPollIntervalInSeconds: getEnvAsInt("JOB_POLL_INTERVAL_IN_SECONDS", 1),
+		JobMaxAttempts:           getEnvAsInt("JOB_MAX_ATTEMPTS", 5),
+		JobRetryBackoffInSeconds: getEnvAsInt("JOB_RETRY_BACKOFF_IN_SECONDS", 5),
+		JobLeaseInSeconds:        getEnvAsInt("JOB_LEASE_IN_SECONDS", 600),
+
+		ShutdownTimeoutInSeconds: getEnvAsInt("SHUTDOWN_TIMEOUT_IN_SECONDS", 30),
+
+		OCREngine:           getEnv("OCR_ENGINE", ""),
+		TesseractPath:       getEnv("TESSERACT_PATH", "tesseract"),
+		OCRLanguage:         getEnv("OCR_LANGUAGE", "eng"),
+		OCRTimeoutInSeconds: getEnvAsInt("OCR_TIMEOUT_IN_SECONDS", 30),
+
+		Renditions: getEnvAsRenditions("RENDITIONS", []Rendition{
+			{Name: "thumb", Size: 150},
+			{Name: "preview", Size: 800},
+			{Name: "full"},
+		}),
+	}
+
+	// The enhanced rendition is opt-in, as it costs more than a resize
+	if getEnvAsBool("ENHANCE_RECEIPTS", false) {
+		cfg.Renditions = append(cfg.Renditions, Rendition{
+			Name:    "enhanced",
+			Size:    getEnvAsInt("ENHANCED_RENDITION_SIZE", 1600),
+			Enhance: true,
+		})
 	}
+	return cfg
 }
 
 // Gets the env by key or fallbacks to the default value
@@ -50,6 +198,20 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvAsCurrency retrieves an ISO 4217 currency code, logging a warning and
+// returning the fallback for unknown codes.
+func getEnvAsCurrency(key string, fallback money.Currency) money.Currency {
+	if value, ok := os.LookupEnv(key); ok {
+		currency, err := money.ParseCurrency(value)
+		if err != nil {
+			log.Printf("Warning: Environment variable %s is not a known currency, using default value %s", key, fallback)
+			return fallback
+		}
+		return currency
+	}
+	return fallback
+}
+
 // getEnvAsInt retrieves an integer environment variable or falls back to the default value.
 // If the value cannot be converted to an int, it logs a warning and returns the fallback.
 func getEnvAsInt(key string, fallback int64) int64 {
@@ -63,3 +225,63 @@ func getEnvAsInt(key string, fallback int64) int64 {
 	}
 	return fallback
 }
+
+// getEnvAsBool retrieves a boolean environment variable or falls back to the default value.
+// If the value cannot be parsed as a bool, it logs a warning and returns the fallback.
+func getEnvAsBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Warning: Environment variable %s is not a valid boolean, using default value %t", key, fallback)
+			return fallback
+		}
+		return b
+	}
+	return fallback
+}
+
+// getEnvAsRenditions reads renditions written as a comma separated list of
+// name:size pairs, e.g. "thumb:150,preview:800,full", where a name without a
+// size keeps the full size. If the value cannot be parsed, it logs a warning
+// and returns the fallback.
+func getEnvAsRenditions(key string, fallback []Rendition) []Rendition {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	var renditions []Rendition
+	seen := map[string]bool{}
+	for _, part := range strings.Split(value, ",") {
+		name, size, _ := strings.Cut(strings.TrimSpace(part), ":")
+		r := Rendition{Name: name}
+		if size != "" {
+			n, err := strconv.ParseInt(size, 10, 64)
+			if err != nil || n < 0 {
+				log.Printf("Warning: Environment variable %s has an invalid size for %q, using the default renditions", key, name)
+				return fallback
+			}
+			r.Size = n
+		}
+		if !isRenditionName(name) || seen[name] {
+			log.Printf("Warning: Environment variable %s has an invalid or repeated name %q, using the default renditions", key, name)
+			return fallback
+		}
+		seen[name] = true
+		renditions = append(renditions, r)
+	}
+	return renditions
+}
+
+// isRenditionName accepts the names that are safe in a storage key.
+func isRenditionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestDHash(t *testing.T) {
+	original := gradient(400, 300, false)
+
+	t.Run("should match a rescaled copy", func(t *testing.T) {
+		scaled := image.NewRGBA(image.Rect(0, 0, 123, 97))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), original, original.Bounds(), draw.Src, nil)
+
+		if d := HammingDistance(DHash(original), DHash(scaled)); d > 4 {
+			t.Errorf("expected a small distance for a rescaled copy, got %d", d)
+		}
+	})
+
+	t.Run("should differ for a different image", func(t *testing.T) {
+		other := gradient(400, 300, true)
+
+		if d := HammingDistance(DHash(original), DHash(other)); d < 20 {
+			t.Errorf("expected a large distance for a different image, got %d", d)
+		}
+	})
+}
+
+// gradient draws horizontal stripes of varying brightness, optionally
+// mirrored left to right.
+func gradient(w, h int, mirror bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := (x*255/w + (y/40)*70) % 256
+			if mirror {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	return img
+}
@@ -0,0 +1,154 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is an output encoding for served images.
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatGIF  Format = "gif"
+	// FormatWebP is accepted, but as there is no pure Go WebP encoder it is
+	// served losslessly as PNG instead.
+	FormatWebP Format = "webp"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "jpeg", "jpg":
+		return FormatJPEG, nil
+	case "png":
+		return FormatPNG, nil
+	case "gif":
+		return FormatGIF, nil
+	case "webp":
+		return FormatWebP, nil
+	default:
+		return "", fmt.Errorf("invalid format %q, expected jpeg, png, gif or webp", s)
+	}
+}
+
+// FormatForContentType maps a MIME type onto a Format.
+func FormatForContentType(contentType string) (Format, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+
+	switch mediaType {
+	case "image/jpeg":
+		return FormatJPEG, true
+	case "image/png":
+		return FormatPNG, true
+	case "image/gif":
+		return FormatGIF, true
+	case "image/webp":
+		return FormatWebP, true
+	default:
+		return "", false
+	}
+}
+
+// Encoded is the format actually written, after any fallback.
+func (f Format) Encoded() Format {
+	if f == FormatWebP {
+		return FormatPNG
+	}
+	return f
+}
+
+func (f Format) ContentType() string {
+	return "image/" + string(f.Encoded())
+}
+
+// Lossy reports whether a quality setting applies to the format.
+func (f Format) Lossy() bool {
+	return f.Encoded() == FormatJPEG
+}
+
+// Encode writes img in format f. quality only applies to lossy formats; zero
+// selects the encoder's default.
+func Encode(w io.Writer, img image.Image, f Format, quality int) error {
+	switch f.Encoded() {
+	case FormatJPEG:
+		if quality <= 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatGIF:
+		return gif.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("unsupported format %q", f)
+	}
+}
+
+// Negotiate picks an output format from an Accept header. The original format
+// wins whenever the client accepts it, so PNG scans stay lossless; otherwise
+// the most preferred supported type is used. It reports false when the client
+// accepts none of the supported formats.
+func Negotiate(accept string, original Format) (Format, bool) {
+	if original == "" {
+		original = FormatJPEG
+	}
+	if strings.TrimSpace(accept) == "" {
+		return original, true
+	}
+
+	type offer struct {
+		mediaType string
+		q         float64
+	}
+
+	var offers []offer
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		offers = append(offers, offer{mediaType, q})
+	}
+
+	// Best offers first; the stable sort keeps the client's order on ties
+	sort.SliceStable(offers, func(i, j int) bool { return offers[i].q > offers[j].q })
+
+	for _, o := range offers {
+		if o.q <= 0 {
+			continue
+		}
+		if o.mediaType == "*/*" || o.mediaType == "image/*" || o.mediaType == "image/"+string(original) {
+			return original, true
+		}
+	}
+
+	for _, o := range offers {
+		if o.q <= 0 {
+			continue
+		}
+		if f, ok := FormatForContentType(o.mediaType); ok {
+			return f, true
+		}
+	}
+
+	return "", false
+}
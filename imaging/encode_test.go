@@ -0,0 +1,33 @@
+package imaging
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept   string
+		original Format
+		want     Format
+		ok       bool
+	}{
+		{"", FormatPNG, FormatPNG, true},
+		{"*/*", FormatPNG, FormatPNG, true},
+		{"image/webp, image/png;q=0.8", FormatPNG, FormatPNG, true},
+		{"image/webp, image/jpeg;q=0.8", FormatPNG, FormatWebP, true},
+		{"image/gif;q=0.2, image/jpeg;q=0.9", FormatPNG, FormatJPEG, true},
+		{"image/png;q=0, image/jpeg", FormatPNG, FormatJPEG, true},
+		{"application/json", FormatPNG, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := Negotiate(tt.accept, tt.original)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Negotiate(%q, %q) = %q, %t; want %q, %t", tt.accept, tt.original, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWebPFallback(t *testing.T) {
+	if ct := FormatWebP.ContentType(); ct != "image/png" {
+		t.Errorf("expected webp to be served as image/png, got %q", ct)
+	}
+}
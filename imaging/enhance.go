@@ -0,0 +1,284 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+const (
+	// enhanceAnalysisSize bounds the copy the paper is looked for in; its
+	// outline needs no detail
+	enhanceAnalysisSize = 512
+	// maxSkew is the largest tilt corrected, in degrees. Anything larger is
+	// more likely a misdetection than a tilted photo
+	maxSkew = 20
+	// minSkew is the smallest tilt worth resampling the image for
+	minSkew = 0.5
+	// minElongation is the ratio the paper's axes must differ by for its
+	// orientation to be told apart
+	minElongation = 1.2
+	// minPaperContrast is how much brighter than the background, in grey
+	// levels, the paper must be on average to be looked for
+	minPaperContrast = 48
+)
+
+// Enhance prepares a photographed receipt for reading: it finds the paper
+// where it stands out brighter than the background, straightens and crops
+// it, converts it to greyscale and stretches its contrast. Images without
+// a recognisable paper edge are only converted and stretched.
+func Enhance(img image.Image) *image.Gray {
+	gray := toGray(img)
+
+	small := shrinkGray(gray, enhanceAnalysisSize)
+	threshold, contrast := otsuThreshold(small)
+	if contrast < minPaperContrast {
+		return stretchContrast(gray)
+	}
+
+	if angle, ok := skewAngle(small, threshold); ok && math.Abs(angle) >= minSkew {
+		gray = rotateGray(gray, -angle)
+		small = shrinkGray(gray, enhanceAnalysisSize)
+	}
+
+	if bounds, ok := paperBounds(small, threshold); ok {
+		// Map the bounds found in the small copy onto the full image
+		sx := float64(gray.Bounds().Dx()) / float64(small.Bounds().Dx())
+		sy := float64(gray.Bounds().Dy()) / float64(small.Bounds().Dy())
+		crop := image.Rect(
+			int(float64(bounds.Min.X)*sx), int(float64(bounds.Min.Y)*sy),
+			int(math.Ceil(float64(bounds.Max.X)*sx)), int(math.Ceil(float64(bounds.Max.Y)*sy)),
+		).Intersect(gray.Bounds())
+		gray = gray.SubImage(crop).(*image.Gray)
+	}
+
+	return stretchContrast(gray)
+}
+
+func toGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, b.Min, draw.Src)
+	return gray
+}
+
+// shrinkGray scales an image down to fit within size pixels.
+func shrinkGray(gray *image.Gray, size int) *image.Gray {
+	b := gray.Bounds()
+	if max(b.Dx(), b.Dy()) <= size {
+		return gray
+	}
+
+	w, h := fitSize(b.Dx(), b.Dy(), size, size)
+	small := image.NewGray(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), gray, b, draw.Src, nil)
+	return small
+}
+
+// otsuThreshold picks the grey level that best separates the image into a
+// dark and a bright class, the background and the paper, and returns how far
+// apart the mean levels of the two classes are.
+func otsuThreshold(gray *image.Gray) (uint8, float64) {
+	var hist [256]int
+	b := gray.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hist[gray.GrayAt(x, y).Y]++
+		}
+	}
+
+	total := b.Dx() * b.Dy()
+	sum := 0.0
+	for i, n := range hist {
+		sum += float64(i * n)
+	}
+
+	var best uint8
+	var bestVariance, bestContrast, sumDark float64
+	dark := 0
+	for i, n := range hist {
+		dark += n
+		if dark == 0 {
+			continue
+		}
+		bright := total - dark
+		if bright == 0 {
+			break
+		}
+		sumDark += float64(i * n)
+
+		meanDark := sumDark / float64(dark)
+		meanBright := (sum - sumDark) / float64(bright)
+		variance := float64(dark) * float64(bright) * (meanDark - meanBright) * (meanDark - meanBright)
+		if variance > bestVariance {
+			best, bestVariance, bestContrast = uint8(i), variance, meanBright-meanDark
+		}
+	}
+	return best, bestContrast
+}
+
+// skewAngle estimates the tilt of the paper in degrees from the orientation
+// of the bright pixels' principal axis, relative to the nearest image axis.
+// It reports false when the paper is too square or too tilted to tell.
+func skewAngle(gray *image.Gray, threshold uint8) (float64, bool) {
+	var n, sx, sy, sxx, syy, sxy float64
+	b := gray.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if gray.GrayAt(x, y).Y <= threshold {
+				continue
+			}
+			fx, fy := float64(x), float64(y)
+			n++
+			sx, sy = sx+fx, sy+fy
+			sxx, syy, sxy = sxx+fx*fx, syy+fy*fy, sxy+fx*fy
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+
+	// Central second moments of the bright region
+	mx, my := sx/n, sy/n
+	mu20 := sxx/n - mx*mx
+	mu02 := syy/n - my*my
+	mu11 := sxy/n - mx*my
+
+	spread := math.Hypot((mu20-mu02)/2, mu11)
+	major, minor := (mu20+mu02)/2+spread, (mu20+mu02)/2-spread
+	if minor <= 0 || major/minor < minElongation*minElongation {
+		return 0, false
+	}
+
+	angle := 0.5 * math.Atan2(2*mu11, mu20-mu02) * 180 / math.Pi
+	switch {
+	case angle > 45:
+		angle -= 90
+	case angle < -45:
+		angle += 90
+	}
+	if math.Abs(angle) > maxSkew {
+		return 0, false
+	}
+	return angle, true
+}
+
+// rotateGray rotates an image by degrees around its centre onto a black
+// canvas large enough to hold all of it.
+func rotateGray(gray *image.Gray, degrees float64) *image.Gray {
+	b := gray.Bounds()
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	w, h := float64(b.Dx()), float64(b.Dy())
+	dw := math.Ceil(math.Abs(w*cos) + math.Abs(h*sin))
+	dh := math.Ceil(math.Abs(w*sin) + math.Abs(h*cos))
+
+	dst := image.NewGray(image.Rect(0, 0, int(dw), int(dh)))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	// Move the source centre to the origin, rotate, then move it to the
+	// centre of the canvas
+	cx, cy := float64(b.Min.X)+w/2, float64(b.Min.Y)+h/2
+	s2d := f64.Aff3{
+		cos, -sin, dw/2 - cos*cx + sin*cy,
+		sin, cos, dh/2 - sin*cx - cos*cy,
+	}
+	draw.BiLinear.Transform(dst, s2d, gray, b, draw.Src, nil)
+	return dst
+}
+
+// paperBounds finds the rows and columns where the bright pixels make up a
+// good part of the paper. It reports false when the bright region covers
+// too little or nearly all of the image to be a paper on a background.
+func paperBounds(gray *image.Gray, threshold uint8) (image.Rectangle, bool) {
+	b := gray.Bounds()
+	rows := make([]int, b.Dy())
+	cols := make([]int, b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if gray.GrayAt(x, y).Y > threshold {
+				rows[y-b.Min.Y]++
+				cols[x-b.Min.X]++
+			}
+		}
+	}
+
+	top, bottom, ok := denseRange(rows)
+	if !ok {
+		return image.Rectangle{}, false
+	}
+	left, right, _ := denseRange(cols)
+
+	paper := image.Rect(b.Min.X+left, b.Min.Y+top, b.Min.X+right+1, b.Min.Y+bottom+1)
+	coverage := float64(paper.Dx()*paper.Dy()) / float64(b.Dx()*b.Dy())
+	if coverage < 0.05 || coverage > 0.95 {
+		return image.Rectangle{}, false
+	}
+	return paper, true
+}
+
+// denseRange returns the first and last index whose count reaches a quarter
+// of the largest count, ignoring specks of bright background.
+func denseRange(counts []int) (int, int, bool) {
+	peak := 0
+	for _, c := range counts {
+		peak = max(peak, c)
+	}
+	if peak == 0 {
+		return 0, 0, false
+	}
+
+	first, last := -1, -1
+	for i, c := range counts {
+		if 4*c >= peak {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	return first, last, true
+}
+
+// stretchContrast spreads the grey levels between the 1st and the 99th
+// percentile over the full range, so faded print turns black on white.
+// Images of nearly a single level are left alone.
+func stretchContrast(gray *image.Gray) *image.Gray {
+	b := gray.Bounds()
+	out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), gray, b.Min, draw.Src)
+
+	var hist [256]int
+	for _, p := range out.Pix {
+		hist[p]++
+	}
+
+	lo, hi := percentile(hist, len(out.Pix), 0.01), percentile(hist, len(out.Pix), 0.99)
+	if hi-lo < 16 {
+		return out
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := (i - lo) * 255 / (hi - lo)
+		lut[i] = uint8(min(max(v, 0), 255))
+	}
+	for i, p := range out.Pix {
+		out.Pix[i] = lut[p]
+	}
+	return out
+}
+
+func percentile(hist [256]int, total int, p float64) int {
+	target := int(p * float64(total))
+	seen := 0
+	for i, n := range hist {
+		seen += n
+		if seen > target {
+			return i
+		}
+	}
+	return 255
+}
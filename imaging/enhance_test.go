@@ -0,0 +1,74 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// testTiltedReceipt draws a w x h receipt with a few dark text lines, tilted
+// by degrees, onto a dark table.
+func testTiltedReceipt(w, h int, degrees float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 400))
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x++ {
+			// Turn the pixel back into the receipt's own coordinates
+			dx, dy := float64(x)-200, float64(y)-200
+			rx, ry := cos*dx+sin*dy, -sin*dx+cos*dy
+
+			c := color.RGBA{60, 50, 40, 255}
+			if math.Abs(rx) < float64(w)/2 && math.Abs(ry) < float64(h)/2 {
+				c = color.RGBA{200, 195, 180, 255}
+				if int(ry+float64(h)/2)%20 < 3 && math.Abs(rx) < float64(w)/3 {
+					c = color.RGBA{90, 90, 90, 255}
+				}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEnhance(t *testing.T) {
+	t.Run("should straighten and crop a tilted receipt", func(t *testing.T) {
+		img := testTiltedReceipt(120, 240, 10)
+
+		gray := toGray(img)
+		threshold, _ := otsuThreshold(gray)
+		angle, ok := skewAngle(gray, threshold)
+		if !ok || math.Abs(angle-10) > 1 {
+			t.Fatalf("expected a tilt of about 10 degrees, got %.1f, %t", angle, ok)
+		}
+
+		enhanced := Enhance(img)
+		if b := enhanced.Bounds(); math.Abs(float64(b.Dx()-120)) > 12 || math.Abs(float64(b.Dy()-240)) > 12 {
+			t.Errorf("expected about 120x240, got %dx%d", b.Dx(), b.Dy())
+		}
+	})
+
+	t.Run("should stretch the contrast", func(t *testing.T) {
+		enhanced := Enhance(testTiltedReceipt(120, 240, 0))
+
+		var lo, hi uint8 = 255, 0
+		for _, p := range enhanced.Pix {
+			lo, hi = min(lo, p), max(hi, p)
+		}
+		if lo > 10 || hi < 245 {
+			t.Errorf("expected the full range, got %d to %d", lo, hi)
+		}
+	})
+
+	t.Run("should keep images without a paper edge whole", func(t *testing.T) {
+		img := image.NewGray(image.Rect(0, 0, 50, 80))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(100 + i%50)
+		}
+
+		if b := Enhance(img).Bounds(); b.Dx() != 50 || b.Dy() != 80 {
+			t.Errorf("expected 50x80, got %dx%d", b.Dx(), b.Dy())
+		}
+	})
+}
@@ -0,0 +1,369 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNoExif = errors.New("no exif metadata")
+
+// Metadata holds the EXIF fields kept from an uploaded photo.
+type Metadata struct {
+	// Orientation is the EXIF orientation, 1 to 8, where 1 is upright
+	Orientation int
+	// CapturedAt is when the photo was taken, zero when unknown
+	CapturedAt time.Time
+	// Width and Height are the dimensions reported by the camera
+	Width  int
+	Height int
+}
+
+const (
+	markerSOI   = 0xd8
+	markerSOS   = 0xda
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP14 = 0xee
+	markerAPP15 = 0xef
+	markerCOM   = 0xfe
+
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagPixelXDimension    = 0xa002
+	tagPixelYDimension    = 0xa003
+	tagInteropIFD         = 0xa005
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// ReadExif reads the EXIF metadata of a JPEG. It returns ErrNoExif when the
+// image carries none.
+func ReadExif(r io.Reader) (*Metadata, error) {
+	br := bufio.NewReader(r)
+	if err := readSOI(br); err != nil {
+		return nil, err
+	}
+
+	for {
+		marker, payload, err := readSegment(br)
+		if err != nil {
+			return nil, err
+		}
+		if marker == markerSOS {
+			return nil, ErrNoExif
+		}
+		if marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			return parseTIFF(payload[len(exifHeader):])
+		}
+	}
+}
+
+// StripJPEGMetadata copies a JPEG from r to w without its metadata segments,
+// dropping EXIF (including GPS position and camera details), XMP, IPTC and
+// comments. JFIF, ICC colour profiles and Adobe colour transforms are kept,
+// and the orientation is written back in a minimal EXIF segment when the
+// image is not upright. The compressed image data is copied untouched.
+func StripJPEGMetadata(w io.Writer, r io.Reader, orientation int) error {
+	br := bufio.NewReader(r)
+	if err := readSOI(br); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{0xff, markerSOI}); err != nil {
+		return err
+	}
+
+	wroteExif := orientation <= 1 || orientation > 8
+	for {
+		marker, payload, err := readSegment(br)
+		if err != nil {
+			return err
+		}
+
+		// JFIF must stay directly after SOI, the orientation follows it
+		if !wroteExif && marker != markerAPP0 {
+			if err := writeSegment(w, markerAPP1, orientationExif(orientation)); err != nil {
+				return err
+			}
+			wroteExif = true
+		}
+
+		if marker == markerSOS {
+			if err := writeSegment(w, marker, payload); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, br)
+			return err
+		}
+
+		if isMetadata(marker) {
+			continue
+		}
+
+		if err := writeSegment(w, marker, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// isMetadata reports whether a segment is dropped by StripJPEGMetadata.
+func isMetadata(marker byte) bool {
+	switch marker {
+	case markerAPP0, markerAPP2, markerAPP14:
+		return false
+	case markerCOM:
+		return true
+	default:
+		return marker >= markerAPP0 && marker <= markerAPP15
+	}
+}
+
+func readSOI(br *bufio.Reader) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xff || soi[1] != markerSOI {
+		return errors.New("not a jpeg image")
+	}
+	return nil
+}
+
+// readSegment reads the next marker and its payload. Markers without a
+// payload return a nil payload.
+func readSegment(br *bufio.Reader) (byte, []byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if b != 0xff {
+		return 0, nil, fmt.Errorf("invalid jpeg marker %#x", b)
+	}
+
+	// Markers may be preceded by any number of fill bytes
+	marker := byte(0xff)
+	for marker == 0xff {
+		if marker, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if marker == 0x01 || (marker >= 0xd0 && marker <= markerSOI) {
+		return marker, nil, nil
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(br, length[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(length[:]))
+	if n < 2 {
+		return 0, nil, fmt.Errorf("invalid jpeg segment length %d", n)
+	}
+
+	payload := make([]byte, n-2)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return 0, nil, err
+	}
+	return marker, payload, nil
+}
+
+func writeSegment(w io.Writer, marker byte, payload []byte) error {
+	if payload == nil {
+		_, err := w.Write([]byte{0xff, marker})
+		return err
+	}
+
+	header := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// orientationExif is an EXIF payload holding nothing but the orientation.
+func orientationExif(orientation int) []byte {
+	b := append([]byte{}, exifHeader...)
+	b = append(b, 'M', 'M', 0, 42, 0, 0, 0, 8) // big endian, IFD0 at offset 8
+	b = append(b, 0, 1)                        // one entry
+	b = binary.BigEndian.AppendUint16(b, tagOrientation)
+	b = append(b, 0, 3, 0, 0, 0, 1) // one SHORT
+	b = binary.BigEndian.AppendUint16(b, uint16(orientation))
+	b = append(b, 0, 0, 0, 0, 0, 0) // padding, no next IFD
+	return b
+}
+
+// tiff reads values out of the TIFF structure of an EXIF segment.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func parseTIFF(data []byte) (*Metadata, error) {
+	if len(data) < 8 {
+		return nil, errors.New("truncated exif data")
+	}
+
+	t := tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid exif byte order")
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, errors.New("invalid exif header")
+	}
+
+	ifd0, err := t.readIFD(t.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{Orientation: 1}
+	var dateTime, dateTimeOriginal, offsetTimeOriginal string
+
+	for _, e := range ifd0 {
+		switch e.tag {
+		case tagOrientation:
+			if o := t.uint(e); o >= 1 && o <= 8 {
+				meta.Orientation = o
+			}
+		case tagDateTime:
+			dateTime = t.string(e)
+		case tagExifIFD:
+			sub, err := t.readIFD(uint32(t.uint(e)))
+			if err != nil {
+				continue
+			}
+			for _, e := range sub {
+				switch e.tag {
+				case tagDateTimeOriginal:
+					dateTimeOriginal = t.string(e)
+				case tagOffsetTimeOriginal:
+					offsetTimeOriginal = t.string(e)
+				case tagPixelXDimension:
+					meta.Width = t.uint(e)
+				case tagPixelYDimension:
+					meta.Height = t.uint(e)
+				}
+			}
+		}
+	}
+
+	if dateTimeOriginal != "" {
+		meta.CapturedAt = parseExifTime(dateTimeOriginal, offsetTimeOriginal)
+	}
+	if meta.CapturedAt.IsZero() && dateTime != "" {
+		meta.CapturedAt = parseExifTime(dateTime, "")
+	}
+
+	return meta, nil
+}
+
+func (t tiff) readIFD(offset uint32) ([]ifdEntry, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, errors.New("exif directory out of range")
+	}
+
+	n := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(t.data) {
+		return nil, errors.New("exif directory out of range")
+	}
+
+	entries := make([]ifdEntry, 0, n)
+	for i := 0; i < n; i++ {
+		raw := t.data[start+i*12 : start+i*12+12]
+		e := ifdEntry{
+			tag:   t.order.Uint16(raw),
+			typ:   t.order.Uint16(raw[2:]),
+			count: t.order.Uint32(raw[4:]),
+		}
+
+		size := uint64(typeSize(e.typ)) * uint64(e.count)
+		if size == 0 {
+			continue
+		}
+		if size <= 4 {
+			e.value = raw[8 : 8+size]
+		} else {
+			off := uint64(t.order.Uint32(raw[8:]))
+			if off+size > uint64(len(t.data)) {
+				continue
+			}
+			e.value = t.data[off : off+size]
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// uint reads a SHORT or LONG value.
+func (t tiff) uint(e ifdEntry) int {
+	switch e.typ {
+	case 3:
+		return int(t.order.Uint16(e.value))
+	case 4:
+		return int(t.order.Uint32(e.value))
+	default:
+		return 0
+	}
+}
+
+func (t tiff) string(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(e.value), "\x00 ")
+}
+
+func typeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// parseExifTime parses "2006:01:02 15:04:05" with an optional "-07:00"
+// offset. Without an offset the time is taken as UTC.
+func parseExifTime(s, offset string) time.Time {
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", s+offset); err == nil {
+			return t
+		}
+	}
+
+	t, err := time.Parse("2006:01:02 15:04:05", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,140 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// exifJPEG is a 4x2 JPEG with an EXIF segment holding the orientation, a
+// capture time, camera dimensions and an (empty) GPS directory.
+func exifJPEG(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	entry := func(b []byte, tag, typ uint16, count, value uint32) []byte {
+		b = le.AppendUint16(b, tag)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, count)
+		return le.AppendUint32(b, value)
+	}
+
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	// IFD0 at 8
+	tiff = le.AppendUint16(tiff, 3)
+	tiff = entry(tiff, tagOrientation, 3, 1, uint32(orientation))
+	tiff = entry(tiff, tagExifIFD, 4, 1, 50)
+	tiff = entry(tiff, 0x8825, 4, 1, 92)
+	tiff = le.AppendUint32(tiff, 0)
+	// Exif IFD at 50
+	tiff = le.AppendUint16(tiff, 3)
+	tiff = entry(tiff, tagDateTimeOriginal, 2, 20, 98)
+	tiff = entry(tiff, tagPixelXDimension, 4, 1, 4000)
+	tiff = entry(tiff, tagPixelYDimension, 4, 1, 3000)
+	tiff = le.AppendUint32(tiff, 0)
+	// GPS IFD at 92
+	tiff = le.AppendUint16(tiff, 0)
+	tiff = le.AppendUint32(tiff, 0)
+	// Capture time at 98
+	tiff = append(tiff, "2024:10:05 14:30:00\x00"...)
+
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:2])
+	if err := writeSegment(&out, markerAPP1, append(append([]byte{}, exifHeader...), tiff...)); err != nil {
+		t.Fatal(err)
+	}
+	out.Write(img.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestReadExif(t *testing.T) {
+	t.Run("should read the kept fields", func(t *testing.T) {
+		meta, err := ReadExif(bytes.NewReader(exifJPEG(t, 6)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if meta.Orientation != 6 {
+			t.Errorf("expected orientation 6, got %d", meta.Orientation)
+		}
+		if want := time.Date(2024, 10, 5, 14, 30, 0, 0, time.UTC); !meta.CapturedAt.Equal(want) {
+			t.Errorf("expected capture time %v, got %v", want, meta.CapturedAt)
+		}
+		if meta.Width != 4000 || meta.Height != 3000 {
+			t.Errorf("expected 4000x3000, got %dx%d", meta.Width, meta.Height)
+		}
+	})
+
+	t.Run("should report images without exif", func(t *testing.T) {
+		var img bytes.Buffer
+		if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 2)), nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadExif(&img); err != ErrNoExif {
+			t.Errorf("expected ErrNoExif, got %v", err)
+		}
+	})
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	var out bytes.Buffer
+	if err := StripJPEGMetadata(&out, bytes.NewReader(exifJPEG(t, 6)), 6); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := ReadExif(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Orientation != 6 || !meta.CapturedAt.IsZero() || meta.Width != 0 {
+		t.Errorf("expected only the orientation to be kept, got %+v", meta)
+	}
+
+	if _, err := jpeg.Decode(&out); err != nil {
+		t.Errorf("expected the stripped image to decode: %v", err)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 2x1 image with a red pixel on the left
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+
+	red := func(img image.Image, x, y int) bool {
+		r, g, _, _ := img.At(x, y).RGBA()
+		return r == 0xffff && g == 0
+	}
+
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		x, y        int
+	}{
+		{1, 2, 1, 0, 0},
+		{2, 2, 1, 1, 0},
+		{3, 2, 1, 1, 0},
+		{6, 1, 2, 0, 0},
+		{8, 1, 2, 0, 1},
+	}
+
+	for _, tt := range tests {
+		dst := Orient(src, tt.orientation)
+		if b := dst.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("orientation %d: expected %dx%d, got %dx%d", tt.orientation, tt.width, tt.height, b.Dx(), b.Dy())
+			continue
+		}
+		if !red(dst, tt.x, tt.y) {
+			t.Errorf("orientation %d: expected the red pixel at %d,%d", tt.orientation, tt.x, tt.y)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package imaging holds the image processing applied to receipt images.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	// Register the decoders for the formats receipts can be uploaded in
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrNoPreview is returned for receipts that are stored as uploaded but
+// cannot be turned into an image, such as HEIC photos or PDFs without an
+// embedded page image.
+var ErrNoPreview = errors.New("no preview can be rendered for this file")
+
+// ErrTooLarge is returned for images whose dimensions exceed the Limits.
+// It is reported from the image header, before any pixels are allocated.
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+// Limits bounds the images that are decoded, so that a small file declaring
+// a huge canvas cannot exhaust the memory. Zero values are unlimited.
+type Limits struct {
+	// MaxPixels bounds the width times the height
+	MaxPixels int64
+	// MaxDimension bounds the width and the height
+	MaxDimension int
+}
+
+// Check returns an error wrapping ErrTooLarge if an image of the given size
+// exceeds the limits.
+func (l Limits) Check(width, height int) error {
+	if l.MaxDimension > 0 && (width > l.MaxDimension || height > l.MaxDimension) {
+		return fmt.Errorf("%w: %dx%d pixels, the width and height must not exceed %d pixels", ErrTooLarge, width, height, l.MaxDimension)
+	}
+	if l.MaxPixels > 0 && int64(width)*int64(height) > l.MaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels, the image must not exceed %d pixels", ErrTooLarge, width, height, l.MaxPixels)
+	}
+	return nil
+}
+
+// Decode decodes an uploaded receipt of the given content type. PDFs are
+// decoded into a preview of their first page. The dimensions are checked
+// against the limits before decoding.
+func Decode(r io.Reader, contentType string, limits Limits) (image.Image, error) {
+	switch contentType {
+	case "application/pdf":
+		return PDFPreview(r, limits)
+	case "image/heic", "image/heif":
+		// There is no pure Go HEIC decoder
+		return nil, ErrNoPreview
+	default:
+		// Keep the bytes the header is read from to decode them again
+		var head bytes.Buffer
+		config, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+		if err != nil {
+			return nil, err
+		}
+		if err := limits.Check(config.Width, config.Height); err != nil {
+			return nil, err
+		}
+
+		img, _, err := image.Decode(io.MultiReader(&head, r))
+		return img, err
+	}
+}
+
+// DecodeConfig reads the dimensions of an uploaded receipt of the given
+// content type without decoding it, those of its preview for PDFs. Like
+// Decode, it returns ErrNoPreview for files without a preview.
+func DecodeConfig(r io.Reader, contentType string) (image.Config, error) {
+	switch contentType {
+	case "application/pdf":
+		return PDFPreviewConfig(r)
+	case "image/heic", "image/heif":
+		return image.Config{}, ErrNoPreview
+	default:
+		config, _, err := image.DecodeConfig(r)
+		return config, err
+	}
+}
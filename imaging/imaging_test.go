@@ -0,0 +1,87 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testPNGBomb is a valid 1x1 PNG whose header declares a width x height
+// canvas.
+func testPNGBomb(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The IHDR chunk follows the 8 byte signature: length, type, width,
+	// height, 5 more bytes of data and the CRC of type and data
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestDecodeLimits(t *testing.T) {
+	limits := Limits{MaxPixels: 1000, MaxDimension: 100}
+
+	t.Run("should refuse a huge canvas before decoding it", func(t *testing.T) {
+		bomb := testPNGBomb(t, 50000, 50000)
+
+		config, err := DecodeConfig(bytes.NewReader(bomb), "image/png")
+		if err != nil || config.Width != 50000 {
+			t.Fatalf("expected the declared size, got %+v, %v", config, err)
+		}
+
+		if _, err := Decode(bytes.NewReader(bomb), "image/png", limits); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("expected ErrTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("should check the pixel count", func(t *testing.T) {
+		if err := limits.Check(40, 40); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("expected ErrTooLarge, got %v", err)
+		}
+		if err := limits.Check(100, 10); err != nil {
+			t.Errorf("expected 100x10 to pass, got %v", err)
+		}
+		if err := (Limits{}).Check(50000, 50000); err != nil {
+			t.Errorf("expected zero limits to pass, got %v", err)
+		}
+	})
+
+	t.Run("should decode images within the limits", func(t *testing.T) {
+		img, err := Decode(bytes.NewReader(testPNGBomb(t, 1, 1)), "image/png", limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 1 || b.Dy() != 1 {
+			t.Errorf("expected 1x1, got %v", b)
+		}
+	})
+
+	t.Run("should check the preview of a pdf", func(t *testing.T) {
+		var img bytes.Buffer
+		if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 300, 40)), nil); err != nil {
+			t.Fatal(err)
+		}
+		pdf := testPDF(stream("/Subtype /Image /Filter /DCTDecode", img.Bytes()))
+
+		config, err := DecodeConfig(bytes.NewReader(pdf), "application/pdf")
+		if err != nil || config.Width != 300 || config.Height != 40 {
+			t.Fatalf("expected 300x40, got %+v, %v", config, err)
+		}
+
+		if _, err := Decode(bytes.NewReader(pdf), "application/pdf", limits); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("expected ErrTooLarge, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,567 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// StripMetadata removes the EXIF and XMP metadata, which may hold the GPS
+// position and device details, from a PNG, WebP, TIFF or HEIC image and
+// returns the stripped image with the fields worth keeping. Like
+// StripJPEGMetadata it keeps the orientation in the file where the format
+// has a place for it. Other types are returned unchanged.
+func StripMetadata(data []byte, contentType string) ([]byte, *Metadata, error) {
+	switch contentType {
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	case "image/tiff":
+		return stripTIFF(data)
+	case "image/heic", "image/heif":
+		return stripHEIC(data)
+	default:
+		return data, &Metadata{Orientation: 1}, nil
+	}
+}
+
+// exifMetadata reads the kept fields of a TIFF structure, tolerating the
+// "Exif\0\0" header some writers put in front of it. Unreadable metadata is
+// dropped along with the rest.
+func exifMetadata(data []byte) *Metadata {
+	meta, err := parseTIFF(bytes.TrimPrefix(data, exifHeader))
+	if err != nil {
+		return &Metadata{Orientation: 1}
+	}
+	return meta
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG drops the eXIf chunk and the text chunks, which is where XMP and
+// the EXIF of some tools end up, as well as the modification time.
+func stripPNG(data []byte) ([]byte, *Metadata, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, nil, errors.New("not a png image")
+	}
+
+	meta := &Metadata{Orientation: 1}
+	var chunks [][]byte
+	for rest := data[len(pngSignature):]; len(rest) > 0; {
+		if len(rest) < 12 {
+			return nil, nil, errors.New("truncated png chunk")
+		}
+		n := uint64(binary.BigEndian.Uint32(rest))
+		if n+12 > uint64(len(rest)) {
+			return nil, nil, errors.New("truncated png chunk")
+		}
+		chunk := rest[:n+12]
+		rest = rest[n+12:]
+
+		switch string(chunk[4:8]) {
+		case "eXIf":
+			meta = exifMetadata(chunk[8 : 8+n])
+		case "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			chunks = append(chunks, chunk)
+		}
+	}
+	if len(chunks) == 0 || string(chunks[0][4:8]) != "IHDR" {
+		return nil, nil, errors.New("png image without a header")
+	}
+
+	out := append([]byte{}, pngSignature...)
+	out = append(out, chunks[0]...)
+	// eXIf has to come before the image data, right after the header will do
+	if meta.Orientation > 1 {
+		out = appendPNGChunk(out, "eXIf", orientationExif(meta.Orientation)[len(exifHeader):])
+	}
+	for _, chunk := range chunks[1:] {
+		out = append(out, chunk...)
+	}
+
+	return out, meta, nil
+}
+
+func appendPNGChunk(b []byte, typ string, payload []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	start := len(b)
+	b = append(b, typ...)
+	b = append(b, payload...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[start:]))
+}
+
+const (
+	webpFlagXMP  = 0x04
+	webpFlagExif = 0x08
+)
+
+// stripWebP drops the EXIF and XMP chunks of an extended WebP and clears
+// their flags. Simple WebPs cannot carry metadata.
+func stripWebP(data []byte) ([]byte, *Metadata, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, nil, errors.New("not a webp image")
+	}
+
+	meta := &Metadata{Orientation: 1}
+	out := append([]byte{}, data[:12]...)
+	vp8x := -1
+	for rest := data[12:]; len(rest) > 0; {
+		if len(rest) < 8 {
+			return nil, nil, errors.New("truncated webp chunk")
+		}
+		n := uint64(binary.LittleEndian.Uint32(rest[4:]))
+		size := 8 + n + n%2
+		if size > uint64(len(rest)) {
+			// The padding of the last chunk is often left out
+			if 8+n > uint64(len(rest)) {
+				return nil, nil, errors.New("truncated webp chunk")
+			}
+			size = uint64(len(rest))
+		}
+		chunk := rest[:size]
+		rest = rest[size:]
+
+		switch string(chunk[:4]) {
+		case "EXIF":
+			meta = exifMetadata(chunk[8 : 8+n])
+		case "XMP ":
+		default:
+			if string(chunk[:4]) == "VP8X" && n >= 10 {
+				vp8x = len(out)
+			}
+			out = append(out, chunk...)
+			if len(chunk)%2 != 0 {
+				out = append(out, 0)
+			}
+		}
+	}
+
+	if vp8x >= 0 {
+		out[vp8x+8] &^= webpFlagExif | webpFlagXMP
+		if meta.Orientation > 1 {
+			exif := orientationExif(meta.Orientation)[len(exifHeader):]
+			out = append(out, "EXIF"...)
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(exif)))
+			out = append(out, exif...)
+			out[vp8x+8] |= webpFlagExif
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out, meta, nil
+}
+
+// tiffImageTags are the tags needed to render a TIFF, which stripTIFF keeps
+// along with the orientation and colour profile.
+var tiffImageTags = map[uint16]bool{
+	254: true, // NewSubfileType
+	255: true, // SubfileType
+	256: true, // ImageWidth
+	257: true, // ImageLength
+	258: true, // BitsPerSample
+	259: true, // Compression
+	262: true, // PhotometricInterpretation
+	266: true, // FillOrder
+	273: true, // StripOffsets
+	274: true, // Orientation
+	277: true, // SamplesPerPixel
+	278: true, // RowsPerStrip
+	279: true, // StripByteCounts
+	282: true, // XResolution
+	283: true, // YResolution
+	284: true, // PlanarConfiguration
+	296: true, // ResolutionUnit
+	317: true, // Predictor
+	320: true, // ColorMap
+	322: true, // TileWidth
+	323: true, // TileLength
+	324: true, // TileOffsets
+	325: true, // TileByteCounts
+	338: true, // ExtraSamples
+	339: true, // SampleFormat
+	347: true, // JPEGTables
+	529: true, // YCbCrCoefficients
+	530: true, // YCbCrSubSampling
+	531: true, // YCbCrPositioning
+	532: true, // ReferenceBlackWhite
+
+	0x8773: true, // ICC profile
+}
+
+// stripTIFF removes every tag outside of tiffImageTags from the image
+// directories in place, so that the image data keeps its offsets. The
+// values and sub-directories of the removed tags, such as the Exif and GPS
+// directories, are zeroed.
+func stripTIFF(data []byte) ([]byte, *Metadata, error) {
+	meta, err := parseTIFF(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := append([]byte{}, data...)
+	t := tiff{data: out, order: binary.LittleEndian}
+	if out[0] == 'M' {
+		t.order = binary.BigEndian
+	}
+
+	seen := map[uint32]bool{}
+	for offset := t.order.Uint32(out[4:]); offset != 0; {
+		if seen[offset] {
+			return nil, nil, errors.New("tiff directories form a loop")
+		}
+		seen[offset] = true
+
+		next, err := t.stripIFD(offset, seen)
+		if err != nil {
+			return nil, nil, err
+		}
+		offset = next
+	}
+
+	return out, meta, nil
+}
+
+// tiffEntry is a raw directory entry: the value, or the offset of a value
+// longer than four bytes, is at valueAt.
+type tiffEntry struct {
+	tag     uint16
+	size    uint64
+	valueAt uint64
+	raw     []byte
+}
+
+func (t tiff) entries(offset uint32) ([]tiffEntry, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, errors.New("tiff directory out of range")
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+n*12+4 > len(t.data) {
+		return nil, errors.New("tiff directory out of range")
+	}
+
+	entries := make([]tiffEntry, n)
+	for i := range entries {
+		raw := t.data[start+i*12 : start+i*12+12]
+		e := tiffEntry{
+			tag:     t.order.Uint16(raw),
+			size:    uint64(typeSize(t.order.Uint16(raw[2:]))) * uint64(t.order.Uint32(raw[4:])),
+			valueAt: uint64(start + i*12 + 8),
+			raw:     append([]byte{}, raw...),
+		}
+		if e.size > 4 {
+			e.valueAt = uint64(t.order.Uint32(raw[8:]))
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+// stripIFD rewrites the directory at offset with the kept tags only and
+// returns the offset of the next one.
+func (t tiff) stripIFD(offset uint32, seen map[uint32]bool) (uint32, error) {
+	entries, err := t.entries(offset)
+	if err != nil {
+		return 0, err
+	}
+	end := int(offset) + 2 + len(entries)*12
+	next := t.order.Uint32(t.data[end:])
+
+	var kept [][]byte
+	for _, e := range entries {
+		if tiffImageTags[e.tag] {
+			kept = append(kept, e.raw)
+			continue
+		}
+		t.zeroEntry(e, seen)
+	}
+
+	// Entries stay sorted, so the kept ones can move up in place
+	clear(t.data[offset : end+4])
+	t.order.PutUint16(t.data[offset:], uint16(len(kept)))
+	for i, raw := range kept {
+		copy(t.data[int(offset)+2+i*12:], raw)
+	}
+	t.order.PutUint32(t.data[int(offset)+2+len(kept)*12:], next)
+
+	return next, nil
+}
+
+// zeroEntry zeroes the value of a removed entry, and the whole directory
+// for pointers to sub-directories.
+func (t tiff) zeroEntry(e tiffEntry, seen map[uint32]bool) {
+	switch e.tag {
+	case tagExifIFD, tagGPSIFD, tagInteropIFD:
+		if e.size == 4 {
+			if sub := t.order.Uint32(t.data[e.valueAt:]); !seen[sub] {
+				seen[sub] = true
+				t.zeroIFD(sub, seen)
+			}
+		}
+	}
+	if e.valueAt+e.size <= uint64(len(t.data)) {
+		clear(t.data[e.valueAt : e.valueAt+e.size])
+	}
+}
+
+func (t tiff) zeroIFD(offset uint32, seen map[uint32]bool) {
+	entries, err := t.entries(offset)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		t.zeroEntry(e, seen)
+	}
+	clear(t.data[offset : int(offset)+2+len(entries)*12+4])
+}
+
+// stripHEIC zeroes the Exif and XMP items of a HEIC in place. Their bytes
+// are found through the item location box, so the image items keep their
+// offsets. The rotation of a HEIC lives in its irot property, which is
+// kept, so the orientation reported is always upright.
+func stripHEIC(data []byte) ([]byte, *Metadata, error) {
+	out := append([]byte{}, data...)
+	meta := &Metadata{Orientation: 1}
+
+	metaBox, ok, err := findBox(out, "meta")
+	if err != nil || !ok {
+		return out, meta, err
+	}
+	if len(metaBox) < 4 {
+		return nil, nil, errors.New("truncated heic meta box")
+	}
+	children := metaBox[4:]
+
+	iinf, ok, err := findBox(children, "iinf")
+	if err != nil || !ok {
+		return out, meta, err
+	}
+	items, err := metadataItems(iinf)
+	if err != nil || len(items) == 0 {
+		return out, meta, err
+	}
+
+	iloc, ok, err := findBox(children, "iloc")
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, errors.New("heic metadata without a location")
+	}
+	idat, _, err := findBox(children, "idat")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extents, err := itemExtents(iloc, items, out, idat)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, extent := range extents {
+		if items[extent.item] == "Exif" && len(extent.data) > 4 {
+			// The payload starts with the offset of the TIFF header
+			skip := uint64(binary.BigEndian.Uint32(extent.data))
+			if 4+skip < uint64(len(extent.data)) {
+				exif := exifMetadata(extent.data[4+skip:])
+				meta.CapturedAt, meta.Width, meta.Height = exif.CapturedAt, exif.Width, exif.Height
+			}
+		}
+		clear(extent.data)
+	}
+
+	return out, meta, nil
+}
+
+// findBox returns the payload of the first box of the given type in data,
+// a sequence of ISO BMFF boxes.
+func findBox(data []byte, typ string) ([]byte, bool, error) {
+	for len(data) > 0 {
+		boxType, payload, rest, err := nextBox(data)
+		if err != nil {
+			return nil, false, err
+		}
+		if boxType == typ {
+			return payload, true, nil
+		}
+		data = rest
+	}
+	return nil, false, nil
+}
+
+// nextBox splits the first ISO BMFF box off data.
+func nextBox(data []byte) (string, []byte, []byte, error) {
+	if len(data) < 8 {
+		return "", nil, nil, errors.New("truncated heic box")
+	}
+	size := uint64(binary.BigEndian.Uint32(data))
+	header := uint64(8)
+	switch size {
+	case 0:
+		size = uint64(len(data))
+	case 1:
+		if len(data) < 16 {
+			return "", nil, nil, errors.New("truncated heic box")
+		}
+		size, header = binary.BigEndian.Uint64(data[8:]), 16
+	}
+	if size < header || size > uint64(len(data)) {
+		return "", nil, nil, errors.New("truncated heic box")
+	}
+	return string(data[4:8]), data[header:size], data[size:], nil
+}
+
+// metadataItems maps the IDs of the Exif and XMP items listed in an iinf
+// box to their type.
+func metadataItems(iinf []byte) (map[uint32]string, error) {
+	if len(iinf) < 6 {
+		return nil, errors.New("truncated heic item info")
+	}
+	rest := iinf[6:]
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return nil, errors.New("truncated heic item info")
+		}
+		rest = iinf[8:]
+	}
+
+	items := map[uint32]string{}
+	for len(rest) > 0 {
+		box, infe, next, err := nextBox(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = next
+		if box != "infe" || len(infe) < 12 {
+			continue
+		}
+
+		version := infe[0]
+		if version < 2 {
+			continue
+		}
+		var id uint32
+		var typ string
+		var tail []byte
+		if version == 2 {
+			id, typ, tail = uint32(binary.BigEndian.Uint16(infe[4:])), string(infe[8:12]), infe[12:]
+		} else {
+			if len(infe) < 14 {
+				continue
+			}
+			id, typ, tail = binary.BigEndian.Uint32(infe[4:]), string(infe[10:14]), infe[14:]
+		}
+
+		switch typ {
+		case "Exif":
+			items[id] = typ
+		case "mime":
+			// The item name comes first, then the content type
+			if _, after, ok := bytes.Cut(tail, []byte{0}); ok {
+				if contentType, _, _ := bytes.Cut(after, []byte{0}); string(contentType) == "application/rdf+xml" {
+					items[id] = "XMP"
+				}
+			}
+		}
+	}
+	return items, nil
+}
+
+type itemExtent struct {
+	item uint32
+	data []byte
+}
+
+// itemExtents slices the bytes of the given items out of the file, or out of
+// the idat box for items stored there.
+func itemExtents(iloc []byte, items map[uint32]string, file, idat []byte) ([]itemExtent, error) {
+	r := boxReader{data: iloc}
+	version := r.uint(1)
+	r.uint(3) // flags
+	sizes := r.uint(1)
+	offsetSize, lengthSize := sizes>>4, sizes&0xf
+	sizes = r.uint(1)
+	baseOffsetSize, indexSize := sizes>>4, sizes&0xf
+	if version == 0 {
+		indexSize = 0
+	}
+
+	count := r.uint(2)
+	if version == 2 {
+		count = r.uint(4)
+	}
+
+	var extents []itemExtent
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		id := r.uint(2)
+		if version == 2 {
+			id = r.uint(4)
+		}
+		method := uint64(0)
+		if version > 0 {
+			method = r.uint(2) & 0xf
+		}
+		r.uint(2) // data reference index
+		base := r.uint(int(baseOffsetSize))
+
+		n := r.uint(2)
+		for j := uint64(0); j < n && r.err == nil; j++ {
+			r.uint(int(indexSize))
+			offset := base + r.uint(int(offsetSize))
+			length := r.uint(int(lengthSize))
+
+			if _, ok := items[uint32(id)]; !ok {
+				continue
+			}
+
+			var src []byte
+			switch method {
+			case 0:
+				src = file
+			case 1:
+				src = idat
+			default:
+				return nil, fmt.Errorf("unsupported heic item construction method %d", method)
+			}
+			if length == 0 && offset <= uint64(len(src)) {
+				length = uint64(len(src)) - offset
+			}
+			if offset+length > uint64(len(src)) {
+				return nil, errors.New("heic item out of range")
+			}
+			extents = append(extents, itemExtent{item: uint32(id), data: src[offset : offset+length]})
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return extents, nil
+}
+
+// boxReader reads big endian integers of 0 to 8 bytes, remembering the
+// first error.
+type boxReader struct {
+	data []byte
+	err  error
+}
+
+func (r *boxReader) uint(size int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if size > len(r.data) {
+		r.err = errors.New("truncated heic item location")
+		return 0
+	}
+
+	var v uint64
+	for _, b := range r.data[:size] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+	return v
+}
@@ -0,0 +1,274 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// gpsTIFF is a little endian TIFF structure holding the orientation, a
+// capture time, the camera make and a GPS directory. With pixels set it is
+// also a valid 1x1 grey TIFF image.
+func gpsTIFF(orientation uint16, pixels bool) []byte {
+	le := binary.LittleEndian
+	type entry struct {
+		tag, typ     uint16
+		count, value uint32
+	}
+
+	var ifd0 []entry
+	if pixels {
+		ifd0 = append(ifd0,
+			entry{256, 3, 1, 1}, // ImageWidth
+			entry{257, 3, 1, 1}, // ImageLength
+			entry{258, 3, 1, 8}, // BitsPerSample
+			entry{259, 3, 1, 1}, // Compression
+			entry{262, 3, 1, 1}, // PhotometricInterpretation
+		)
+	}
+	ifd0 = append(ifd0, entry{0x010f, 2, 10, 0}) // Make
+	if pixels {
+		ifd0 = append(ifd0, entry{273, 4, 1, 0}) // StripOffsets
+	}
+	ifd0 = append(ifd0, entry{tagOrientation, 3, 1, uint32(orientation)})
+	if pixels {
+		ifd0 = append(ifd0,
+			entry{277, 3, 1, 1}, // SamplesPerPixel
+			entry{278, 3, 1, 1}, // RowsPerStrip
+			entry{279, 4, 1, 1}, // StripByteCounts
+		)
+	}
+	ifd0 = append(ifd0, entry{tagExifIFD, 4, 1, 0}, entry{tagGPSIFD, 4, 1, 0})
+
+	exifAt := uint32(8 + 2 + 12*len(ifd0) + 4)
+	gpsAt := exifAt + 18
+	makeAt := gpsAt + 30
+	timeAt := makeAt + 10
+	secretAt := timeAt + 20
+	pixelAt := secretAt + 10
+
+	for i, e := range ifd0 {
+		switch e.tag {
+		case 0x010f:
+			ifd0[i].value = makeAt
+		case 273:
+			ifd0[i].value = pixelAt
+		case tagExifIFD:
+			ifd0[i].value = exifAt
+		case tagGPSIFD:
+			ifd0[i].value = gpsAt
+		}
+	}
+
+	appendIFD := func(b []byte, entries ...entry) []byte {
+		b = le.AppendUint16(b, uint16(len(entries)))
+		for _, e := range entries {
+			b = le.AppendUint16(b, e.tag)
+			b = le.AppendUint16(b, e.typ)
+			b = le.AppendUint32(b, e.count)
+			b = le.AppendUint32(b, e.value)
+		}
+		return le.AppendUint32(b, 0)
+	}
+
+	b := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	b = appendIFD(b, ifd0...)
+	b = appendIFD(b, entry{tagDateTimeOriginal, 2, 20, timeAt})
+	b = appendIFD(b,
+		entry{0x0001, 2, 2, 'N'},       // GPSLatitudeRef
+		entry{0x001b, 7, 10, secretAt}, // GPSProcessingMethod
+	)
+	b = append(b, "SecretCam\x00"...)
+	b = append(b, "2024:10:05 14:30:00\x00"...)
+	b = append(b, "GPSSECRET\x00"...)
+	if pixels {
+		b = append(b, 0x80)
+	}
+	return b
+}
+
+// checkStripped fails if any of the metadata of gpsTIFF survived.
+func checkStripped(t *testing.T, data []byte) {
+	t.Helper()
+
+	for _, secret := range []string{"GPSSECRET", "SecretCam", "2024:10:05", "xmpmeta"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("expected %q to be stripped", secret)
+		}
+	}
+}
+
+func checkCaptured(t *testing.T, meta *Metadata) {
+	t.Helper()
+
+	if want := time.Date(2024, 10, 5, 14, 30, 0, 0, time.UTC); !meta.CapturedAt.Equal(want) {
+		t.Errorf("expected capture time %v, got %v", want, meta.CapturedAt)
+	}
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	// IHDR is the first chunk, 25 bytes long with its length and CRC
+	header := 8 + 25
+	data := append([]byte{}, img.Bytes()[:header]...)
+	data = appendPNGChunk(data, "eXIf", gpsTIFF(6, false))
+	data = appendPNGChunk(data, "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta>GPSSECRET</x:xmpmeta>"))
+	data = append(data, img.Bytes()[header:]...)
+
+	out, meta, err := StripMetadata(data, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStripped(t, out)
+	checkCaptured(t, meta)
+	if meta.Orientation != 6 {
+		t.Errorf("expected orientation 6, got %d", meta.Orientation)
+	}
+	if !bytes.Contains(out, []byte("eXIf")) {
+		t.Error("expected the orientation to be kept")
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("expected the stripped image to decode: %v", err)
+	}
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	// A 1x1 lossless WebP with the extended header flagging EXIF and XMP
+	vp8l := "VP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00"
+	vp8x := "VP8X\x0a\x00\x00\x00" + string([]byte{webpFlagExif | webpFlagXMP}) + "\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+	chunk := func(typ string, payload []byte) string {
+		b := binary.LittleEndian.AppendUint32([]byte(typ), uint32(len(payload)))
+		b = append(b, payload...)
+		if len(payload)%2 != 0 {
+			b = append(b, 0)
+		}
+		return string(b)
+	}
+
+	body := "WEBP" + vp8x + vp8l +
+		chunk("EXIF", gpsTIFF(8, false)) +
+		chunk("XMP ", []byte("<x:xmpmeta>GPSSECRET</x:xmpmeta>"))
+	data := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body)))
+	data = append(data, body...)
+
+	out, meta, err := StripMetadata(data, "image/webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStripped(t, out)
+	checkCaptured(t, meta)
+	if meta.Orientation != 8 {
+		t.Errorf("expected orientation 8, got %d", meta.Orientation)
+	}
+	if got := binary.LittleEndian.Uint32(out[4:]); int(got) != len(out)-8 {
+		t.Errorf("expected a RIFF size of %d, got %d", len(out)-8, got)
+	}
+	if flags := out[20]; flags&webpFlagXMP != 0 || flags&webpFlagExif == 0 {
+		t.Errorf("expected only the EXIF flag to be set, got %#x", flags)
+	}
+	if _, err := Decode(bytes.NewReader(out), "image/webp", Limits{}); err != nil {
+		t.Errorf("expected the stripped image to decode: %v", err)
+	}
+}
+
+func TestStripTIFFMetadata(t *testing.T) {
+	data := gpsTIFF(3, true)
+
+	out, meta, err := StripMetadata(data, "image/tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStripped(t, out)
+	checkCaptured(t, meta)
+	if meta.Orientation != 3 {
+		t.Errorf("expected orientation 3, got %d", meta.Orientation)
+	}
+
+	kept, err := parseTIFF(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.Orientation != 3 || !kept.CapturedAt.IsZero() {
+		t.Errorf("expected only the orientation to be kept, got %+v", kept)
+	}
+	if _, err := Decode(bytes.NewReader(out), "image/tiff", Limits{}); err != nil {
+		t.Errorf("expected the stripped image to decode: %v", err)
+	}
+}
+
+func TestStripHEICMetadata(t *testing.T) {
+	box := func(typ string, payload ...[]byte) []byte {
+		b := binary.BigEndian.AppendUint32(nil, uint32(8+len(bytes.Join(payload, nil))))
+		b = append(b, typ...)
+		return append(b, bytes.Join(payload, nil)...)
+	}
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+
+	// The Exif item starts with the offset of the TIFF header
+	exif := append([]byte("\x00\x00\x00\x06Exif\x00\x00"), gpsTIFF(6, false)...)
+	xmp := []byte("<x:xmpmeta>GPSSECRET</x:xmpmeta>")
+
+	meta := func(exifAt, xmpAt uint32) []byte {
+		iinf := box("iinf",
+			[]byte{0, 0, 0, 0, 0, 2},
+			box("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif\x00")),
+			box("infe", []byte{2, 0, 0, 0, 0, 2, 0, 0}, []byte("mime\x00application/rdf+xml\x00")),
+		)
+
+		// Version 1, four byte offsets and lengths, two items in the file
+		iloc := []byte{1, 0, 0, 0, 0x44, 0x00, 0, 2}
+		for _, item := range []struct {
+			id     uint16
+			offset uint32
+			length int
+		}{{1, exifAt, len(exif)}, {2, xmpAt, len(xmp)}} {
+			iloc = binary.BigEndian.AppendUint16(iloc, item.id)
+			iloc = append(iloc, 0, 0, 0, 0, 0, 1)
+			iloc = binary.BigEndian.AppendUint32(iloc, item.offset)
+			iloc = binary.BigEndian.AppendUint32(iloc, uint32(item.length))
+		}
+
+		return box("meta", []byte{0, 0, 0, 0}, iinf, box("iloc", iloc))
+	}
+
+	exifAt := uint32(len(ftyp) + len(meta(0, 0)) + 8)
+	data := bytes.Join([][]byte{
+		ftyp,
+		meta(exifAt, exifAt+uint32(len(exif))),
+		box("mdat", exif, xmp),
+	}, nil)
+
+	out, got, err := StripMetadata(data, "image/heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStripped(t, out)
+	checkCaptured(t, got)
+	if got.Orientation != 1 {
+		t.Errorf("expected the orientation to be left to the file, got %d", got.Orientation)
+	}
+	if len(out) != len(data) {
+		t.Errorf("expected the items to keep their offsets, got %d bytes instead of %d", len(out), len(data))
+	}
+
+	t.Run("should accept files without metadata", func(t *testing.T) {
+		out, _, err := StripMetadata(ftyp, "image/heic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, ftyp) {
+			t.Error("expected the file to be left unchanged")
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package imaging
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/jpeg"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// maxPDFSize bounds the bytes read when looking for a preview
+	maxPDFSize = 64 << 20
+	// maxPDFPixels bounds the size of an embedded bitmap
+	maxPDFPixels = 50_000_000
+)
+
+var (
+	pdfStream     = regexp.MustCompile(`(?s)\d+\s+\d+\s+obj\s*<<(.*?)>>\s*stream\r?\n`)
+	pdfImage      = regexp.MustCompile(`/Subtype\s*/Image\b`)
+	pdfFilters    = regexp.MustCompile(`/Filter\s*(\[[^\]]*\]|/\w+)`)
+	pdfName       = regexp.MustCompile(`/(\w+)`)
+	pdfLength     = regexp.MustCompile(`/Length\s+(\d+)\b(\s+\d+\s+R)?`)
+	pdfColorSpace = regexp.MustCompile(`/ColorSpace\s*/(\w+)`)
+	pdfPredictor  = regexp.MustCompile(`/Predictor\b`)
+	pdfInts       = regexp.MustCompile(`/(Width|Height|BitsPerComponent)\s+(\d+)\b`)
+)
+
+// PDFPreview renders a preview of a PDF's first page. There is no pure Go
+// PDF renderer, so instead the first page image embedded in the document is
+// returned, which is what scanners and phone apps produce: a JPEG
+// (DCTDecode) or an 8 bit Flate compressed RGB or greyscale bitmap. It
+// returns ErrNoPreview when the PDF has no such image, e.g. when its pages
+// are made of text and vector graphics, and an error wrapping ErrTooLarge
+// when the image exceeds the limits.
+func PDFPreview(r io.Reader, limits Limits) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxPDFSize))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := data[m[2]:m[3]]
+		if !pdfImage.Match(dict) {
+			continue
+		}
+
+		stream := pdfStreamData(data[m[1]:], dict)
+		config, err := pdfImageConfig(dict, stream)
+		if err != nil {
+			continue
+		}
+		if err := limits.Check(config.Width, config.Height); err != nil {
+			return nil, err
+		}
+		if img, err := decodePDFImage(dict, stream); err == nil {
+			return img, nil
+		}
+	}
+
+	return nil, ErrNoPreview
+}
+
+// PDFPreviewConfig returns the dimensions of the image PDFPreview would
+// return, without decoding it.
+func PDFPreviewConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxPDFSize))
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	for _, m := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := data[m[2]:m[3]]
+		if !pdfImage.Match(dict) {
+			continue
+		}
+
+		if config, err := pdfImageConfig(dict, pdfStreamData(data[m[1]:], dict)); err == nil {
+			return config, nil
+		}
+	}
+
+	return image.Config{}, ErrNoPreview
+}
+
+// pdfStreamData returns the stream following a dictionary, using a direct
+// /Length when there is one and the endstream keyword otherwise.
+func pdfStreamData(data, dict []byte) []byte {
+	if m := pdfLength.FindSubmatch(dict); m != nil && len(m[2]) == 0 {
+		if n, err := strconv.Atoi(string(m[1])); err == nil && n <= len(data) {
+			return data[:n]
+		}
+	}
+
+	end := bytes.Index(data, []byte("endstream"))
+	if end < 0 {
+		return data
+	}
+	return bytes.TrimRight(data[:end], "\r\n")
+}
+
+// pdfImageConfig reads the dimensions of an embedded image, from the JPEG
+// header or from the dictionary of a bitmap. It returns ErrNoPreview for
+// images decodePDFImage does not support.
+func pdfImageConfig(dict, stream []byte) (image.Config, error) {
+	switch pdfFilter(dict) {
+	case "DCTDecode":
+		return jpeg.DecodeConfig(bytes.NewReader(stream))
+	case "FlateDecode":
+		width, height := pdfInt(dict, "Width"), pdfInt(dict, "Height")
+		if width <= 0 || height <= 0 || pdfInt(dict, "BitsPerComponent") != 8 || pdfChannels(dict) == 0 {
+			return image.Config{}, ErrNoPreview
+		}
+		return image.Config{Width: width, Height: height}, nil
+	default:
+		return image.Config{}, ErrNoPreview
+	}
+}
+
+func decodePDFImage(dict, stream []byte) (image.Image, error) {
+	switch pdfFilter(dict) {
+	case "DCTDecode":
+		return jpeg.Decode(bytes.NewReader(stream))
+	case "FlateDecode":
+		return decodePDFBitmap(dict, stream)
+	default:
+		return nil, ErrNoPreview
+	}
+}
+
+// pdfFilter returns the single filter of a stream the preview supports, or
+// an empty string.
+func pdfFilter(dict []byte) string {
+	var filters []string
+	if m := pdfFilters.FindSubmatch(dict); m != nil {
+		for _, name := range pdfName.FindAllSubmatch(m[1], -1) {
+			filters = append(filters, string(name[1]))
+		}
+	}
+
+	switch {
+	case len(filters) == 1 && filters[0] == "DCTDecode":
+		return filters[0]
+	case len(filters) == 1 && filters[0] == "FlateDecode" && !pdfPredictor.Match(dict):
+		return filters[0]
+	default:
+		return ""
+	}
+}
+
+// pdfChannels is the number of 8 bit channels of a DeviceRGB or DeviceGray
+// image, or zero for other colour spaces.
+func pdfChannels(dict []byte) int {
+	if m := pdfColorSpace.FindSubmatch(dict); m != nil {
+		switch string(m[1]) {
+		case "DeviceRGB":
+			return 3
+		case "DeviceGray":
+			return 1
+		}
+	}
+	return 0
+}
+
+// decodePDFBitmap decodes a Flate compressed 8 bit DeviceRGB or DeviceGray
+// image.
+func decodePDFBitmap(dict, stream []byte) (image.Image, error) {
+	width, height := pdfInt(dict, "Width"), pdfInt(dict, "Height")
+	if width <= 0 || height <= 0 || width > maxPDFPixels/height || pdfInt(dict, "BitsPerComponent") != 8 {
+		return nil, ErrNoPreview
+	}
+
+	channels := pdfChannels(dict)
+	if channels == 0 {
+		return nil, ErrNoPreview
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	pix := make([]byte, width*height*channels)
+	if _, err := io.ReadFull(zr, pix); err != nil {
+		return nil, err
+	}
+
+	if channels == 1 {
+		return &image.Gray{Pix: pix, Stride: width, Rect: image.Rect(0, 0, width, height)}, nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, j := 0, 0; i < len(pix); i, j = i+3, j+4 {
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = pix[i], pix[i+1], pix[i+2], 0xff
+	}
+	return img, nil
+}
+
+func pdfInt(dict []byte, key string) int {
+	for _, m := range pdfInts.FindAllSubmatch(dict, -1) {
+		if string(m[1]) == key {
+			n, _ := strconv.Atoi(string(m[2]))
+			return n
+		}
+	}
+	return 0
+}
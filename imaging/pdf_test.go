@@ -0,0 +1,71 @@
+package imaging
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// testPDF wraps the streams, given with their dictionaries, in a minimal PDF.
+func testPDF(objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func stream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func TestPDFPreview(t *testing.T) {
+	t.Run("should extract an embedded jpeg", func(t *testing.T) {
+		var img bytes.Buffer
+		if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 30, 40)), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		pdf := testPDF(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			stream("/Type /XObject /Subtype /Image /Width 30 /Height 40 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode", img.Bytes()),
+		)
+
+		preview, err := PDFPreview(bytes.NewReader(pdf), Limits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := preview.Bounds(); b.Dx() != 30 || b.Dy() != 40 {
+			t.Errorf("expected 30x40, got %dx%d", b.Dx(), b.Dy())
+		}
+	})
+
+	t.Run("should decode a flate compressed bitmap", func(t *testing.T) {
+		var data bytes.Buffer
+		zw := zlib.NewWriter(&data)
+		zw.Write(bytes.Repeat([]byte{0x80}, 6*4))
+		zw.Close()
+
+		pdf := testPDF(stream("/Subtype /Image /Width 6 /Height 4 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter [/FlateDecode]", data.Bytes()))
+
+		preview, err := PDFPreview(bytes.NewReader(pdf), Limits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := preview.Bounds(); b.Dx() != 6 || b.Dy() != 4 {
+			t.Errorf("expected 6x4, got %dx%d", b.Dx(), b.Dy())
+		}
+	})
+
+	t.Run("should report pdfs without page images", func(t *testing.T) {
+		pdf := testPDF(stream("", []byte("BT /F1 12 Tf (Total 12.50) Tj ET")))
+		if _, err := PDFPreview(bytes.NewReader(pdf), Limits{}); err != ErrNoPreview {
+			t.Errorf("expected ErrNoPreview, got %v", err)
+		}
+	})
+}
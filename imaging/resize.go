@@ -0,0 +1,190 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Mode decides how an image is fitted into the requested box.
+type Mode string
+
+const (
+	// ModeScale stretches the image to exactly the requested size.
+	ModeScale Mode = "scale"
+	// ModeFit preserves the aspect ratio and fits the whole image within the
+	// box, so the result may be smaller than requested on one side.
+	ModeFit Mode = "fit"
+	// ModeFill preserves the aspect ratio, covers the whole box and crops
+	// the overflow around the centre.
+	ModeFill Mode = "fill"
+	// ModePad fits the image and letterboxes it onto a background of the
+	// requested size.
+	ModePad Mode = "pad"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeScale, ModeFit, ModeFill, ModePad:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid mode %q, expected scale, fit, fill or pad", s)
+	}
+}
+
+// ParseKernel returns the interpolator for nearest, bilinear or catmullrom.
+func ParseKernel(s string) (draw.Interpolator, error) {
+	switch s {
+	case "nearest":
+		return draw.NearestNeighbor, nil
+	case "bilinear":
+		return draw.BiLinear, nil
+	case "catmullrom":
+		return draw.CatmullRom, nil
+	default:
+		return nil, fmt.Errorf("invalid kernel %q, expected nearest, bilinear or catmullrom", s)
+	}
+}
+
+// KernelName returns the name ParseKernel takes for k, bilinear for nil.
+func KernelName(k draw.Interpolator) string {
+	switch k {
+	case draw.NearestNeighbor:
+		return "nearest"
+	case draw.CatmullRom:
+		return "catmullrom"
+	default:
+		return "bilinear"
+	}
+}
+
+// ParseColor parses a hex colour such as "fff", "ffffff" or "ffffff80".
+func ParseColor(s string) (color.Color, error) {
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) == 6 {
+		s += "ff"
+	}
+
+	var r, g, b, a uint8
+	if len(s) != 8 {
+		return nil, fmt.Errorf("invalid colour %q", s)
+	}
+	if _, err := fmt.Sscanf(s, "%02x%02x%02x%02x", &r, &g, &b, &a); err != nil {
+		return nil, fmt.Errorf("invalid colour %q", s)
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: a}, nil
+}
+
+// Options describe a resize. A zero Width or Height is derived from the
+// other one using the image's aspect ratio. A positive MaxSize bounds the
+// derived side, shrinking the box along the aspect ratio when it would be
+// exceeded.
+type Options struct {
+	Width      int
+	Height     int
+	MaxSize    int
+	Mode       Mode
+	Kernel     draw.Interpolator
+	Background color.Color
+}
+
+// Resize returns img fitted into the box described by opts. The original is
+// returned unchanged when neither dimension is set.
+func Resize(img image.Image, opts Options) image.Image {
+	src := img.Bounds()
+	if src.Empty() {
+		return img
+	}
+
+	w, h := boxSize(src.Dx(), src.Dy(), opts.Width, opts.Height, opts.MaxSize)
+	if w == 0 || h == 0 {
+		return img
+	}
+
+	kernel := opts.Kernel
+	if kernel == nil {
+		kernel = draw.BiLinear
+	}
+
+	switch opts.Mode {
+	case ModeFit:
+		fw, fh := fitSize(src.Dx(), src.Dy(), w, h)
+		dst := image.NewRGBA(image.Rect(0, 0, fw, fh))
+		kernel.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+		return dst
+
+	case ModeFill:
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		kernel.Scale(dst, dst.Bounds(), img, fillCrop(src, w, h), draw.Src, nil)
+		return dst
+
+	case ModePad:
+		bg := opts.Background
+		if bg == nil {
+			bg = color.White
+		}
+
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+		fw, fh := fitSize(src.Dx(), src.Dy(), w, h)
+		x, y := (w-fw)/2, (h-fh)/2
+		kernel.Scale(dst, image.Rect(x, y, x+fw, y+fh), img, src, draw.Over, nil)
+		return dst
+
+	default:
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		kernel.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+		return dst
+	}
+}
+
+// boxSize fills in a missing dimension from the source aspect ratio. A
+// derived side longer than limit is cut to limit and the requested side
+// shrinks with it, so an extreme aspect ratio can't blow up the output.
+func boxSize(sw, sh, w, h, limit int) (int, int) {
+	switch {
+	case w > 0 && h > 0:
+		return w, h
+	case w > 0:
+		dh := float64(w) * float64(sh) / float64(sw)
+		if limit > 0 && dh > float64(limit) {
+			return max(1, round(float64(w)*float64(limit)/dh)), limit
+		}
+		return w, max(1, round(dh))
+	case h > 0:
+		dw := float64(h) * float64(sw) / float64(sh)
+		if limit > 0 && dw > float64(limit) {
+			return limit, max(1, round(float64(h)*float64(limit)/dw))
+		}
+		return max(1, round(dw)), h
+	default:
+		return 0, 0
+	}
+}
+
+// fitSize is the largest size with the source aspect ratio inside w x h.
+func fitSize(sw, sh, w, h int) (int, int) {
+	scale := math.Min(float64(w)/float64(sw), float64(h)/float64(sh))
+	return max(1, min(w, round(float64(sw)*scale))), max(1, min(h, round(float64(sh)*scale)))
+}
+
+// fillCrop is the centred part of src with the aspect ratio of w x h.
+func fillCrop(src image.Rectangle, w, h int) image.Rectangle {
+	scale := math.Max(float64(w)/float64(src.Dx()), float64(h)/float64(src.Dy()))
+	cw := min(src.Dx(), max(1, round(float64(w)/scale)))
+	ch := min(src.Dy(), max(1, round(float64(h)/scale)))
+
+	x := src.Min.X + (src.Dx()-cw)/2
+	y := src.Min.Y + (src.Dy()-ch)/2
+	return image.Rect(x, y, x+cw, y+ch)
+}
+
+func round(f float64) int {
+	return int(math.Round(f))
+}
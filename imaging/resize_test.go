@@ -0,0 +1,101 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestResize(t *testing.T) {
+	// A 400x200 landscape image
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+
+	tests := []struct {
+		name   string
+		opts   Options
+		width  int
+		height int
+	}{
+		{"scale stretches to the box", Options{Width: 100, Height: 100, Mode: ModeScale}, 100, 100},
+		{"fit keeps the aspect ratio", Options{Width: 100, Height: 100, Mode: ModeFit}, 100, 50},
+		{"fill covers the box", Options{Width: 100, Height: 100, Mode: ModeFill}, 100, 100},
+		{"pad letterboxes to the box", Options{Width: 100, Height: 100, Mode: ModePad}, 100, 100},
+		{"a missing height follows the aspect ratio", Options{Width: 100, Mode: ModeScale}, 100, 50},
+		{"a missing width follows the aspect ratio", Options{Height: 100, Mode: ModeFit}, 200, 100},
+		{"no dimensions keeps the original", Options{Mode: ModeFit}, 400, 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Resize(src, tt.opts).Bounds()
+			if b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("expected %dx%d, got %dx%d", tt.width, tt.height, b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestResizeBoundsDerivedSide(t *testing.T) {
+	// A 1x20000 sliver would be 4096x81920000 at width 4096
+	tall := image.NewRGBA(image.Rect(0, 0, 1, 20000))
+	wide := image.NewRGBA(image.Rect(0, 0, 20000, 1))
+	portrait := image.NewRGBA(image.Rect(0, 0, 200, 400))
+
+	tests := []struct {
+		name   string
+		src    image.Image
+		opts   Options
+		width  int
+		height int
+	}{
+		{"a derived height is capped", tall, Options{Width: 4096, MaxSize: 4096, Mode: ModeFit}, 1, 4096},
+		{"a derived width is capped", wide, Options{Height: 4096, MaxSize: 4096, Mode: ModeScale}, 4096, 1},
+		{"the aspect ratio survives the cap", portrait, Options{Width: 800, MaxSize: 1000, Mode: ModeScale}, 500, 1000},
+		{"a derived side within the limit is kept", portrait, Options{Width: 100, MaxSize: 1000, Mode: ModeScale}, 100, 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Resize(tt.src, tt.opts).Bounds()
+			if b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("expected %dx%d, got %dx%d", tt.width, tt.height, b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestResizePadBackground(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+
+	bg, err := ParseColor("f00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := Resize(img, Options{Width: 100, Height: 100, Mode: ModePad, Background: bg})
+
+	// The letterbox bars are above and below the image
+	r, g, b, _ := dst.At(50, 5).RGBA()
+	if r != 0xffff || g != 0 || b != 0 {
+		t.Errorf("expected a red letterbox, got %v", dst.At(50, 5))
+	}
+	if c := color.RGBAModel.Convert(dst.At(50, 50)).(color.RGBA); c.R == 0xff {
+		t.Errorf("expected the image in the middle, got %v", c)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	for _, s := range []string{"fff", "ffffff", "ffffff80"} {
+		if _, err := ParseColor(s); err != nil {
+			t.Errorf("expected %q to parse: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "ff", "gggggg", "#ffffff"} {
+		if _, err := ParseColor(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package money handles amounts of money exactly, as whole numbers of a
+// currency's minor units such as cents, rather than as floats.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxWholeDigits bounds the digits before the decimal point, as the amount
+// columns hold up to 10 of them.
+const maxWholeDigits = 10
+
+var (
+	ErrUnknownCurrency = errors.New("unknown currency, use an ISO 4217 code such as EUR")
+	ErrInvalidAmount   = errors.New("invalid amount, use digits and an optional decimal point, such as 12.50")
+	ErrAmountTooLarge  = errors.New("amount is too large")
+)
+
+// Currency is an ISO 4217 currency code, such as "EUR".
+type Currency string
+
+// ParseCurrency returns the currency with the code, in either case.
+func ParseCurrency(code string) (Currency, error) {
+	c := Currency(strings.ToUpper(strings.TrimSpace(code)))
+	if _, ok := minorDigits[c]; !ok {
+		return "", ErrUnknownCurrency
+	}
+	return c, nil
+}
+
+// Digits is the number of decimal places of the currency's minor unit, 2
+// for cents and 0 for currencies without one like the yen.
+func (c Currency) Digits() int {
+	return minorDigits[c]
+}
+
+// Amount is an exact amount of money: a whole number of minor units along
+// with the decimal places of its currency. The zero Amount is zero.
+type Amount struct {
+	minor  int64
+	digits int
+}
+
+// New returns the amount of minor units of the currency.
+func New(minor int64, c Currency) Amount {
+	return Amount{minor: minor, digits: c.Digits()}
+}
+
+// Parse reads a decimal amount of the currency, such as "12.50" or
+// "-3". Decimal places beyond the currency's minor unit are refused unless
+// they are zeros.
+func Parse(s string, c Currency) (Amount, error) {
+	if _, ok := minorDigits[c]; !ok {
+		return Amount{}, ErrUnknownCurrency
+	}
+
+	a, err := parseDecimal(s, c.Digits())
+	if errors.Is(err, errTooManyDecimals) {
+		return Amount{}, fmt.Errorf("%s amounts have at most %d decimal places", c, c.Digits())
+	}
+	return a, err
+}
+
+var errTooManyDecimals = errors.New("too many decimal places")
+
+// parseDecimal reads a decimal amount into minor units of the given number
+// of decimal places, or of as many as it is written with when digits is
+// negative.
+func parseDecimal(s string, digits int) (Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Amount{}, ErrInvalidAmount
+	}
+
+	if digits < 0 {
+		digits = len(fraction)
+	}
+	if len(fraction) > digits {
+		if strings.Trim(fraction[digits:], "0") != "" {
+			return Amount{}, errTooManyDecimals
+		}
+		fraction = fraction[:digits]
+	}
+	fraction += strings.Repeat("0", digits-len(fraction))
+
+	whole = strings.TrimLeft(whole, "0")
+	if len(whole) > maxWholeDigits {
+		return Amount{}, ErrAmountTooLarge
+	}
+
+	minor, err := strconv.ParseInt("0"+whole+fraction, 10, 64)
+	if err != nil {
+		return Amount{}, ErrInvalidAmount
+	}
+	if negative {
+		minor = -minor
+	}
+
+	return Amount{minor: minor, digits: digits}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Minor returns the amount in minor units.
+func (a Amount) Minor() int64 {
+	return a.minor
+}
+
+// String writes the amount with its currency's decimal places, e.g. "12.50".
+func (a Amount) String() string {
+	s := strconv.FormatInt(a.minor, 10)
+	if a.digits == 0 {
+		return s
+	}
+
+	sign := ""
+	if a.minor < 0 {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= a.digits {
+		s = strings.Repeat("0", a.digits-len(s)+1) + s
+	}
+	return sign + s[:len(s)-a.digits] + "." + s[len(s)-a.digits:]
+}
+
+// MarshalJSON writes the amount as a string, which unlike a JSON number
+// cannot lose precision in clients reading it into a float.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON reads an amount written as a string or a number, keeping as
+// many decimal places as it is written with.
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return ErrInvalidAmount
+	}
+
+	parsed, err := parseDecimal(n.String(), -1)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Value stores the amount in a DECIMAL column exactly, as a string.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Sign returns -1, 0 or 1 for negative, zero and positive amounts.
+func (a Amount) Sign() int {
+	switch {
+	case a.minor < 0:
+		return -1
+	case a.minor > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Add returns the sum of two amounts of the same currency.
+func (a Amount) Add(b Amount) Amount {
+	a, b = align(a, b)
+	return Amount{minor: a.minor + b.minor, digits: a.digits}
+}
+
+// Sub returns the difference of two amounts of the same currency.
+func (a Amount) Sub(b Amount) Amount {
+	a, b = align(a, b)
+	return Amount{minor: a.minor - b.minor, digits: a.digits}
+}
+
+// Cmp compares two amounts of the same currency, returning -1, 0 or 1.
+func (a Amount) Cmp(b Amount) int {
+	return a.Sub(b).Sign()
+}
+
+// Mul multiplies the amount by a factor such as a quantity or a tax rate,
+// rounding half away from zero to the minor unit.
+func (a Amount) Mul(factor float64) Amount {
+	return Amount{minor: int64(math.Round(float64(a.minor) * factor)), digits: a.digits}
+}
+
+// align writes both amounts with the larger number of decimal places, for
+// zero Amounts which have none.
+func align(a, b Amount) (Amount, Amount) {
+	for a.digits < b.digits {
+		a.minor, a.digits = a.minor*10, a.digits+1
+	}
+	for b.digits < a.digits {
+		b.minor, b.digits = b.minor*10, b.digits+1
+	}
+	return a, b
+}
@@ -0,0 +1,157 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("should read amounts into minor units", func(t *testing.T) {
+		tests := []struct {
+			s        string
+			currency Currency
+			minor    int64
+			str      string
+		}{
+			{"12.50", "EUR", 1250, "12.50"},
+			{"12.5", "EUR", 1250, "12.50"},
+			{"12", "EUR", 1200, "12.00"},
+			{"0.07", "USD", 7, "0.07"},
+			{"-3.10", "USD", -310, "-3.10"},
+			{"12.500", "EUR", 1250, "12.50"},
+			{"1500", "JPY", 1500, "1500"},
+			{"1.234", "KWD", 1234, "1.234"},
+			{"9999999999.99", "USD", 999999999999, "9999999999.99"},
+		}
+		for _, tt := range tests {
+			a, err := Parse(tt.s, tt.currency)
+			if err != nil {
+				t.Errorf("%s %s: %v", tt.s, tt.currency, err)
+				continue
+			}
+			if a.Minor() != tt.minor || a.String() != tt.str {
+				t.Errorf("%s %s: expected %d (%s), got %d (%s)", tt.s, tt.currency, tt.minor, tt.str, a.Minor(), a)
+			}
+		}
+	})
+
+	t.Run("should refuse what is not an exact amount of the currency", func(t *testing.T) {
+		tests := []struct {
+			s        string
+			currency Currency
+		}{
+			{"12.505", "EUR"},
+			{"12.5", "JPY"},
+			{"1e3", "EUR"},
+			{"1,000.00", "EUR"},
+			{".50", "EUR"},
+			{"", "EUR"},
+			{"NaN", "EUR"},
+			{"12.50", "XYZ"},
+			{"12345678901", "EUR"},
+		}
+		for _, tt := range tests {
+			if a, err := Parse(tt.s, tt.currency); err == nil {
+				t.Errorf("%q %s: expected an error, got %s", tt.s, tt.currency, a)
+			}
+		}
+	})
+}
+
+func TestParseCurrency(t *testing.T) {
+	if c, err := ParseCurrency("eur"); err != nil || c != "EUR" || c.Digits() != 2 {
+		t.Errorf("expected EUR with 2 digits, got %q, %v", c, err)
+	}
+	if c, _ := ParseCurrency("JPY"); c.Digits() != 0 {
+		t.Errorf("expected JPY to have no minor unit, got %d", c.Digits())
+	}
+	if _, err := ParseCurrency("EURO"); err != ErrUnknownCurrency {
+		t.Errorf("expected ErrUnknownCurrency, got %v", err)
+	}
+}
+
+func TestAmount(t *testing.T) {
+	t.Run("should add without float rounding", func(t *testing.T) {
+		sum := Amount{}
+		for range 10 {
+			sum = sum.Add(New(10, "USD"))
+		}
+		if sum.Cmp(New(100, "USD")) != 0 || sum.String() != "1.00" {
+			t.Errorf("expected 1.00, got %s", sum)
+		}
+	})
+
+	t.Run("should round products to the minor unit", func(t *testing.T) {
+		if got := New(1067, "USD").Mul(3); got.Minor() != 3201 {
+			t.Errorf("expected 3201, got %d", got.Minor())
+		}
+		if got := New(1005, "USD").Mul(0.5); got.Minor() != 503 {
+			t.Errorf("expected half a cent to round up to 503, got %d", got.Minor())
+		}
+	})
+
+	t.Run("should unmarshal strings and numbers exactly", func(t *testing.T) {
+		var v struct{ A, B Amount }
+		if err := json.Unmarshal([]byte(`{"A": "12.50", "B": 0.1}`), &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.A.String() != "12.50" || v.B.Minor() != 1 || v.A.Add(v.B).String() != "12.60" {
+			t.Errorf("unexpected amounts %s and %s", v.A, v.B)
+		}
+	})
+
+	t.Run("should marshal as a string", func(t *testing.T) {
+		b, err := json.Marshal(map[string]Amount{"amount": New(-5, "EUR")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != `{"amount":"-0.05"}` {
+			t.Errorf("unexpected JSON %s", b)
+		}
+	})
+}
+
+func TestRate(t *testing.T) {
+	t.Run("should convert to the minor unit of the other currency", func(t *testing.T) {
+		tests := []struct {
+			amount Amount
+			rate   string
+			to     Currency
+			str    string
+		}{
+			{New(1000, "EUR"), "1.0565", "USD", "10.57"},
+			{New(1000, "EUR"), "162.85", "JPY", "1629"},
+			{New(1500, "JPY"), "0.0063", "USD", "9.45"},
+			{New(-1000, "EUR"), "1.0565", "USD", "-10.57"},
+			{New(1234, "KWD"), "3.25", "USD", "4.01"},
+		}
+		for _, tt := range tests {
+			rate, err := ParseRate(tt.rate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.amount.Convert(rate, tt.to); got.String() != tt.str {
+				t.Errorf("%s at %s: expected %s %s, got %s", tt.amount, tt.rate, tt.str, tt.to, got)
+			}
+		}
+	})
+
+	t.Run("should chain and invert rates exactly", func(t *testing.T) {
+		eurUSD, _ := ParseRate("1.0565")
+		eurGBP, _ := ParseRate("0.8329")
+		if got := eurGBP.Inverse().Mul(eurUSD).String(); got != "1.268459599" {
+			t.Errorf("unexpected GBP to USD rate %s", got)
+		}
+		if got := New(100, "GBP").Convert(eurGBP.Inverse().Mul(eurUSD), "USD"); got.String() != "1.27" {
+			t.Errorf("expected 1.27, got %s", got)
+		}
+	})
+
+	t.Run("should refuse rates that are not positive decimals", func(t *testing.T) {
+		for _, s := range []string{"", "0", "-1.2", "1e3", "1,2", "1.00000000001"} {
+			if _, err := ParseRate(s); err == nil {
+				t.Errorf("%q: expected an error", s)
+			}
+		}
+	})
+}
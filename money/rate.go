@@ -0,0 +1,118 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// maxRateDecimals bounds the decimal places of a rate, as the rate column
+// holds up to 10 of them.
+const maxRateDecimals = 10
+
+var ErrInvalidRate = errors.New("invalid exchange rate, use a positive decimal such as 1.0842")
+
+// Rate is an exact exchange rate, the amount of the quote currency one unit
+// of the base currency buys. The zero Rate is invalid.
+type Rate struct {
+	r *big.Rat
+}
+
+// ParseRate reads a positive decimal rate such as "1.0842".
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) || len(fraction) > maxRateDecimals {
+		return Rate{}, ErrInvalidRate
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || r.Sign() <= 0 {
+		return Rate{}, ErrInvalidRate
+	}
+	return Rate{r: r}, nil
+}
+
+// IsZero reports whether the rate is unset.
+func (r Rate) IsZero() bool {
+	return r.r == nil
+}
+
+// Inverse returns the rate of the reverse pair.
+func (r Rate) Inverse() Rate {
+	return Rate{r: new(big.Rat).Inv(r.r)}
+}
+
+// Mul chains two rates, such as GBP to EUR and EUR to USD into GBP to USD.
+func (r Rate) Mul(s Rate) Rate {
+	return Rate{r: new(big.Rat).Mul(r.r, s.r)}
+}
+
+// String writes the rate with up to 10 decimal places, e.g. "1.0842".
+func (r Rate) String() string {
+	if r.r == nil {
+		return "0"
+	}
+	s := r.r.FloatString(maxRateDecimals)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// MarshalJSON writes the rate as a string, like amounts.
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON reads a rate written as a string or a number.
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return ErrInvalidRate
+	}
+
+	parsed, err := ParseRate(n.String())
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// Value stores the rate in a DECIMAL column exactly, as a string.
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+// Convert returns the amount in the currency the rate converts to, rounded
+// half away from zero to that currency's minor unit.
+func (a Amount) Convert(r Rate, to Currency) Amount {
+	// minor units of a * rate * 10^(to's digits - a's digits)
+	x := new(big.Rat).Mul(new(big.Rat).SetInt64(a.minor), r.r)
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(to.Digits()-a.digits))), nil)
+	if to.Digits() > a.digits {
+		x.Mul(x, new(big.Rat).SetInt(scale))
+	} else {
+		x.Quo(x, new(big.Rat).SetInt(scale))
+	}
+
+	// Round half away from zero
+	num, den := new(big.Int).Abs(x.Num()), x.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if m.Mul(m, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if x.Sign() < 0 {
+		q.Neg(q)
+	}
+
+	return Amount{minor: q.Int64(), digits: to.Digits()}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+// Package ocr reads the text printed on receipt images and finds the
+// merchant, total and date in it.
+package ocr
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/groshiniprasad/uploady/configs"
+)
+
+// Extractor recognises the text on a receipt image.
+type Extractor interface {
+	Extract(ctx context.Context, img image.Image) (Text, error)
+}
+
+// Text is the recognised text of an image, line by line from the top.
+type Text struct {
+	Lines []Line
+}
+
+// Line is a line of recognised text with the engine's confidence in it,
+// between 0 and 1.
+type Line struct {
+	Text       string
+	Confidence float64
+}
+
+func (t Text) String() string {
+	lines := make([]string, len(t.Lines))
+	for i, l := range t.Lines {
+		lines[i] = l.Text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// New returns the extractor selected by cfg.OCREngine, or nil when OCR is
+// disabled.
+func New(cfg configs.Config) (Extractor, error) {
+	switch cfg.OCREngine {
+	case "":
+		return nil, nil
+	case "tesseract":
+		return NewTesseract(cfg.TesseractPath, cfg.OCRLanguage)
+	default:
+		return nil, fmt.Errorf("unknown OCR engine %q", cfg.OCREngine)
+	}
+}
+
+// Fake is a deterministic Extractor for tests: it returns the same text, or
+// error, for every image.
+type Fake struct {
+	Text Text
+	Err  error
+}
+
+// NewFake returns a Fake recognising the lines with full confidence.
+func NewFake(lines ...string) *Fake {
+	f := &Fake{}
+	for _, l := range lines {
+		f.Text.Lines = append(f.Text.Lines, Line{Text: l, Confidence: 1})
+	}
+	return f
+}
+
+func (f *Fake) Extract(ctx context.Context, img image.Image) (Text, error) {
+	return f.Text, f.Err
+}
@@ -0,0 +1,237 @@
+package ocr
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/groshiniprasad/uploady/types"
+)
+
+const (
+	// guessWeight scales the confidence of values that were not labelled as
+	// such on the receipt, like the largest amount standing in for the total
+	// or a date whose day and month could be swapped
+	guessWeight = 0.5
+	// merchantWeight scales the confidence of the merchant's name, which is
+	// only usually the first text on a receipt
+	merchantWeight = 0.8
+	// merchantLines is how many lines from the top the merchant is looked for in
+	merchantLines = 5
+)
+
+var (
+	// amountPattern matches prices with two decimals, with or without
+	// thousands separators: 12.50, 1,234.50, 1.234,50
+	amountPattern = regexp.MustCompile(`\d{1,3}(?:[.,]\d{3})+[.,]\d{2}\b|\d+[.,]\d{2}\b`)
+
+	totalKeywords = []string{"total", "amount due", "balance due", "to pay"}
+	// notTotalKeywords mark lines that mention a total without being it
+	notTotalKeywords = []string{"subtotal", "sub total", "sub-total", "tax", "vat", "discount", "savings", "items"}
+	// notMerchantKeywords mark header lines that are not the merchant's name
+	notMerchantKeywords = []string{"receipt", "invoice", "welcome", "tel:", "tel.", "phone", "www", "http", "@"}
+
+	isoDatePattern     = regexp.MustCompile(`\b(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})\b`)
+	numericDatePattern = regexp.MustCompile(`\b(\d{1,2})[-/.](\d{1,2})[-/.](\d{4}|\d{2})\b`)
+	dayMonthPattern    = regexp.MustCompile(`(?i)\b(\d{1,2})(?:st|nd|rd|th)?\s+(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.?,?\s+(\d{4})\b`)
+	monthDayPattern    = regexp.MustCompile(`(?i)\b(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.?\s+(\d{1,2})(?:st|nd|rd|th)?,?\s+(\d{4})\b`)
+
+	months = map[string]time.Month{
+		"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+		"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+		"sep": time.September, "oct": time.October, "nov": time.November, "dec": time.December,
+	}
+)
+
+// Parse finds the merchant's name, the total and the date in the text of a
+// receipt. Values that cannot be found are left nil.
+func Parse(text Text) *types.ReceiptSuggestions {
+	return &types.ReceiptSuggestions{
+		Name:   findMerchant(text),
+		Amount: findTotal(text),
+		Date:   findDate(text),
+	}
+}
+
+// findTotal returns the amount on the last line labelled as a total, since
+// receipts list the subtotals above it. Without one, the largest amount on
+// the receipt is the best guess. Amounts are written with a decimal point,
+// as the currency is not known.
+func findTotal(text Text) *types.Suggestion[string] {
+	var total, largest *types.Suggestion[string]
+	var largestValue float64
+	for _, line := range text.Lines {
+		amounts := findAmounts(line.Text)
+		if len(amounts) == 0 {
+			continue
+		}
+
+		if isTotalLine(line.Text) {
+			total = &types.Suggestion[string]{Value: amounts[len(amounts)-1], Confidence: line.Confidence}
+		}
+		for _, amount := range amounts {
+			// Only compared, the amount itself stays exact
+			value, _ := strconv.ParseFloat(amount, 64)
+			if largest == nil || value > largestValue {
+				largest = &types.Suggestion[string]{Value: amount, Confidence: line.Confidence * guessWeight}
+				largestValue = value
+			}
+		}
+	}
+
+	if total != nil {
+		return total
+	}
+	return largest
+}
+
+func isTotalLine(line string) bool {
+	lower := strings.ToLower(line)
+	return containsAny(lower, totalKeywords) && !containsAny(lower, notTotalKeywords)
+}
+
+// findAmounts returns the prices in a line in the order they appear. Dates
+// are left out first, as 30.11.2024 would otherwise read as 30.11.
+func findAmounts(line string) []string {
+	line = isoDatePattern.ReplaceAllString(line, " ")
+	line = numericDatePattern.ReplaceAllString(line, " ")
+
+	var amounts []string
+	for _, match := range amountPattern.FindAllString(line, -1) {
+		amounts = append(amounts, normalizeAmount(match))
+	}
+	return amounts
+}
+
+// normalizeAmount writes a price whose last separator is the decimal one and
+// whose other separators group thousands with a decimal point only, e.g.
+// 1.234,50 as 1234.50.
+func normalizeAmount(s string) string {
+	whole, decimals := s[:len(s)-3], s[len(s)-2:]
+	whole = strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, whole)
+
+	return whole + "." + decimals
+}
+
+// findDate returns the first date in the text. Numeric dates are read day
+// first unless that cannot be, and then count as a guess when the day and
+// month could be swapped.
+func findDate(text Text) *types.Suggestion[time.Time] {
+	for _, line := range text.Lines {
+		if date, weight, ok := parseDate(line.Text); ok {
+			return &types.Suggestion[time.Time]{Value: date, Confidence: line.Confidence * weight}
+		}
+	}
+	return nil
+}
+
+func parseDate(line string) (time.Time, float64, bool) {
+	if m := isoDatePattern.FindStringSubmatch(line); m != nil {
+		if date, ok := makeDate(atoi(m[1]), atoi(m[2]), atoi(m[3])); ok {
+			return date, 1, true
+		}
+	}
+
+	if m := dayMonthPattern.FindStringSubmatch(line); m != nil {
+		if date, ok := makeDate(atoi(m[3]), int(months[strings.ToLower(m[2])]), atoi(m[1])); ok {
+			return date, 1, true
+		}
+	}
+
+	if m := monthDayPattern.FindStringSubmatch(line); m != nil {
+		if date, ok := makeDate(atoi(m[3]), int(months[strings.ToLower(m[1])]), atoi(m[2])); ok {
+			return date, 1, true
+		}
+	}
+
+	if m := numericDatePattern.FindStringSubmatch(line); m != nil {
+		first, second, year := atoi(m[1]), atoi(m[2]), atoi(m[3])
+		if year < 100 {
+			year += 2000
+		}
+
+		if date, ok := makeDate(year, second, first); ok {
+			if first <= 12 && second <= 12 && first != second {
+				return date, guessWeight, true
+			}
+			return date, 1, true
+		}
+		if date, ok := makeDate(year, first, second); ok {
+			return date, 1, true
+		}
+	}
+
+	return time.Time{}, 0, false
+}
+
+// makeDate builds the date, rejecting days that do not exist and years no
+// receipt is from.
+func makeDate(year, month, day int) (time.Time, bool) {
+	if year < 2000 || year > 2100 || month < 1 || month > 12 || day < 1 {
+		return time.Time{}, false
+	}
+
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// findMerchant returns the first line near the top that reads like a name:
+// mostly letters, with no price, date or contact details.
+func findMerchant(text Text) *types.Suggestion[string] {
+	for i, line := range text.Lines {
+		if i == merchantLines {
+			break
+		}
+
+		name := strings.Trim(line.Text, " .,:;-*=#")
+		if !isMerchantName(name) {
+			continue
+		}
+		return &types.Suggestion[string]{Value: name, Confidence: line.Confidence * merchantWeight}
+	}
+	return nil
+}
+
+func isMerchantName(line string) bool {
+	if containsAny(strings.ToLower(line), notMerchantKeywords) || amountPattern.MatchString(line) {
+		return false
+	}
+	if _, _, ok := parseDate(line); ok {
+		return false
+	}
+
+	letters, others := 0, 0
+	for _, r := range line {
+		switch {
+		case unicode.IsLetter(r):
+			letters++
+		case !unicode.IsSpace(r):
+			others++
+		}
+	}
+	return letters >= 3 && letters >= others
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
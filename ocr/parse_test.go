@@ -0,0 +1,112 @@
+package ocr
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("should find the merchant, total and date of a receipt", func(t *testing.T) {
+		text := NewFake(
+			"*** RECEIPT ***",
+			"Corner Grocery",
+			"12 Main Street",
+			"Date: 30/11/2024 14:02",
+			"Milk            1.29",
+			"Bread           2.50",
+			"Subtotal        3.79",
+			"Tax             0.30",
+			"TOTAL           4.09",
+			"Card           10.00",
+		).Text
+
+		s := Parse(text)
+		if s.Name == nil || s.Name.Value != "Corner Grocery" {
+			t.Errorf("expected merchant Corner Grocery, got %+v", s.Name)
+		}
+		if s.Amount == nil || s.Amount.Value != "4.09" || s.Amount.Confidence != 1 {
+			t.Errorf("expected total 4.09 with full confidence, got %+v", s.Amount)
+		}
+		want := time.Date(2024, time.November, 30, 0, 0, 0, 0, time.UTC)
+		if s.Date == nil || !s.Date.Value.Equal(want) || s.Date.Confidence != 1 {
+			t.Errorf("expected date %v with full confidence, got %+v", want, s.Date)
+		}
+	})
+
+	t.Run("should guess the largest amount without a total", func(t *testing.T) {
+		s := Parse(NewFake("Cafe", "Coffee 3.20", "Cake 1,204.50").Text)
+		if s.Amount == nil || s.Amount.Value != "1204.50" || s.Amount.Confidence != guessWeight {
+			t.Errorf("expected a guessed 1204.50, got %+v", s.Amount)
+		}
+	})
+
+	t.Run("should read decimal commas", func(t *testing.T) {
+		s := Parse(NewFake("Bäckerei", "Summe EUR", "Zu zahlen 1.234,56").Text)
+		if s.Amount == nil || s.Amount.Value != "1234.56" {
+			t.Errorf("expected 1234.56, got %+v", s.Amount)
+		}
+	})
+
+	t.Run("should read dates in other formats", func(t *testing.T) {
+		tests := map[string]time.Time{
+			"2024-03-05":    time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC),
+			"5 March 2024":  time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC),
+			"Mar 5th, 2024": time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC),
+			"03/25/2024":    time.Date(2024, time.March, 25, 0, 0, 0, 0, time.UTC),
+		}
+		for line, want := range tests {
+			s := Parse(NewFake(line).Text)
+			if s.Date == nil || !s.Date.Value.Equal(want) {
+				t.Errorf("expected %v in %q, got %+v", want, line, s.Date)
+			}
+		}
+	})
+
+	t.Run("should lower the confidence of ambiguous dates", func(t *testing.T) {
+		s := Parse(NewFake("05/03/2024").Text)
+		if s.Date == nil || s.Date.Confidence != guessWeight {
+			t.Errorf("expected a guessed date, got %+v", s.Date)
+		}
+	})
+
+	t.Run("should reject impossible dates", func(t *testing.T) {
+		s := Parse(NewFake("31/02/2024", "1999-01-01").Text)
+		if s.Date != nil {
+			t.Errorf("expected no date, got %v", s.Date.Value)
+		}
+	})
+
+	t.Run("should leave out what it cannot find", func(t *testing.T) {
+		s := Parse(NewFake("", "12345").Text)
+		if s.Name != nil || s.Amount != nil || s.Date != nil {
+			t.Errorf("expected no suggestions, got %+v", s)
+		}
+	})
+}
+
+func TestParseTSV(t *testing.T) {
+	tsv := strings.Join([]string{
+		"level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext",
+		"1\t1\t0\t0\t0\t0\t0\t0\t100\t100\t-1\t",
+		"5\t1\t1\t1\t1\t1\t0\t0\t10\t10\t90\tCorner",
+		"5\t1\t1\t1\t1\t2\t10\t0\t10\t10\t70\tGrocery",
+		"5\t1\t1\t1\t2\t1\t0\t10\t10\t10\t60\tTOTAL",
+		"5\t1\t1\t1\t2\t2\t10\t10\t10\t10\t80\t4.09",
+	}, "\n")
+
+	text, err := parseTSV(strings.NewReader(tsv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(text.Lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(text.Lines), text)
+	}
+	if text.Lines[0].Text != "Corner Grocery" || text.Lines[0].Confidence != 0.8 {
+		t.Errorf("unexpected first line %+v", text.Lines[0])
+	}
+	if text.Lines[1].Text != "TOTAL 4.09" || text.Lines[1].Confidence != 0.7 {
+		t.Errorf("unexpected second line %+v", text.Lines[1])
+	}
+}
@@ -0,0 +1,100 @@
+package ocr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Tesseract runs the tesseract command line tool, which must be installed
+// along with the data of the language.
+type Tesseract struct {
+	path     string
+	language string
+}
+
+// NewTesseract looks up the tesseract binary, by name in the PATH or by path.
+func NewTesseract(path, language string) (*Tesseract, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("tesseract not found: %w", err)
+	}
+	if language == "" {
+		language = "eng"
+	}
+	return &Tesseract{path: resolved, language: language}, nil
+}
+
+// Extract pipes the image to tesseract as a PNG and reads back its TSV
+// output, which carries a confidence for every word.
+func (t *Tesseract) Extract(ctx context.Context, img image.Image) (Text, error) {
+	var in bytes.Buffer
+	if err := png.Encode(&in, img); err != nil {
+		return Text{}, err
+	}
+
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.path, "stdin", "stdout", "-l", t.language, "tsv")
+	cmd.Stdin = &in
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Text{}, fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseTSV(&out)
+}
+
+// parseTSV joins the words of tesseract's TSV output into lines. A line's
+// confidence is the average of its words'.
+func parseTSV(r io.Reader) (Text, error) {
+	type lineKey struct{ page, block, par, line string }
+
+	var text Text
+	var current lineKey
+	var words []string
+	var confidence float64
+
+	flush := func() {
+		if len(words) > 0 {
+			text.Lines = append(text.Lines, Line{
+				Text:       strings.Join(words, " "),
+				Confidence: confidence / float64(len(words)) / 100,
+			})
+		}
+		words, confidence = nil, 0
+	}
+
+	scanner := bufio.NewScanner(r)
+	for first := true; scanner.Scan(); first = false {
+		// level page_num block_num par_num line_num word_num left top width height conf text
+		cols := strings.Split(scanner.Text(), "\t")
+		if first || len(cols) < 12 {
+			continue
+		}
+
+		word := strings.TrimSpace(cols[11])
+		conf, err := strconv.ParseFloat(cols[10], 64)
+		if err != nil || conf < 0 || word == "" {
+			continue
+		}
+
+		key := lineKey{cols[1], cols[2], cols[3], cols[4]}
+		if key != current {
+			flush()
+			current = key
+		}
+		words = append(words, word)
+		confidence += conf
+	}
+	flush()
+
+	return text, scanner.Err()
+}
@@ -0,0 +1,177 @@
+// Package queue runs the background jobs of a types.JobStore on a pool of
+// workers, retrying failed jobs with exponential backoff.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/groshiniprasad/uploady/types"
+)
+
+// maxBackoff caps the wait before a retry.
+const maxBackoff = time.Hour
+
+// Func does the work of a job. Its result is stored as the job's JSON
+// result. Errors are retried unless they are wrapped with Permanent.
+type Func func(ctx context.Context, job *types.Job) (any, error)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying cannot fix, such as a corrupt
+// image, so that the job fails right away.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsFinalAttempt reports whether the job fails for good if this attempt
+// fails.
+func IsFinalAttempt(job *types.Job, err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts
+}
+
+// Pool claims due jobs from the store and runs them on a fixed number of
+// workers.
+type Pool struct {
+	store        types.JobStore
+	workers      int
+	pollInterval time.Duration
+	lease        time.Duration
+	backoff      time.Duration
+	funcs        map[string]Func
+
+	wg sync.WaitGroup
+	// jobCtx is handed to running jobs and only cancelled when draining
+	// the pool takes too long
+	jobCtx     context.Context
+	cancelJobs context.CancelFunc
+}
+
+func New(store types.JobStore, workers int, pollInterval, lease, backoff time.Duration) *Pool {
+	jobCtx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		store:        store,
+		workers:      workers,
+		pollInterval: pollInterval,
+		lease:        lease,
+		backoff:      backoff,
+		funcs:        map[string]Func{},
+		jobCtx:       jobCtx,
+		cancelJobs:   cancel,
+	}
+}
+
+// Register sets the function running the jobs of a kind. It must be called
+// before Start.
+func (p *Pool) Register(kind string, fn Func) {
+	p.funcs[kind] = fn
+}
+
+// Start starts the workers. They stop claiming jobs once ctx is done; Drain
+// waits for the jobs they are running.
+func (p *Pool) Start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+}
+
+// Drain waits for the running jobs to finish. When ctx is done first, the
+// jobs are cancelled and left to be claimed again once their lease expires.
+func (p *Pool) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.cancelJobs()
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	defer p.wg.Done()
+
+	for ctx.Err() == nil {
+		ran, err := p.RunNext(time.Now())
+		if err != nil {
+			log.Printf("failed to run job: %v", err)
+		}
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+// RunNext runs the next job due at now, if any, and reports whether there
+// was one.
+func (p *Pool) RunNext(now time.Time) (bool, error) {
+	job, err := p.store.ClaimJob(now, p.lease)
+	if err != nil {
+		return false, err
+	}
+	if job == nil {
+		return false, nil
+	}
+
+	result, err := p.run(job)
+	if err == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return true, p.store.FailJob(job.ID, fmt.Sprintf("failed to encode result: %v", err))
+		}
+		return true, p.store.CompleteJob(job.ID, data)
+	}
+
+	log.Printf("job %d (%s) failed on attempt %d of %d: %v", job.ID, job.Kind, job.Attempts, job.MaxAttempts, err)
+	if IsFinalAttempt(job, err) {
+		return true, p.store.FailJob(job.ID, err.Error())
+	}
+	return true, p.store.RetryJob(job.ID, now.Add(p.backoffFor(job.Attempts)), err.Error())
+}
+
+func (p *Pool) run(job *types.Job) (result any, err error) {
+	fn, ok := p.funcs[job.Kind]
+	if !ok {
+		return nil, Permanent(fmt.Errorf("unknown job kind %q", job.Kind))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	return fn(p.jobCtx, job)
+}
+
+// backoffFor is the wait after the given number of attempts: the backoff,
+// doubled for every attempt after the first.
+func (p *Pool) backoffFor(attempts int) time.Duration {
+	wait := p.backoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
@@ -0,0 +1,204 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/groshiniprasad/uploady/types"
+)
+
+func TestPool(t *testing.T) {
+	now := time.Date(2024, 11, 23, 10, 0, 0, 0, time.UTC)
+
+	t.Run("should store the result of a job", func(t *testing.T) {
+		store := newMockJobStore()
+		id, _ := store.EnqueueJob(types.Job{Kind: "echo", Payload: json.RawMessage(`"hi"`), MaxAttempts: 3, RunAt: now})
+
+		pool := New(store, 1, time.Second, time.Minute, time.Second)
+		pool.Register("echo", func(ctx context.Context, job *types.Job) (any, error) {
+			return job.Payload, nil
+		})
+
+		ran, err := pool.RunNext(now)
+		if err != nil || !ran {
+			t.Fatalf("expected a job to run, got %v, %v", ran, err)
+		}
+
+		job := store.jobs[id]
+		if job.Status != types.JobSucceeded || string(job.Result) != `"hi"` {
+			t.Errorf("unexpected job %+v", job)
+		}
+	})
+
+	t.Run("should retry with exponential backoff", func(t *testing.T) {
+		store := newMockJobStore()
+		id, _ := store.EnqueueJob(types.Job{Kind: "flaky", MaxAttempts: 3, RunAt: now})
+
+		pool := New(store, 1, time.Second, time.Minute, 10*time.Second)
+		pool.Register("flaky", func(ctx context.Context, job *types.Job) (any, error) {
+			return nil, errors.New("try again")
+		})
+
+		pool.RunNext(now)
+		if job := store.jobs[id]; job.Status != types.JobQueued || !job.RunAt.Equal(now.Add(10*time.Second)) {
+			t.Fatalf("expected a retry in 10s, got %+v", job)
+		}
+
+		if ran, _ := pool.RunNext(now.Add(5 * time.Second)); ran {
+			t.Fatal("expected the job to wait for its backoff")
+		}
+
+		pool.RunNext(now.Add(10 * time.Second))
+		if job := store.jobs[id]; !job.RunAt.Equal(now.Add(30 * time.Second)) {
+			t.Fatalf("expected the backoff to double, got %+v", job)
+		}
+
+		pool.RunNext(now.Add(30 * time.Second))
+		if job := store.jobs[id]; job.Status != types.JobFailed || job.LastError != "try again" || job.Attempts != 3 {
+			t.Errorf("expected the job to fail after 3 attempts, got %+v", job)
+		}
+	})
+
+	t.Run("should not retry permanent errors", func(t *testing.T) {
+		store := newMockJobStore()
+		id, _ := store.EnqueueJob(types.Job{Kind: "broken", MaxAttempts: 5, RunAt: now})
+
+		pool := New(store, 1, time.Second, time.Minute, time.Second)
+		pool.Register("broken", func(ctx context.Context, job *types.Job) (any, error) {
+			return nil, Permanent(errors.New("corrupt image"))
+		})
+
+		pool.RunNext(now)
+		if job := store.jobs[id]; job.Status != types.JobFailed || job.Attempts != 1 {
+			t.Errorf("expected the job to fail at once, got %+v", job)
+		}
+	})
+
+	t.Run("should fail jobs of unknown kinds and survive panics", func(t *testing.T) {
+		store := newMockJobStore()
+		unknown, _ := store.EnqueueJob(types.Job{Kind: "unknown", MaxAttempts: 5, RunAt: now})
+		panicky, _ := store.EnqueueJob(types.Job{Kind: "panic", MaxAttempts: 1, RunAt: now})
+
+		pool := New(store, 1, time.Second, time.Minute, time.Second)
+		pool.Register("panic", func(ctx context.Context, job *types.Job) (any, error) {
+			panic("boom")
+		})
+
+		pool.RunNext(now)
+		pool.RunNext(now)
+		if store.jobs[unknown].Status != types.JobFailed || store.jobs[panicky].Status != types.JobFailed {
+			t.Errorf("expected both jobs to fail, got %+v and %+v", store.jobs[unknown], store.jobs[panicky])
+		}
+	})
+
+	t.Run("should finish running jobs when drained", func(t *testing.T) {
+		store := newMockJobStore()
+		id, _ := store.EnqueueJob(types.Job{Kind: "slow", MaxAttempts: 1, RunAt: time.Now()})
+
+		started := make(chan struct{})
+		pool := New(store, 2, 10*time.Millisecond, time.Minute, time.Second)
+		pool.Register("slow", func(ctx context.Context, job *types.Job) (any, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			return nil, ctx.Err()
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		pool.Start(ctx)
+		<-started
+		cancel()
+
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Second)
+		defer cancelDrain()
+		if err := pool.Drain(drainCtx); err != nil {
+			t.Fatal(err)
+		}
+
+		if job := store.get(id); job.Status != types.JobSucceeded {
+			t.Errorf("expected the running job to finish, got %+v", job)
+		}
+	})
+}
+
+type mockJobStore struct {
+	mu   sync.Mutex
+	jobs map[int]*types.Job
+	next int
+}
+
+func newMockJobStore() *mockJobStore {
+	return &mockJobStore{jobs: map[int]*types.Job{}}
+}
+
+func (m *mockJobStore) get(id int) types.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.jobs[id]
+}
+
+func (m *mockJobStore) EnqueueJob(job types.Job) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.next++
+	job.ID = m.next
+	job.Status = types.JobQueued
+	m.jobs[job.ID] = &job
+	return job.ID, nil
+}
+
+func (m *mockJobStore) GetJob(id int, userId int) (*types.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := *m.jobs[id]
+	return &job, nil
+}
+
+func (m *mockJobStore) ClaimJob(now time.Time, lease time.Duration) (*types.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := 1; id <= m.next; id++ {
+		job := m.jobs[id]
+		if job.Status == types.JobQueued && !job.RunAt.After(now) {
+			job.Status = types.JobRunning
+			job.Attempts++
+			claimed := *job
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockJobStore) CompleteJob(id int, result json.RawMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[id].Status = types.JobSucceeded
+	m.jobs[id].Result = result
+	return nil
+}
+
+func (m *mockJobStore) RetryJob(id int, runAt time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[id].Status = types.JobQueued
+	m.jobs[id].RunAt = runAt
+	m.jobs[id].LastError = lastError
+	return nil
+}
+
+func (m *mockJobStore) FailJob(id int, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[id].Status = types.JobFailed
+	m.jobs[id].LastError = lastError
+	return nil
+}
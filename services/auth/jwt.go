@@ -62,6 +62,19 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.Handl
 	}
 }
 
+// WithAdminAuth is WithJWTAuth for the handlers only admins may call.
+func WithAdminAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+	return WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		u, err := store.GetUserByID(GetUserIDFromContext(r.Context()))
+		if err != nil || !u.IsAdmin {
+			permissionDenied(w)
+			return
+		}
+
+		handlerFunc(w, r)
+	}, store)
+}
+
 func CreateJWT(secret []byte, userID int) (string, error) {
 	expiration := time.Second * time.Duration(configs.Envs.JWTExpirationInSeconds)
 
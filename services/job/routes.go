@@ -0,0 +1,50 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+type Handler struct {
+	store     types.JobStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.JobStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/jobs/{id}", auth.WithJWTAuth(h.handleGetJob, h.userStore)).Methods(http.MethodGet)
+}
+
+// handleGetJob reports the status of one of the user's jobs, with its
+// result once it succeeded.
+func (h *Handler) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid job ID"))
+		return
+	}
+
+	job, err := h.store.GetJob(id, userID)
+	if errors.Is(err, ErrJobNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, job)
+}
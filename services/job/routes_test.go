@@ -0,0 +1,65 @@
+package job
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+func TestJobServiceHandlers(t *testing.T) {
+	handler := NewHandler(&mockJobStore{}, nil)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/jobs/{id}", handler.handleGetJob).Methods(http.MethodGet)
+
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should fail if the job ID is not a number", func(t *testing.T) {
+		rr := get("/jobs/abc")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should report the status of a job", func(t *testing.T) {
+		rr := get("/jobs/1")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), `"status":"succeeded"`) || !strings.Contains(rr.Body.String(), `"result":{"warning":"hi"}`) {
+			t.Errorf("unexpected job %s", rr.Body)
+		}
+	})
+
+	t.Run("should fail if the job does not exist", func(t *testing.T) {
+		rr := get("/jobs/2")
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+type mockJobStore struct {
+	types.JobStore
+}
+
+func (m *mockJobStore) GetJob(id int, userId int) (*types.Job, error) {
+	if id != 1 {
+		return nil, ErrJobNotFound
+	}
+	return &types.Job{ID: 1, Kind: "receipt.process", Status: types.JobSucceeded, Result: []byte(`{"warning":"hi"}`)}, nil
+}
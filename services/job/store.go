@@ -0,0 +1,171 @@
+package job
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/groshiniprasad/uploady/types"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+const jobColumns = "id, userId, kind, payload, status, attempts, maxAttempts, lastError, result, runAt, createdAt, finishedAt"
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) EnqueueJob(job types.Job) (int, error) {
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	res, err := s.db.Exec("INSERT INTO jobs (userId, kind, payload, status, maxAttempts, runAt) VALUES (?, ?, ?, ?, ?, ?)",
+		job.UserID, job.Kind, string(job.Payload), types.JobQueued, job.MaxAttempts, runAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve last insert ID: %w", err)
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetJob(id int, userId int) (*types.Job, error) {
+	row := s.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = ? AND userId = ?", id, userId)
+
+	job, err := scanRowIntoJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+
+	return job, nil
+}
+
+// ClaimJob locks the oldest due job, skipping rows other workers have
+// locked, so that concurrent workers never claim the same job.
+func (s *Store) ClaimJob(now time.Time, lease time.Duration) (*types.Job, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("SELECT "+jobColumns+` FROM jobs
+		WHERE (status = ? AND runAt <= ?) OR (status = ? AND lockedAt < ?)
+		ORDER BY runAt, id LIMIT 1 FOR UPDATE SKIP LOCKED`,
+		types.JobQueued, now, types.JobRunning, now.Add(-lease))
+
+	job, err := scanRowIntoJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE jobs SET status = ?, attempts = attempts + 1, lockedAt = ? WHERE id = ?", types.JobRunning, now, job.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	job.Status = types.JobRunning
+	job.Attempts++
+	return job, nil
+}
+
+func (s *Store) CompleteJob(id int, result json.RawMessage) error {
+	_, err := s.db.Exec("UPDATE jobs SET status = ?, result = ?, lastError = NULL, lockedAt = NULL, finishedAt = NOW() WHERE id = ?",
+		types.JobSucceeded, nullJSON(result), id)
+	if err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) RetryJob(id int, runAt time.Time, lastError string) error {
+	_, err := s.db.Exec("UPDATE jobs SET status = ?, runAt = ?, lastError = ?, lockedAt = NULL WHERE id = ?",
+		types.JobQueued, runAt, lastError, id)
+	if err != nil {
+		return fmt.Errorf("failed to retry job: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) FailJob(id int, lastError string) error {
+	_, err := s.db.Exec("UPDATE jobs SET status = ?, lastError = ?, lockedAt = NULL, finishedAt = NOW() WHERE id = ?",
+		types.JobFailed, lastError, id)
+	if err != nil {
+		return fmt.Errorf("failed to fail job: %w", err)
+	}
+
+	return nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRowIntoJob(row rowScanner) (*types.Job, error) {
+	job := new(types.Job)
+	var payload []byte
+	var lastError sql.NullString
+	var result []byte
+	var finishedAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Kind,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&lastError,
+		&result,
+		&job.RunAt,
+		&job.CreatedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = payload
+	job.LastError = lastError.String
+	if len(result) > 0 {
+		job.Result = result
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	return job, nil
+}
+
+func nullJSON(data json.RawMessage) sql.NullString {
+	if len(data) == 0 || string(data) == "null" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
@@ -0,0 +1,123 @@
+package rate
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+// ParseRate reads the rate of a pair on a day given as 2006-01-02, as sent
+// to the API or found in an import file.
+func ParseRate(date, base, quote, rate string) (types.ExchangeRate, error) {
+	day, err := time.Parse(time.DateOnly, strings.TrimSpace(date))
+	if err != nil {
+		return types.ExchangeRate{}, fmt.Errorf("invalid date %q, use 2006-01-02", date)
+	}
+
+	r := types.ExchangeRate{Date: day}
+	if r.Base, err = money.ParseCurrency(base); err != nil {
+		return types.ExchangeRate{}, fmt.Errorf("invalid base %q: %w", base, err)
+	}
+	if r.Quote, err = money.ParseCurrency(quote); err != nil {
+		return types.ExchangeRate{}, fmt.Errorf("invalid quote %q: %w", quote, err)
+	}
+	if r.Base == r.Quote {
+		return types.ExchangeRate{}, fmt.Errorf("the base and the quote are both %s", r.Base)
+	}
+	if r.Rate, err = money.ParseRate(rate); err != nil {
+		return types.ExchangeRate{}, err
+	}
+
+	return r, nil
+}
+
+// ReadCSV reads rates from a CSV file with a date,base,quote,rate header,
+// the columns being in any order.
+func ReadCSV(r io.Reader) ([]types.ExchangeRate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CSV header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"date", "base", "quote", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("the CSV header has no %s column", name)
+		}
+	}
+
+	var rates []types.ExchangeRate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CSV: %w", err)
+		}
+
+		rate, err := ParseRate(record[columns["date"]], record[columns["base"]], record[columns["quote"]], record[columns["rate"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+// ecbEnvelope is the reference rates file of the European Central Bank,
+// the daily one or the historical ones, with the rates of a euro by day.
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ReadECB reads rates from a euro foreign exchange reference rates file of
+// the European Central Bank, such as eurofxref-hist-90d.xml. Currencies that
+// are not in use any more are skipped.
+func ReadECB(r io.Reader) ([]types.ExchangeRate, error) {
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("failed to read the ECB XML: %w", err)
+	}
+
+	var rates []types.ExchangeRate
+	for _, day := range envelope.Days {
+		for _, r := range day.Rates {
+			if _, err := money.ParseCurrency(r.Currency); err != nil {
+				continue
+			}
+
+			rate, err := ParseRate(day.Time, "EUR", r.Currency, r.Rate)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", day.Time, r.Currency, err)
+			}
+			rates = append(rates, rate)
+		}
+	}
+
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("the ECB XML has no rates")
+	}
+
+	return rates, nil
+}
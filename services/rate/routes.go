@@ -0,0 +1,107 @@
+package rate
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+// MaxUpsertRates bounds the rates of one request; larger sets are imported
+// with cmd/rates.
+const MaxUpsertRates = 1000
+
+type Handler struct {
+	store     types.ExchangeRateStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.ExchangeRateStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/exchange-rates", auth.WithAdminAuth(h.handleUpsertRates, h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/exchange-rates/{base}/{quote}", auth.WithJWTAuth(h.handleGetRate, h.userStore)).Methods(http.MethodGet)
+}
+
+// handleUpsertRates sets the rates of currency pairs by day, replacing the
+// rates already set for the same pair and day.
+func (h *Handler) handleUpsertRates(w http.ResponseWriter, r *http.Request) {
+	var payload types.UpsertExchangeRatesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+	if len(payload.Rates) > MaxUpsertRates {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("at most %d rates can be set at once", MaxUpsertRates))
+		return
+	}
+
+	rates := make([]types.ExchangeRate, len(payload.Rates))
+	for i, p := range payload.Rates {
+		rate, err := ParseRate(p.Date, p.Base, p.Quote, p.Rate.String())
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("rates[%d]: %w", i, err))
+			return
+		}
+		rates[i] = rate
+	}
+
+	if err := h.store.UpsertExchangeRates(rates); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]int{"updated": len(rates)})
+}
+
+// handleGetRate returns the rate effective on the date given as 2006-01-02,
+// today by default, the way receipt amounts are converted.
+func (h *Handler) handleGetRate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	base, err := money.ParseCurrency(vars["base"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid base: %w", err))
+		return
+	}
+	quote, err := money.ParseCurrency(vars["quote"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid quote: %w", err))
+		return
+	}
+
+	on := time.Now().UTC()
+	if str := r.URL.Query().Get("date"); str != "" {
+		if on, err = time.Parse(time.DateOnly, str); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid date, use 2006-01-02"))
+			return
+		}
+	}
+
+	table, err := Load(h.store, []money.Currency{base, quote}, on, on, MaxAge())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rate, date, ok := table.Find(base, quote, on)
+	if !ok {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("no %s to %s rate on %s", base, quote, on.Format(time.DateOnly)))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.ExchangeRate{Date: date, Base: base, Quote: quote, Rate: rate})
+}
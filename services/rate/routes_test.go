@@ -0,0 +1,212 @@
+package rate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+const ecbXML = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2024-11-29">
+			<Cube currency="USD" rate="1.0565"/>
+			<Cube currency="GBP" rate="0.8329"/>
+			<Cube currency="INR" rate="89.3500"/>
+		</Cube>
+		<Cube time="2024-11-28">
+			<Cube currency="USD" rate="1.0537"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestImport(t *testing.T) {
+	t.Run("should read the rates of a euro from ECB XML", func(t *testing.T) {
+		rates, err := ReadECB(strings.NewReader(ecbXML))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rates) != 4 {
+			t.Fatalf("expected 4 rates, got %d", len(rates))
+		}
+		if r := rates[2]; r.Base != "EUR" || r.Quote != "INR" || r.Rate.String() != "89.35" || r.Date.Format(time.DateOnly) != "2024-11-29" {
+			t.Errorf("unexpected rate %+v", r)
+		}
+	})
+
+	t.Run("should read rates from CSV", func(t *testing.T) {
+		rates, err := ReadCSV(strings.NewReader("quote,base,date,rate\nUSD,gbp,2024-11-29,1.2690\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rates) != 1 || rates[0].Base != "GBP" || rates[0].Quote != "USD" || rates[0].Rate.String() != "1.269" {
+			t.Errorf("unexpected rates %+v", rates)
+		}
+	})
+
+	t.Run("should report the line of an invalid rate", func(t *testing.T) {
+		_, err := ReadCSV(strings.NewReader("date,base,quote,rate\n2024-11-29,GBP,USD,1.2690\n2024-11-29,GBP,USD,-1\n"))
+		if err == nil || !strings.Contains(err.Error(), "line 3") {
+			t.Errorf("expected an error on line 3, got %v", err)
+		}
+	})
+}
+
+func TestTable(t *testing.T) {
+	rates, err := ReadECB(strings.NewReader(ecbXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := NewTable(rates, 7*24*time.Hour)
+
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+
+	t.Run("should use the latest rate on or before the day", func(t *testing.T) {
+		rate, date, ok := table.Find("EUR", "USD", day("2024-11-28").Add(15*time.Hour))
+		if !ok || rate.String() != "1.0537" || !date.Equal(day("2024-11-28")) {
+			t.Errorf("unexpected rate %s of %v", rate, date)
+		}
+
+		// Saturday uses Friday's rate
+		if rate, _, _ := table.Find("EUR", "USD", day("2024-11-30")); rate.String() != "1.0565" {
+			t.Errorf("expected Friday's rate, got %s", rate)
+		}
+	})
+
+	t.Run("should invert and cross rates", func(t *testing.T) {
+		got := table.Convert(money.New(1000, "USD"), "USD", "EUR", day("2024-11-29"))
+		if got == nil || got.Amount.String() != "9.47" || got.Currency != "EUR" {
+			t.Errorf("unexpected conversion %+v", got)
+		}
+
+		got = table.Convert(money.New(100000, "INR"), "INR", "GBP", day("2024-11-29"))
+		if got == nil || got.Amount.String() != "9.32" {
+			t.Errorf("unexpected conversion %+v", got)
+		}
+	})
+
+	t.Run("should not convert without a recent rate", func(t *testing.T) {
+		if got := table.Convert(money.New(1000, "EUR"), "EUR", "USD", day("2024-11-27")); got != nil {
+			t.Errorf("expected no rate before the first one, got %+v", got)
+		}
+		if got := table.Convert(money.New(1000, "EUR"), "EUR", "USD", day("2024-12-07")); got != nil {
+			t.Errorf("expected the rate to be stale, got %+v", got)
+		}
+		if got := table.Convert(money.New(1000, "EUR"), "EUR", "JPY", day("2024-11-29")); got != nil {
+			t.Errorf("expected no rate for JPY, got %+v", got)
+		}
+	})
+}
+
+func TestRateHandlers(t *testing.T) {
+	store := &mockRateStore{}
+	handler := NewHandler(store, &mockUserStore{})
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/exchange-rates", handler.handleUpsertRates).Methods(http.MethodPut)
+		router.HandleFunc("/exchange-rates/{base}/{quote}", handler.handleGetRate).Methods(http.MethodGet)
+
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should refuse invalid rates", func(t *testing.T) {
+		for _, body := range []string{
+			`{"rates": []}`,
+			`{"rates": [{"date": "2024-11-29", "base": "EUR", "quote": "USD", "rate": 0}]}`,
+			`{"rates": [{"date": "29.11.2024", "base": "EUR", "quote": "USD", "rate": 1.05}]}`,
+			`{"rates": [{"date": "2024-11-29", "base": "EUR", "quote": "EUR", "rate": 1}]}`,
+		} {
+			if rr := serve(http.MethodPut, "/exchange-rates", body); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, body, rr.Code)
+			}
+		}
+		if len(store.rates) != 0 {
+			t.Errorf("expected no rates to be stored, got %+v", store.rates)
+		}
+	})
+
+	t.Run("should store the rates", func(t *testing.T) {
+		rr := serve(http.MethodPut, "/exchange-rates", `{"rates": [{"date": "2024-11-29", "base": "gbp", "quote": "USD", "rate": "1.2690"}]}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if len(store.rates) != 1 || store.rates[0].Base != "GBP" || store.rates[0].Rate.String() != "1.269" {
+			t.Errorf("unexpected rates %+v", store.rates)
+		}
+	})
+
+	t.Run("should return the rate effective on the date", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/exchange-rates/USD/GBP?date=2024-12-01", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var res struct {
+			Date string `json:"date"`
+			Rate string `json:"rate"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(res.Date, "2024-11-29") || res.Rate != "0.7880220646" {
+			t.Errorf("unexpected rate %+v", res)
+		}
+
+		if rr := serve(http.MethodGet, "/exchange-rates/USD/GBP?date=2024-11-01", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+type mockRateStore struct {
+	rates []types.ExchangeRate
+}
+
+func (m *mockRateStore) UpsertExchangeRates(rates []types.ExchangeRate) error {
+	m.rates = append(m.rates, rates...)
+	return nil
+}
+
+func (m *mockRateStore) ListExchangeRates(currencies []money.Currency, from, to time.Time) ([]types.ExchangeRate, error) {
+	var rates []types.ExchangeRate
+	for _, rate := range m.rates {
+		if !rate.Date.Before(from) && !rate.Date.After(to) {
+			rates = append(rates, rate)
+		}
+	}
+	return rates, nil
+}
+
+type mockUserStore struct{}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
+
+func (m *mockUserStore) CreateUser(u types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	return &types.User{}, nil
+}
@@ -0,0 +1,87 @@
+package rate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+// upsertBatchSize bounds the rows of one INSERT, a year of ECB rates being
+// some 8000 of them.
+const upsertBatchSize = 500
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) UpsertExchangeRates(rates []types.ExchangeRate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to store exchange rates: %w", err)
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(rates); start += upsertBatchSize {
+		batch := rates[start:min(start+upsertBatchSize, len(rates))]
+
+		args := make([]any, 0, 4*len(batch))
+		for _, rate := range batch {
+			args = append(args, rate.Date.Format(time.DateOnly), rate.Base, rate.Quote, rate.Rate)
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?), ", len(batch)), ", ")
+		_, err := tx.Exec("INSERT INTO exchange_rates (date, base, quote, rate) VALUES "+placeholders+" ON DUPLICATE KEY UPDATE rate = VALUES(rate)", args...)
+		if err != nil {
+			return fmt.Errorf("failed to store exchange rates: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to store exchange rates: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) ListExchangeRates(currencies []money.Currency, from, to time.Time) ([]types.ExchangeRate, error) {
+	if len(currencies) == 0 {
+		return nil, nil
+	}
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(currencies)), ", ")
+	args := []any{from.Format(time.DateOnly), to.Format(time.DateOnly)}
+	for range 2 {
+		for _, c := range currencies {
+			args = append(args, c)
+		}
+	}
+
+	rows, err := s.db.Query("SELECT date, base, quote, rate FROM exchange_rates WHERE date BETWEEN ? AND ? AND (base IN ("+in+") OR quote IN ("+in+")) ORDER BY date", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exchange rates: %w", err)
+	}
+	defer rows.Close()
+
+	rates := []types.ExchangeRate{}
+	for rows.Next() {
+		var rate types.ExchangeRate
+		var str string
+		if err := rows.Scan(&rate.Date, &rate.Base, &rate.Quote, &str); err != nil {
+			return nil, fmt.Errorf("failed to scan exchange rate: %w", err)
+		}
+		if rate.Rate, err = money.ParseRate(str); err != nil {
+			return nil, fmt.Errorf("failed to scan exchange rate: %w", err)
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
@@ -0,0 +1,151 @@
+package rate
+
+import (
+	"slices"
+	"time"
+
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+type pair struct {
+	base, quote money.Currency
+}
+
+// Table looks up the rate effective on a day: the pair's latest rate on or
+// before it, as long as it is at most maxAge old. Pairs without a rate of
+// their own are converted through the inverse pair, or through a currency
+// both have rates for, such as the euro for rates published by the ECB.
+type Table struct {
+	rates  map[pair][]types.ExchangeRate
+	maxAge time.Duration
+}
+
+// MaxAge is how old a rate may be, from EXCHANGE_RATE_MAX_AGE_IN_DAYS.
+func MaxAge() time.Duration {
+	return time.Duration(configs.Envs.ExchangeRateMaxAgeInDays) * 24 * time.Hour
+}
+
+// NewTable indexes the rates by pair.
+func NewTable(rates []types.ExchangeRate, maxAge time.Duration) *Table {
+	t := &Table{rates: map[pair][]types.ExchangeRate{}, maxAge: maxAge}
+	for _, rate := range rates {
+		p := pair{rate.Base, rate.Quote}
+		t.rates[p] = append(t.rates[p], rate)
+	}
+	for _, rates := range t.rates {
+		slices.SortFunc(rates, func(a, b types.ExchangeRate) int { return a.Date.Compare(b.Date) })
+	}
+	return t
+}
+
+// Load reads the rates needed to convert between the currencies on the days
+// from to to.
+func Load(store types.ExchangeRateStore, currencies []money.Currency, from, to time.Time, maxAge time.Duration) (*Table, error) {
+	rates, err := store.ListExchangeRates(currencies, from.Add(-maxAge), to)
+	if err != nil {
+		return nil, err
+	}
+	return NewTable(rates, maxAge), nil
+}
+
+// Find returns the rate from base to quote effective on the day, with the
+// date it was set, preferring a rate of the pair or its inverse over one
+// through another currency. It returns false when there is none.
+func (t *Table) Find(base, quote money.Currency, on time.Time) (money.Rate, time.Time, bool) {
+	// Rates are set for whole days
+	on = time.Date(on.Year(), on.Month(), on.Day(), 0, 0, 0, 0, time.UTC)
+
+	if base == quote {
+		one, _ := money.ParseRate("1")
+		return one, on, true
+	}
+
+	if rate, date, ok := t.direct(base, quote, on); ok {
+		return rate, date, true
+	}
+
+	// Through the currency with the most recent rates, in a stable order
+	var best money.Rate
+	var bestDate time.Time
+	for _, via := range t.currencies() {
+		if via == base || via == quote {
+			continue
+		}
+		first, firstDate, ok := t.direct(base, via, on)
+		if !ok {
+			continue
+		}
+		second, secondDate, ok := t.direct(via, quote, on)
+		if !ok {
+			continue
+		}
+
+		// A cross rate is as old as the older of its rates
+		date := firstDate
+		if secondDate.Before(date) {
+			date = secondDate
+		}
+		if best.IsZero() || date.After(bestDate) {
+			best, bestDate = first.Mul(second), date
+		}
+	}
+
+	return best, bestDate, !best.IsZero()
+}
+
+// Convert converts the amount into the currency at the rate effective on
+// the day, or returns nil when there is none.
+func (t *Table) Convert(amount money.Amount, from, to money.Currency, on time.Time) *types.ConvertedAmount {
+	rate, date, ok := t.Find(from, to, on)
+	if !ok {
+		return nil
+	}
+
+	return &types.ConvertedAmount{
+		Amount:   amount.Convert(rate, to),
+		Currency: to,
+		Rate:     rate,
+		RateDate: date,
+	}
+}
+
+// direct looks up the rate of the pair or of its inverse, whichever was set
+// last.
+func (t *Table) direct(base, quote money.Currency, on time.Time) (money.Rate, time.Time, bool) {
+	rate, ok := t.latest(pair{base, quote}, on)
+	inverse, inverseOK := t.latest(pair{quote, base}, on)
+	switch {
+	case ok && (!inverseOK || !inverse.Date.After(rate.Date)):
+		return rate.Rate, rate.Date, true
+	case inverseOK:
+		return inverse.Rate.Inverse(), inverse.Date, true
+	default:
+		return money.Rate{}, time.Time{}, false
+	}
+}
+
+// latest returns the pair's last rate on or before the day, unless it is
+// older than maxAge.
+func (t *Table) latest(p pair, on time.Time) (types.ExchangeRate, bool) {
+	rates := t.rates[p]
+	i, found := slices.BinarySearchFunc(rates, on, func(rate types.ExchangeRate, on time.Time) int { return rate.Date.Compare(on) })
+	if found {
+		return rates[i], true
+	}
+	if i == 0 || on.Sub(rates[i-1].Date) > t.maxAge {
+		return types.ExchangeRate{}, false
+	}
+	return rates[i-1], true
+}
+
+// currencies returns the currencies of the table in order.
+func (t *Table) currencies() []money.Currency {
+	var currencies []money.Currency
+	for p := range t.rates {
+		currencies = append(currencies, p.base, p.quote)
+	}
+	slices.Sort(currencies)
+	return slices.Compact(currencies)
+}
@@ -0,0 +1,256 @@
+package receipt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+func (h *Handler) handleGetAttachments(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	attachments, err := h.store.ListAttachments(receiptID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, attachments)
+}
+
+// handleAddAttachment appends the uploaded "file" to the receipt's
+// attachments.
+func (h *Handler) handleAddAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	attachment, upload, written, ok := h.receiveAttachment(w, r)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+	attachment.ReceiptID = receiptID
+
+	created, err := h.store.AddAttachment(*attachment, userID)
+	if err != nil {
+		h.discardBlob(written, attachment.ImageKey)
+		writeStoreError(w, err)
+		return
+	}
+	h.keepBlob(r.Context(), upload)
+	h.reprocess(receiptID, userID)
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+// handleReplaceAttachment swaps the file of the attachment at position n for
+// the uploaded "file".
+func (h *Handler) handleReplaceAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	position, err := parseAttachmentPosition(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	attachment, upload, written, ok := h.receiveAttachment(w, r)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+	attachment.ReceiptID = receiptID
+	attachment.Position = position
+
+	orphaned, err := h.store.ReplaceAttachment(*attachment, userID)
+	if err != nil {
+		h.discardBlob(written, attachment.ImageKey)
+		writeStoreError(w, err)
+		return
+	}
+	h.keepBlob(r.Context(), upload)
+	h.cache.InvalidateReceipt(receiptID)
+	h.discardBlob(orphaned != "", orphaned)
+	h.reprocess(receiptID, userID)
+
+	replaced, err := h.store.GetAttachment(receiptID, userID, position)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, replaced)
+}
+
+func (h *Handler) handleRemoveAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	position, err := parseAttachmentPosition(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	orphaned, err := h.store.RemoveAttachment(receiptID, userID, position)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.cache.InvalidateReceipt(receiptID)
+	h.discardBlob(orphaned != "", orphaned)
+	if position == 0 {
+		// The next attachment became the primary one
+		h.reprocess(receiptID, userID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReorderAttachments takes the current positions in their new order,
+// e.g. {"order": [2, 0, 1]} moves the last attachment to the front.
+func (h *Handler) handleReorderAttachments(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.ReorderAttachmentsPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	if err := h.store.ReorderAttachments(receiptID, userID, payload.Order); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.cache.InvalidateReceipt(receiptID)
+	if payload.Order[0] != 0 {
+		h.reprocess(receiptID, userID)
+	}
+
+	attachments, err := h.store.ListAttachments(receiptID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, attachments)
+}
+
+// receiveAttachment stores the multipart "file" of the request. It returns
+// the spooled upload, which the caller must close, reports whether the blob
+// was written by this request and writes the error response itself when it
+// fails.
+func (h *Handler) receiveAttachment(w http.ResponseWriter, r *http.Request) (*types.Attachment, *spooledUpload, bool, bool) {
+	form, status, err := readUploadForm(w, r, "file")
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return nil, nil, false, false
+	}
+	upload := form.upload
+
+	phash, err := upload.fingerprint(r.Context())
+	if err != nil {
+		form.Close()
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return nil, nil, false, false
+	}
+
+	written, err := storeBlob(r.Context(), h.blobs, upload)
+	if err != nil {
+		form.Close()
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error saving file: %v", err))
+		return nil, nil, false, false
+	}
+
+	return &types.Attachment{
+		ImageKey:    upload.key(),
+		ImageDigest: upload.digest,
+		ImageSize:   upload.size,
+		ContentType: upload.contentType,
+		PHash:       phash,
+		Orientation: upload.meta.Orientation,
+	}, upload, written, true
+}
+
+// reprocess queues the processing of a receipt whose files changed, so that
+// the renditions of new files are generated, and the thumbnail and
+// suggestions follow a new primary attachment.
+func (h *Handler) reprocess(receiptID, userID int) {
+	if _, err := h.enqueueProcessing(&types.Receipt{ID: receiptID, UserID: userID}); err != nil {
+		log.Printf("failed to queue the processing of receipt %d: %v", receiptID, err)
+	}
+}
+
+// discardBlob deletes an image, and its renditions, no row refers to. The
+// store decides under the blob's lock, so an image that was referenced again
+// in the meantime is kept. Failures only waste space, so they are logged.
+func (h *Handler) discardBlob(discard bool, key string) {
+	if !discard {
+		return
+	}
+	err := h.store.DeleteBlob(key, func() error {
+		return deleteImage(context.Background(), h.blobs, key)
+	})
+	if err != nil {
+		log.Printf("failed to remove image %s: %v", key, err)
+	}
+}
+
+// keepBlob stores the upload again if its object was deleted after
+// storeBlob found it. Deletes are decided under the blob's row lock, so once
+// the row referring to the object is committed it stays. Failures are logged
+// as the receipt already exists.
+func (h *Handler) keepBlob(ctx context.Context, u *spooledUpload) {
+	if _, err := storeBlob(ctx, h.blobs, u); err != nil {
+		log.Printf("failed to store image %s: %v", u.key(), err)
+	}
+}
+
+func parseAttachmentPosition(r *http.Request) (int, error) {
+	position, err := strconv.Atoi(mux.Vars(r)["n"])
+	if err != nil || position < 0 {
+		return 0, fmt.Errorf("invalid attachment position")
+	}
+
+	return position, nil
+}
@@ -0,0 +1,424 @@
+package receipt
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+const (
+	// maxArchiveSize bounds the size of an uploaded archive
+	maxArchiveSize = 200 << 20
+	// maxArchiveEntries bounds the number of files in an archive
+	maxArchiveEntries = 500
+	// maxExtractedSize bounds the bytes extracted from one archive, however
+	// small it is compressed
+	maxExtractedSize = 500 << 20
+	// maxCompressionRatio is the highest ratio of a file's size to its
+	// compressed size accepted. Receipt images barely compress, so higher
+	// ratios point to a zip bomb.
+	maxCompressionRatio = 100
+
+	manifestName = "manifest.csv"
+)
+
+var (
+	errInvalidArchive   = errors.New("the uploaded file is not a valid zip archive")
+	errUnsafePath       = errors.New("unsafe file path")
+	errSuspiciousEntry  = errors.New("the file is compressed suspiciously well")
+	errArchiveExhausted = errors.New("the archive expands beyond the size limit")
+)
+
+// manifestColumns are the columns of manifest.csv. Only filename is
+// required.
+var manifestColumns = []string{"filename", "name", "amount", "currency", "date", "description", "categoryId", "tags"}
+
+// duplicateError marks a file that is not imported because its image has
+// been uploaded before, or appears earlier in the same archive.
+type duplicateError struct {
+	receiptID int
+	filename  string
+}
+
+func (e *duplicateError) Error() string {
+	if e.filename != "" {
+		return "same image as " + e.filename
+	}
+	return fmt.Sprintf("this image has already been uploaded as receipt %d, set force=true to add it again", e.receiptID)
+}
+
+// handleBulkUpload creates a receipt for every image of the uploaded
+// "archive", a ZIP file. An optional manifest.csv at its root gives each
+// file's name, amount, currency, date, description, categoryId and tags;
+// the amount and date of files it leaves out are read from the image.
+// Every file is checked like a single upload and reported on separately;
+// the receipts of the files that pass are created in a single transaction
+// and processed in the background.
+func (h *Handler) handleBulkUpload(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var archive *os.File
+	var size int64
+	defer func() {
+		if archive != nil {
+			archive.Close()
+			os.Remove(archive.Name())
+		}
+	}()
+
+	// The central directory is at the end of a zip, so the archive is
+	// spooled to disk before it is read
+	fields, status, err := readForm(w, r, maxArchiveSize, "archive", func(part io.Reader) (int, error) {
+		var err error
+		archive, err = os.CreateTemp("", "uploady-bulk-*")
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("%w: %v", errSavingFile, err)
+		}
+		size, err = io.Copy(archive, part)
+		if err != nil {
+			return uploadErrorStatus(err), fmt.Errorf("failed to read archive: %w", err)
+		}
+		return 0, nil
+	})
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, errInvalidArchive)
+		return
+	}
+	if len(zr.File) > maxArchiveEntries {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the archive holds more than %d files", maxArchiveEntries))
+		return
+	}
+
+	manifest, err := readManifest(zr)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	force, _ := strconv.ParseBool(fields.Get("force"))
+	imp := &bulkImport{
+		handler: h,
+		userID:  userID,
+		force:   force,
+		budget:  maxExtractedSize,
+		seen:    map[string]string{},
+	}
+	defer imp.close()
+
+	report := types.BulkUploadReport{Results: []types.BulkUploadResult{}}
+	var receipts []types.Receipt
+	var pending []int
+	var written []string
+	listed := map[string]bool{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || isArchiveClutter(f.Name) || f.Name == manifestName {
+			continue
+		}
+
+		fields, ok := manifest.lookup(f.Name)
+		if ok {
+			listed[manifest.key(f.Name)] = true
+		}
+
+		result := types.BulkUploadResult{Filename: f.Name}
+		receipt, wrote, err := imp.importFile(r.Context(), f, fields)
+		var dup *duplicateError
+		switch {
+		case errors.As(err, &dup):
+			result.Status = "skipped"
+			result.DuplicateOf = dup.receiptID
+			result.Error = err.Error()
+		case err != nil:
+			result.Status = "failed"
+			result.Error = err.Error()
+		default:
+			result.Status = "created"
+			receipts = append(receipts, *receipt)
+			pending = append(pending, len(report.Results))
+			if wrote {
+				written = append(written, receipt.ImageKey)
+			}
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	// Rows of the manifest without a file are most likely typos
+	for _, name := range manifest.order {
+		if !listed[name] {
+			report.Results = append(report.Results, types.BulkUploadResult{
+				Filename: name,
+				Status:   "failed",
+				Error:    "listed in the manifest but not found in the archive",
+			})
+		}
+	}
+
+	if len(receipts) > 0 {
+		ids, err := h.store.CreateReceipts(receipts)
+		if err != nil {
+			// Don't leave new images behind without receipts pointing at them
+			for _, key := range written {
+				h.discardBlob(true, key)
+			}
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, upload := range imp.stored {
+			h.keepBlob(r.Context(), upload)
+		}
+		for i, id := range ids {
+			receipts[i].ID = id
+			report.Results[pending[i]].ReceiptID = id
+			report.Results[pending[i]].JobID, err = h.enqueueProcessing(&receipts[i])
+			if err != nil {
+				log.Printf("failed to queue the processing of receipt %d: %v", id, err)
+			}
+		}
+	}
+
+	for _, result := range report.Results {
+		switch result.Status {
+		case "created":
+			report.Created++
+		case "skipped":
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, report)
+}
+
+// bulkImport is the state of importing the files of one archive.
+type bulkImport struct {
+	handler *Handler
+	userID  int
+	force   bool
+	// budget is what is left of maxExtractedSize
+	budget int64
+	// seen maps the digests imported so far to their file names
+	seen map[string]string
+	// stored are the spooled files of the imported receipts, kept until the
+	// receipts are committed
+	stored []*spooledUpload
+}
+
+func (b *bulkImport) close() {
+	for _, u := range b.stored {
+		u.Close()
+	}
+}
+
+// importFile checks one file of the archive and stores its image. It
+// returns the receipt to create and whether the image was written by this
+// import.
+func (b *bulkImport) importFile(ctx context.Context, f *zip.File, fields url.Values) (*types.Receipt, bool, error) {
+	// Nothing is extracted by name, but a path escaping the archive is a
+	// sign of a malicious one
+	if strings.Contains(f.Name, `\`) || !filepath.IsLocal(filepath.FromSlash(f.Name)) {
+		return nil, false, errUnsafePath
+	}
+	if f.UncompressedSize64 > utils.MaxFileSize {
+		return nil, false, utils.ErrFileTooLarge
+	}
+	if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > maxCompressionRatio {
+		return nil, false, errSuspiciousEntry
+	}
+
+	if fields.Get("name") == "" {
+		base := path.Base(f.Name)
+		fields.Set("name", strings.TrimSuffix(base, path.Ext(base)))
+	}
+	// zip readers refuse to read past the declared size, so it can be
+	// charged to the budget up front
+	if int64(f.UncompressedSize64) > b.budget {
+		return nil, false, errArchiveExhausted
+	}
+	b.budget -= int64(f.UncompressedSize64)
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, false, errInvalidArchive
+	}
+	defer rc.Close()
+
+	upload, _, err := openUpload(rc)
+	if err != nil {
+		return nil, false, err
+	}
+	stored := false
+	defer func() {
+		if !stored {
+			upload.Close()
+		}
+	}()
+
+	// Like single uploads, an amount or date left out is read from the image
+	fields, suggestions := b.handler.suggestFields(ctx, fields, upload)
+	if fields.Get("amount") == "" {
+		return nil, false, fmt.Errorf("no amount given or read from the image, add the file to %s", manifestName)
+	}
+
+	receipt, err := newReceipt(b.userID, fields, upload)
+	if err != nil {
+		return nil, false, err
+	}
+	receipt.Suggestions = suggestions
+	if err := b.handler.checkCategory(receipt.CategoryID, b.userID); err != nil {
+		return nil, false, err
+	}
+
+	// Refuse identical re-uploads unless the client insists
+	if !b.force {
+		if first, ok := b.seen[upload.digest]; ok {
+			return nil, false, &duplicateError{filename: first}
+		}
+		existing, err := b.handler.store.GetReceiptByDigest(upload.digest, b.userID)
+		if err == nil {
+			return nil, false, &duplicateError{receiptID: existing.ID}
+		}
+		if !errors.Is(err, ErrReceiptNotFound) {
+			return nil, false, err
+		}
+	}
+
+	written, err := storeBlob(ctx, b.handler.blobs, upload)
+	if err != nil {
+		return nil, false, errSavingFile
+	}
+	b.seen[upload.digest] = f.Name
+	b.stored = append(b.stored, upload)
+	stored = true
+
+	return receipt, written, nil
+}
+
+// bulkManifest holds the rows of manifest.csv by file name.
+type bulkManifest struct {
+	rows  map[string]url.Values
+	order []string
+}
+
+// readManifest parses the manifest.csv at the root of the archive, if any.
+// Its first row names the columns, in any order.
+func readManifest(zr *zip.Reader) (*bulkManifest, error) {
+	m := &bulkManifest{rows: map[string]url.Values{}}
+
+	var file *zip.File
+	for _, f := range zr.File {
+		if f.Name == manifestName {
+			file = f
+			break
+		}
+	}
+	if file == nil {
+		return m, nil
+	}
+	if file.UncompressedSize64 > maxFormFieldSize*16 {
+		return nil, fmt.Errorf("%s is too large", manifestName)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, errInvalidArchive
+	}
+	defer rc.Close()
+
+	cr := csv.NewReader(rc)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", manifestName, err)
+	}
+	if len(records) == 0 {
+		return m, nil
+	}
+
+	columns := map[string]int{}
+	for i, column := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	if _, ok := columns["filename"]; !ok {
+		return nil, fmt.Errorf("invalid %s: missing filename column", manifestName)
+	}
+
+	for _, record := range records[1:] {
+		fields := url.Values{}
+		for _, column := range manifestColumns {
+			if i, ok := columns[column]; ok && i < len(record) {
+				fields.Set(column, strings.TrimSpace(record[i]))
+			}
+		}
+
+		name := path.Clean(fields.Get("filename"))
+		if name == "." {
+			continue
+		}
+		if _, ok := m.rows[name]; ok {
+			return nil, fmt.Errorf("invalid %s: %s is listed twice", manifestName, name)
+		}
+		fields.Del("filename")
+		m.rows[name] = fields
+		m.order = append(m.order, name)
+	}
+
+	return m, nil
+}
+
+// lookup returns a copy of the fields given for a file, by its path in the
+// archive or else by its base name.
+func (m *bulkManifest) lookup(name string) (url.Values, bool) {
+	fields, ok := m.rows[m.key(name)]
+	if !ok {
+		return url.Values{}, false
+	}
+
+	copied := url.Values{}
+	for k, v := range fields {
+		copied[k] = append([]string(nil), v...)
+	}
+	return copied, true
+}
+
+// key is the name a file is listed under in the manifest.
+func (m *bulkManifest) key(name string) string {
+	name = path.Clean(name)
+	if _, ok := m.rows[name]; ok {
+		return name
+	}
+	if base := path.Base(name); base != name {
+		if _, ok := m.rows[base]; ok {
+			return base
+		}
+	}
+	return name
+}
+
+// isArchiveClutter reports whether the file was added by the archiver
+// rather than the user, like macOS resource forks.
+func isArchiveClutter(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".")
+}
@@ -0,0 +1,263 @@
+package receipt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+const (
+	// MaxTags bounds the tags of a receipt
+	MaxTags = 20
+	// maxTagLength is the length of the tags' name column
+	maxTagLength = 64
+)
+
+func (h *Handler) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	categories, err := h.store.ListCategories(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, categories)
+}
+
+func (h *Handler) handleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	category, ok := parseCategory(w, r)
+	if !ok {
+		return
+	}
+	category.UserID = userID
+
+	id, err := h.store.CreateCategory(*category)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	h.writeCategory(w, http.StatusCreated, id, userID)
+}
+
+// handleUpdateCategory replaces the fields of a category, moving it under
+// another parent or to the top level.
+func (h *Handler) handleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	categoryID, err := parseCategoryID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	category, ok := parseCategory(w, r)
+	if !ok {
+		return
+	}
+	category.ID = categoryID
+	category.UserID = userID
+
+	if err := h.store.UpdateCategory(*category); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	h.writeCategory(w, http.StatusOK, categoryID, userID)
+}
+
+func (h *Handler) handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	categoryID, err := parseCategoryID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteCategory(categoryID, userID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleGetTags(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	tags, err := h.store.ListTags(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, tags)
+}
+
+// handleRenameTag renames a tag on all of the receipts carrying it.
+func (h *Handler) handleRenameTag(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	tagID, err := parseTagID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.RenameTagPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	tags, err := parseTags([]string{payload.Name})
+	if err != nil || len(tags) != 1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid tag name, tags are up to %d characters without commas", maxTagLength))
+		return
+	}
+
+	if err := h.store.RenameTag(tagID, userID, tags[0]); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.Tag{ID: tagID, Name: tags[0]})
+}
+
+// handleDeleteTag removes a tag from all of the receipts carrying it.
+func (h *Handler) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	tagID, err := parseTagID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteTag(tagID, userID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeCategory responds with a created or updated category as stored.
+func (h *Handler) writeCategory(w http.ResponseWriter, status int, categoryID, userID int) {
+	category, err := h.store.GetCategory(categoryID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, category)
+}
+
+// parseCategory reads and validates a category payload, writing the error
+// response when it is invalid.
+func parseCategory(w http.ResponseWriter, r *http.Request) (*types.Category, bool) {
+	var payload types.CategoryPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return nil, false
+	}
+
+	return &types.Category{
+		ParentID: payload.ParentID,
+		Name:     strings.TrimSpace(payload.Name),
+		Colour:   strings.ToLower(payload.Colour),
+		Icon:     payload.Icon,
+	}, true
+}
+
+// checkCategory returns errInvalidCategory unless the category, if any, is
+// one of the user's.
+func (h *Handler) checkCategory(categoryID *int, userID int) error {
+	if categoryID == nil {
+		return nil
+	}
+
+	_, err := h.store.GetCategory(*categoryID, userID)
+	if errors.Is(err, ErrCategoryNotFound) {
+		return errInvalidCategory
+	}
+	return err
+}
+
+// writeCategoryError writes the response for an error of checkCategory.
+func writeCategoryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidCategory) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
+
+// parseTags reads tags given as separate values or separated by commas.
+// Tags are trimmed and lower-cased, so that "Travel " and "travel" are the
+// same tag, and duplicates are dropped.
+func parseTags(values []string) ([]string, error) {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if len([]rune(tag)) > maxTagLength {
+				return nil, fmt.Errorf("tags are at most %d characters long", maxTagLength)
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	if len(tags) > MaxTags {
+		return nil, fmt.Errorf("a receipt has at most %d tags", MaxTags)
+	}
+
+	return tags, nil
+}
+
+func parseCategoryID(r *http.Request) (int, error) {
+	categoryID, err := strconv.Atoi(mux.Vars(r)["categoryId"])
+	if err != nil {
+		return 0, fmt.Errorf("invalid category ID")
+	}
+
+	return categoryID, nil
+}
+
+func parseTagID(r *http.Request) (int, error) {
+	tagID, err := strconv.Atoi(mux.Vars(r)["tagId"])
+	if err != nil {
+		return 0, fmt.Errorf("invalid tag ID")
+	}
+
+	return tagID, nil
+}
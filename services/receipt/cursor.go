@@ -0,0 +1,116 @@
+package receipt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/groshiniprasad/uploady/types"
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+	DefaultListSort  = "-createdAt"
+)
+
+// sortColumns maps the public sort keys to their receipts table columns.
+var sortColumns = map[string]string{
+	"date":      "date",
+	"amount":    "amount",
+	"name":      "name",
+	"createdAt": "createdAt",
+}
+
+// listOrder is a parsed sort parameter such as "-date".
+type listOrder struct {
+	key    string
+	column string
+	desc   bool
+}
+
+func parseListOrder(sort string) (listOrder, error) {
+	if sort == "" {
+		sort = DefaultListSort
+	}
+
+	desc := strings.HasPrefix(sort, "-")
+	key := strings.TrimPrefix(sort, "-")
+
+	column, ok := sortColumns[key]
+	if !ok {
+		return listOrder{}, fmt.Errorf("invalid sort %q", sort)
+	}
+
+	return listOrder{key: key, column: column, desc: desc}, nil
+}
+
+func (o listOrder) String() string {
+	if o.desc {
+		return "-" + o.key
+	}
+	return o.key
+}
+
+// listCursor points just past the last receipt of a page. It records the sort
+// it was issued for so it cannot be replayed against a different ordering.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(order listOrder, last types.Receipt) string {
+	var value string
+	switch order.key {
+	case "date":
+		value = last.Date.UTC().Format(time.RFC3339Nano)
+	case "createdAt":
+		value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "amount":
+		value = last.Amount.String()
+	case "name":
+		value = last.Name
+	}
+
+	b, _ := json.Marshal(listCursor{Sort: order.String(), Value: value, ID: last.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the sort value and receipt ID encoded in the cursor,
+// with the value converted to the type of its column.
+func decodeCursor(order listOrder, cursor string) (any, int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid cursor")
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, 0, fmt.Errorf("invalid cursor")
+	}
+
+	if c.Sort != order.String() {
+		return nil, 0, fmt.Errorf("cursor does not match sort %q", order.String())
+	}
+
+	switch order.key {
+	case "date", "createdAt":
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid cursor")
+		}
+		return t, c.ID, nil
+	case "amount":
+		// Kept as the exact decimal the receipt's amount was written as
+		if _, err := strconv.ParseFloat(c.Value, 64); err != nil {
+			return nil, 0, fmt.Errorf("invalid cursor")
+		}
+		return c.Value, c.ID, nil
+	default:
+		return c.Value, c.ID, nil
+	}
+}
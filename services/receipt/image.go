@@ -0,0 +1,430 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/groshiniprasad/uploady/cache"
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/imaging"
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/storage"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+	"golang.org/x/image/draw"
+)
+
+const (
+	defaultImageSize = 100
+	defaultMode      = imaging.ModeScale
+)
+
+var (
+	errNotAcceptable = errors.New("none of the accepted image types can be produced, use jpeg, png, gif or webp")
+	errUndecodable   = errors.New("error decoding image")
+)
+
+// handleGetResizedReceiptsV2 serves the receipt's primary image, either
+// untouched with raw=true, as one of the stored renditions with rendition, or
+// resized and re-encoded in the negotiated format.
+func (h *Handler) handleGetResizedReceiptsV2(w http.ResponseWriter, r *http.Request) {
+	h.serveAttachment(w, r, 0)
+}
+
+// handleGetAttachmentImage serves the receipt's attachment at position n,
+// taking the same parameters as handleGetResizedReceiptsV2.
+func (h *Handler) handleGetAttachmentImage(w http.ResponseWriter, r *http.Request) {
+	position, err := parseAttachmentPosition(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	h.serveAttachment(w, r, position)
+}
+
+func (h *Handler) serveAttachment(w http.ResponseWriter, r *http.Request, position int) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	raw := false
+	if str := r.URL.Query().Get("raw"); str != "" {
+		if raw, err = strconv.ParseBool(str); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("raw must be true or false"))
+			return
+		}
+	}
+
+	opts, err := parseResizeOptions(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rendition, err := parseRendition(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	attachment, err := h.store.GetAttachment(receiptID, userID, position)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if rendition != nil {
+		h.serveRendition(w, r, attachment, *rendition)
+		return
+	}
+
+	if raw {
+		h.serveOriginal(w, r, attachment)
+		return
+	}
+
+	// Derived images depend on the Accept header
+	w.Header().Set("Vary", "Accept")
+
+	original, _ := imaging.FormatForContentType(attachment.ContentType)
+	out, err := parseOutputOptions(r, original)
+	if errors.Is(err, errNotAcceptable) {
+		utils.WriteError(w, http.StatusNotAcceptable, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	key := cache.Key{ReceiptID: attachment.ReceiptID, Variant: variant(attachment, opts, out)}
+	if writeCacheHeaders(w, r, etag(key.Variant), attachment.UploadedAt) {
+		return
+	}
+
+	if entry, ok := h.cache.Get(key); ok {
+		w.Header().Set("X-Cache", "HIT")
+		writeImage(w, entry)
+		return
+	}
+
+	// Decode the image, or the preview of a PDF, turned upright
+	img, err := decodeAttachment(r.Context(), h.blobs, attachment)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	// Encode the resized image before writing so that failures can still be
+	// reported as errors
+	entry, err := renderVariant(img, opts, out)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	h.cache.Set(key, entry)
+
+	w.Header().Set("X-Cache", "MISS")
+	writeImage(w, entry)
+}
+
+// decodeSlots bounds the images decoded at once, since each may take up to
+// four bytes for every one of MaxImagePixels.
+var decodeSlots = make(chan struct{}, max(1, configs.Envs.MaxConcurrentDecodes))
+
+// imageLimits are the largest images that are decoded.
+func imageLimits() imaging.Limits {
+	return imaging.Limits{
+		MaxPixels:    configs.Envs.MaxImagePixels,
+		MaxDimension: int(configs.Envs.MaxImageDimension),
+	}
+}
+
+// decodeImage decodes an image within the limits once a decode slot is
+// free. Images exceeding the limits yield an error wrapping
+// imaging.ErrTooLarge.
+func decodeImage(ctx context.Context, r io.Reader, contentType string) (image.Image, error) {
+	select {
+	case decodeSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-decodeSlots }()
+
+	return imaging.Decode(r, contentType, imageLimits())
+}
+
+// decodeAttachment decodes the attachment's image, or the preview of a PDF,
+// and turns it upright. Images that cannot be decoded yield an error wrapping
+// errUndecodable, imaging.ErrNoPreview for formats without a preview or
+// imaging.ErrTooLarge for images exceeding the limits.
+func decodeAttachment(ctx context.Context, blobs storage.BlobStore, attachment *types.Attachment) (image.Image, error) {
+	file, _, err := blobs.Get(ctx, attachment.ImageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := decodeImage(ctx, file, attachment.ContentType)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, imaging.ErrNoPreview) || errors.Is(err, imaging.ErrTooLarge) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUndecodable, err)
+	}
+
+	return imaging.Orient(img, attachment.Orientation), nil
+}
+
+// writeDecodeError reports why an attachment could not be decoded.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, imaging.ErrNoPreview):
+		utils.WriteError(w, http.StatusNotAcceptable, fmt.Errorf("%w, request the original with raw=true", err))
+	case errors.Is(err, imaging.ErrTooLarge):
+		utils.WriteError(w, http.StatusUnprocessableEntity, err)
+	default:
+		writeStoreError(w, err)
+	}
+}
+
+// renderVariant resizes and encodes an image.
+func renderVariant(img image.Image, opts imaging.Options, out outputOptions) (cache.Entry, error) {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, imaging.Resize(img, opts), out.format, out.quality); err != nil {
+		return cache.Entry{}, fmt.Errorf("error encoding image: %v", err)
+	}
+
+	return cache.Entry{ContentType: out.format.ContentType(), Data: buf.Bytes()}, nil
+}
+
+func writeImage(w http.ResponseWriter, entry cache.Entry) {
+	w.Header().Set("Content-Type", entry.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.Data)))
+	w.Write(entry.Data)
+}
+
+// etag is a strong validator for an image. Stored images never change, so
+// the source blob and the transformation fully determine the bytes.
+func etag(variant string) string {
+	sum := sha256.Sum256([]byte(variant))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeCacheHeaders sets the validators and caching policy of an image and
+// reports whether the request was answered with 304 Not Modified.
+func writeCacheHeaders(w http.ResponseWriter, r *http.Request, tag string, modified time.Time) bool {
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", configs.Envs.ImageMaxAgeInSeconds))
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, tag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since only
+// when the client sent no entity tags, as RFC 9110 requires.
+func notModified(r *http.Request, tag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == "*" || t == tag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !modified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// variant describes everything that affects a derived image, for use as its
+// cache key. The image key ties it to the stored original.
+func variant(attachment *types.Attachment, opts imaging.Options, out outputOptions) string {
+	// The background only shows in pad mode
+	var bg string
+	if opts.Background != nil && opts.Mode == imaging.ModePad {
+		c := color.NRGBAModel.Convert(opts.Background).(color.NRGBA)
+		bg = fmt.Sprintf("%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+	}
+
+	return fmt.Sprintf("%s:%dx%d:%s:%s:%s:%s:%d", attachment.ImageKey, opts.Width, opts.Height,
+		opts.Mode, imaging.KernelName(opts.Kernel), bg, out.format, out.quality)
+}
+
+func (h *Handler) handleGetCacheStats(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, h.cache.Stats())
+}
+
+// serveOriginal streams the stored bytes untouched. Range requests are
+// answered with 206 when the blob store hands back a seekable object.
+func (h *Handler) serveOriginal(w http.ResponseWriter, r *http.Request, attachment *types.Attachment) {
+	source := attachment.ImageDigest
+	if source == "" {
+		source = attachment.ImageKey
+	}
+	if writeCacheHeaders(w, r, etag(source+":raw"), attachment.UploadedAt) {
+		return
+	}
+
+	file, info, err := h.blobs.Get(r.Context(), attachment.ImageKey)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer file.Close()
+
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	if rs, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", attachment.UploadedAt, rs)
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("failed to send attachment %d of receipt %d: %v", attachment.Position, attachment.ReceiptID, err)
+	}
+}
+
+// outputOptions are the encoding settings of a served image.
+type outputOptions struct {
+	format  imaging.Format
+	quality int
+}
+
+// parseOutputOptions picks the output format from the format parameter or,
+// without one, from the Accept header, defaulting to the original's format.
+func parseOutputOptions(r *http.Request, original imaging.Format) (outputOptions, error) {
+	q := r.URL.Query()
+	var out outputOptions
+
+	if str := q.Get("format"); str != "" {
+		f, err := imaging.ParseFormat(str)
+		if err != nil {
+			return out, err
+		}
+		out.format = f
+	} else {
+		f, ok := imaging.Negotiate(r.Header.Get("Accept"), original)
+		if !ok {
+			return out, errNotAcceptable
+		}
+		out.format = f
+	}
+
+	if str := q.Get("quality"); str != "" {
+		quality, err := strconv.Atoi(str)
+		if err != nil || quality < 1 || quality > 100 {
+			return out, fmt.Errorf("quality must be between 1 and 100")
+		}
+		// Lossless formats have no use for it
+		if out.format.Lossy() {
+			out.quality = quality
+		}
+	}
+
+	return out, nil
+}
+
+// parseResizeOptions reads width, height, mode, kernel and bg from the query.
+// Without width and height the image is scaled to the 100x100 default; with
+// only one of them the other follows the image's aspect ratio, capped at
+// MaxResizeDimension.
+func parseResizeOptions(q url.Values) (imaging.Options, error) {
+	opts := imaging.Options{
+		Mode:    defaultMode,
+		Kernel:  draw.BiLinear,
+		MaxSize: int(configs.Envs.MaxResizeDimension),
+	}
+
+	var err error
+	if opts.Width, err = parseDimension(q.Get("width"), "width"); err != nil {
+		return opts, err
+	}
+	if opts.Height, err = parseDimension(q.Get("height"), "height"); err != nil {
+		return opts, err
+	}
+	if opts.Width == 0 && opts.Height == 0 {
+		opts.Width, opts.Height = defaultImageSize, defaultImageSize
+	}
+
+	if str := q.Get("mode"); str != "" {
+		if opts.Mode, err = imaging.ParseMode(str); err != nil {
+			return opts, err
+		}
+	}
+
+	if str := q.Get("kernel"); str != "" {
+		if opts.Kernel, err = imaging.ParseKernel(str); err != nil {
+			return opts, err
+		}
+	}
+
+	if str := q.Get("bg"); str != "" {
+		if opts.Background, err = imaging.ParseColor(str); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+func parseDimension(str, name string) (int, error) {
+	if str == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(str)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+	if limit := configs.Envs.MaxResizeDimension; int64(n) > limit {
+		return 0, fmt.Errorf("%s must not exceed %d", name, limit)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,243 @@
+package receipt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+// errLineItemCurrency refuses a currency the line items' prices cannot be
+// written in.
+var errLineItemCurrency = errors.New("the line items' prices do not fit the currency")
+
+func (h *Handler) handleGetLineItems(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	receipt, err := h.store.GetReceiptByID(receiptID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	items, err := h.store.ListLineItems(receiptID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	total, warning := checkLineItems(receipt, items)
+	utils.WriteJSON(w, http.StatusOK, types.LineItemList{Items: items, Total: total, Warning: warning})
+}
+
+func (h *Handler) handleCreateLineItem(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Prices are in the receipt's currency
+	receipt, err := h.store.GetReceiptByID(receiptID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	item, ok := parseLineItem(w, r, receipt.Currency)
+	if !ok {
+		return
+	}
+	item.ReceiptID = receiptID
+
+	itemID, err := h.store.CreateLineItem(*item, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	h.writeLineItem(w, http.StatusCreated, receipt, itemID, userID)
+}
+
+// handleUpdateLineItem replaces the fields of a line item.
+func (h *Handler) handleUpdateLineItem(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	itemID, err := parseLineItemID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	receipt, err := h.store.GetReceiptByID(receiptID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	item, ok := parseLineItem(w, r, receipt.Currency)
+	if !ok {
+		return
+	}
+	item.ID = itemID
+	item.ReceiptID = receiptID
+
+	if err := h.store.UpdateLineItem(*item, userID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	h.writeLineItem(w, http.StatusOK, receipt, itemID, userID)
+}
+
+func (h *Handler) handleDeleteLineItem(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	itemID, err := parseLineItemID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteLineItem(receiptID, userID, itemID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeLineItem responds with a created or updated line item as stored,
+// with a warning when the receipt's line items no longer add up to its
+// amount.
+func (h *Handler) writeLineItem(w http.ResponseWriter, status int, receipt *types.Receipt, itemID, userID int) {
+	items, err := h.store.ListLineItems(receipt.ID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	i := slices.IndexFunc(items, func(item types.LineItem) bool { return item.ID == itemID })
+	if i < 0 {
+		writeStoreError(w, ErrLineItemNotFound)
+		return
+	}
+
+	res := types.LineItemResponse{LineItem: items[i]}
+	_, res.Warning = checkLineItems(receipt, items)
+	utils.WriteJSON(w, status, res)
+}
+
+// parseLineItem reads and validates a line item payload with its price in
+// the currency, writing the error response when it is invalid.
+func parseLineItem(w http.ResponseWriter, r *http.Request, currency money.Currency) (*types.LineItem, bool) {
+	var payload types.LineItemPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return nil, false
+	}
+
+	unitPrice, err := money.Parse(payload.UnitPrice.String(), currency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid unitPrice: %w", err))
+		return nil, false
+	}
+
+	if payload.Quantity == 0 {
+		payload.Quantity = 1
+	}
+
+	return &types.LineItem{
+		Description: payload.Description,
+		Quantity:    payload.Quantity,
+		UnitPrice:   unitPrice,
+		TaxRate:     payload.TaxRate,
+		Category:    payload.Category,
+	}, true
+}
+
+func parseLineItemID(r *http.Request) (int, error) {
+	itemID, err := strconv.Atoi(mux.Vars(r)["itemId"])
+	if err != nil {
+		return 0, fmt.Errorf("invalid line item ID")
+	}
+
+	return itemID, nil
+}
+
+// priceLineItem works out the tax and the total of a line, rounded to the
+// minor unit as printed on receipts.
+func priceLineItem(item *types.LineItem) {
+	net := item.UnitPrice.Mul(item.Quantity)
+	item.Tax = net.Mul(item.TaxRate / 100)
+	item.Amount = net.Add(item.Tax)
+}
+
+// checkLineItems adds up the line items and returns a warning when the sum
+// is off the receipt's amount by more than rounding each line to the minor
+// unit explains, half a unit per line. Receipts without line items are not
+// checked.
+func checkLineItems(receipt *types.Receipt, items []types.LineItem) (money.Amount, string) {
+	total := money.New(0, receipt.Currency)
+	for _, item := range items {
+		total = total.Add(item.Amount)
+	}
+
+	off := total.Sub(receipt.Amount).Minor()
+	tolerance := max(1, int64(len(items)+1)/2)
+	if len(items) == 0 || (off >= -tolerance && off <= tolerance) {
+		return total, ""
+	}
+
+	return total, fmt.Sprintf("the line items add up to %s %s but the receipt's amount is %s %s", total, receipt.Currency, receipt.Amount, receipt.Currency)
+}
+
+// checkLineItemPrices returns an error when the unit price of one of the
+// receipt's line items cannot be written in its currency, such as a price
+// with cents on a receipt changed to yen.
+func (h *Handler) checkLineItemPrices(receipt *types.Receipt, userID int) error {
+	items, err := h.store.ListLineItems(receipt.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if _, err := money.Parse(item.UnitPrice.String(), receipt.Currency); err != nil {
+			return fmt.Errorf("%w: line item %d: %v", errLineItemCurrency, item.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package receipt
+
+import (
+	"context"
+	"image"
+	"log"
+	"maps"
+	"net/url"
+	"time"
+
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/imaging"
+	"github.com/groshiniprasad/uploady/ocr"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+// recognize reads the fields of a receipt from its image.
+func recognize(ctx context.Context, extractor ocr.Extractor, img image.Image) (*types.ReceiptSuggestions, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(configs.Envs.OCRTimeoutInSeconds)*time.Second)
+	defer cancel()
+
+	text, err := extractor.Extract(ctx, imaging.Enhance(img))
+	if err != nil {
+		return nil, err
+	}
+
+	return ocr.Parse(text), nil
+}
+
+// suggestFields reads the upload's image when the form leaves out the name,
+// the amount or a date the photo's capture date cannot stand in for, and
+// fills them in from what was found. It returns the completed fields along
+// with everything found. Images that cannot be read leave the fields as they
+// are, for newReceipt to report what is missing.
+func (h *Handler) suggestFields(ctx context.Context, fields url.Values, upload *spooledUpload) (url.Values, *types.ReceiptSuggestions) {
+	needsDate := fields.Get("date") == "" && upload.meta.CapturedAt.IsZero()
+	if h.extractor == nil || (fields.Get("name") != "" && fields.Get("amount") != "" && !needsDate) {
+		return fields, nil
+	}
+
+	img, err := upload.decode(ctx)
+	if err != nil {
+		// Files without a preview have nothing to read
+		return fields, nil
+	}
+
+	suggestions, err := recognize(ctx, h.extractor, img)
+	if err != nil {
+		log.Printf("failed to read the receipt image: %v", err)
+		return fields, nil
+	}
+
+	fields = maps.Clone(fields)
+	if fields.Get("name") == "" && suggestions.Name != nil {
+		fields.Set("name", suggestions.Name.Value)
+	}
+	if fields.Get("amount") == "" && suggestions.Amount != nil {
+		fields.Set("amount", suggestions.Amount.Value)
+	}
+	if needsDate && suggestions.Date != nil {
+		fields.Set("date", suggestions.Date.Value.Format("2006-01-02"))
+	}
+
+	return fields, suggestions
+}
+
+// suggestFromImage stores the fields read from a receipt's primary image
+// for receipts uploaded with all of their fields, so they can be checked
+// against what is printed. Failures are only logged, as the suggestions are
+// a convenience.
+func (h *Handler) suggestFromImage(ctx context.Context, receipt *types.Receipt, img image.Image) {
+	suggestions, err := recognize(ctx, h.extractor, img)
+	if err != nil {
+		log.Printf("failed to read the image of receipt %d: %v", receipt.ID, err)
+		return
+	}
+
+	if err := h.store.SetReceiptSuggestions(receipt.ID, suggestions); err != nil {
+		log.Printf("failed to store the suggestions for receipt %d: %v", receipt.ID, err)
+		return
+	}
+	receipt.Suggestions = suggestions
+}
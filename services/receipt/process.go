@@ -0,0 +1,173 @@
+package receipt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net/url"
+
+	"github.com/groshiniprasad/uploady/cache"
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/imaging"
+	"github.com/groshiniprasad/uploady/queue"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+// JobProcessReceipt is the kind of the jobs processing uploaded receipts.
+const JobProcessReceipt = "receipt.process"
+
+type processReceiptPayload struct {
+	ReceiptID int `json:"receiptId"`
+}
+
+// enqueueProcessing queues the processing of a new receipt and returns the
+// job's ID.
+func (h *Handler) enqueueProcessing(receipt *types.Receipt) (int, error) {
+	payload, err := json.Marshal(processReceiptPayload{ReceiptID: receipt.ID})
+	if err != nil {
+		return 0, err
+	}
+
+	return h.jobs.EnqueueJob(types.Job{
+		UserID:      receipt.UserID,
+		Kind:        JobProcessReceipt,
+		Payload:     payload,
+		MaxAttempts: int(configs.Envs.JobMaxAttempts),
+	})
+}
+
+// ProcessReceiptJob runs a JobProcessReceipt job: it decodes the receipt's
+// primary image, stores its perceptual hash, looks for earlier photos of the
+// same receipt and renders the default thumbnail into the image cache. With
+// OCR enabled, the fields read from the image are stored unless the upload
+// already read them. The renditions of every attachment are stored next to
+// their originals. The receipt's processing status follows the job's
+// progress.
+func (h *Handler) ProcessReceiptJob(ctx context.Context, job *types.Job) (any, error) {
+	var payload processReceiptPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, queue.Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+
+	result, err := h.processReceipt(ctx, payload.ReceiptID, job.UserID)
+	if err != nil {
+		if queue.IsFinalAttempt(job, err) {
+			if err := h.store.SetProcessingStatus(payload.ReceiptID, types.ProcessingFailed); err != nil {
+				log.Printf("failed to update the processing status of receipt %d: %v", payload.ReceiptID, err)
+			}
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (h *Handler) processReceipt(ctx context.Context, receiptID, userID int) (*types.ProcessReceiptResult, error) {
+	result := &types.ProcessReceiptResult{}
+
+	receipt, err := h.store.GetReceiptByID(receiptID, userID)
+	if errors.Is(err, ErrReceiptNotFound) {
+		// Trashed in the meantime, there is nothing left to do
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.store.SetProcessingStatus(receiptID, types.ProcessingRunning); err != nil {
+		return nil, err
+	}
+
+	attachments, err := h.store.ListAttachments(receiptID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range attachments {
+		attachment := &attachments[i]
+
+		img, err := decodeAttachment(ctx, h.blobs, attachment)
+		switch {
+		case errors.Is(err, imaging.ErrNoPreview):
+			// Files without a preview, such as HEIC photos, are kept as they are
+			continue
+		case errors.Is(err, errUndecodable), errors.Is(err, imaging.ErrTooLarge):
+			return nil, queue.Permanent(err)
+		case err != nil:
+			return nil, err
+		}
+
+		if attachment.Position == 0 {
+			// Uploads are fingerprinted and checked for duplicates as they
+			// come in, only receipts stored before that are left to do
+			if receipt.PHash == nil {
+				phash := imaging.DHash(img)
+				if err := h.store.SetReceiptPHash(receiptID, phash); err != nil {
+					return nil, err
+				}
+				receipt.PHash = &phash
+
+				h.warnAboutDuplicate(receipt, result)
+			}
+			h.renderThumbnail(attachment, img)
+
+			if h.extractor != nil && receipt.Suggestions == nil {
+				h.suggestFromImage(ctx, receipt, img)
+			}
+		}
+
+		if _, err := storeRenditions(ctx, h.blobs, attachment, img, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := h.store.SetProcessingStatus(receiptID, types.ProcessingDone); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// warnAboutDuplicate flags a likely duplicate of an earlier photo of the
+// same receipt in the result.
+func (h *Handler) warnAboutDuplicate(receipt *types.Receipt, result *types.ProcessReceiptResult) {
+	similar, err := h.store.FindSimilarReceipts(types.SimilarReceiptQuery{
+		UserID:      receipt.UserID,
+		PHash:       *receipt.PHash,
+		MaxDistance: int(configs.Envs.PHashThreshold),
+		ExcludeID:   receipt.ID,
+		Amount:      &receipt.Amount,
+		Currency:    receipt.Currency,
+		Date:        &receipt.Date,
+		Limit:       1,
+	})
+	if err != nil {
+		log.Printf("failed to look for duplicates of receipt %d: %v", receipt.ID, err)
+		return
+	}
+
+	if len(similar) > 0 {
+		result.DuplicateOf = &similar[0].ID
+		result.Warning = fmt.Sprintf("this looks like a duplicate of receipt %d", similar[0].ID)
+	}
+}
+
+// renderThumbnail caches the image served by GET /receipts/{id} without
+// parameters, so that the first listing showing the receipt is a cache hit.
+func (h *Handler) renderThumbnail(attachment *types.Attachment, img image.Image) {
+	opts, _ := parseResizeOptions(url.Values{})
+	original, _ := imaging.FormatForContentType(attachment.ContentType)
+	format, _ := imaging.Negotiate("", original)
+	out := outputOptions{format: format}
+
+	entry, err := renderVariant(img, opts, out)
+	if err != nil {
+		log.Printf("failed to render the thumbnail of receipt %d: %v", attachment.ReceiptID, err)
+		return
+	}
+
+	h.cache.Set(cache.Key{ReceiptID: attachment.ReceiptID, Variant: variant(attachment, opts, out)}, entry)
+}
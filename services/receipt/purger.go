@@ -0,0 +1,87 @@
+package receipt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/groshiniprasad/uploady/cache"
+	"github.com/groshiniprasad/uploady/storage"
+	"github.com/groshiniprasad/uploady/types"
+)
+
+// purgeBatchSize caps how many receipts a single purge pass removes.
+const purgeBatchSize = 100
+
+// Purger permanently removes receipts that have been in the trash for longer
+// than the retention period, together with their attachments.
+type Purger struct {
+	store     types.ReceiptStore
+	blobs     storage.BlobStore
+	cache     *cache.Cache
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewPurger(store types.ReceiptStore, blobs storage.BlobStore, cache *cache.Cache, retention, interval time.Duration) *Purger {
+	return &Purger{store: store, blobs: blobs, cache: cache, retention: retention, interval: interval}
+}
+
+// Run purges once immediately and then on every interval until ctx is done.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.PurgeOnce(ctx, time.Now())
+		if err != nil {
+			log.Printf("failed to purge trashed receipts: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d trashed receipts", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce removes the receipts that were trashed before now minus the
+// retention period and returns how many were purged.
+func (p *Purger) PurgeOnce(ctx context.Context, now time.Time) (int, error) {
+	purged := 0
+	for {
+		receipts, err := p.store.ListPurgeableReceipts(now.Add(-p.retention), purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, receipt := range receipts {
+			// The row goes first: an image left behind by a failed delete is
+			// merely wasted space, while a row whose image is gone would be a
+			// broken receipt.
+			orphaned, err := p.store.PurgeReceipt(receipt.ID)
+			if err != nil {
+				return purged, fmt.Errorf("receipt %d: %w", receipt.ID, err)
+			}
+			purged++
+			p.cache.InvalidateReceipt(receipt.ID)
+
+			for _, key := range orphaned {
+				err := p.store.DeleteBlob(key, func() error {
+					return deleteImage(ctx, p.blobs, key)
+				})
+				if err != nil {
+					log.Printf("failed to remove image %s: %v", key, err)
+				}
+			}
+		}
+
+		if len(receipts) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/groshiniprasad/uploady/cache"
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/imaging"
+	"github.com/groshiniprasad/uploady/storage"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+	"golang.org/x/image/draw"
+)
+
+// renditionPrefix keeps renditions apart from the content addressed
+// originals in the blob store.
+const renditionPrefix = "renditions/"
+
+// backfillBatchSize caps how many attachments a backfill reads at once.
+const backfillBatchSize = 100
+
+// renditionParams are the query parameters a rendition cannot be combined
+// with, since its size and format are fixed.
+var renditionParams = []string{"raw", "width", "height", "mode", "kernel", "bg", "format", "quality"}
+
+// parseRendition returns the rendition named by the rendition parameter, or
+// nil when the query asks for none.
+func parseRendition(q url.Values) (*configs.Rendition, error) {
+	name := q.Get("rendition")
+	if name == "" {
+		return nil, nil
+	}
+
+	for _, param := range renditionParams {
+		if q.Has(param) {
+			return nil, fmt.Errorf("rendition cannot be combined with %s", param)
+		}
+	}
+
+	names := make([]string, len(configs.Envs.Renditions))
+	for i, r := range configs.Envs.Renditions {
+		if r.Name == name {
+			return &r, nil
+		}
+		names[i] = r.Name
+	}
+
+	return nil, fmt.Errorf("unknown rendition %q, use %s", name, strings.Join(names, ", "))
+}
+
+// renditionKey is where a rendition of the image stored under imageKey is
+// kept, and the format it is encoded in: the original's when it can be
+// written, JPEG otherwise. Both follow from the key alone, so renditions can
+// be found and removed without the attachment's row.
+func renditionKey(imageKey, name string) (string, imaging.Format) {
+	ext := path.Ext(imageKey)
+	original, _ := imaging.FormatForContentType(mime.TypeByExtension(ext))
+	format, _ := imaging.Negotiate("", original)
+	format = format.Encoded()
+
+	return renditionPrefix + name + "/" + strings.TrimSuffix(imageKey, ext) + "." + string(format), format
+}
+
+// renderRendition scales an image to fit the rendition's size, enhancing it
+// first for enhanced renditions. Images that are already smaller are only
+// re-encoded.
+func renderRendition(img image.Image, rendition configs.Rendition, format imaging.Format) (cache.Entry, error) {
+	if rendition.Enhance {
+		img = imaging.Enhance(img)
+	}
+
+	opts := imaging.Options{Mode: imaging.ModeFit, Kernel: draw.CatmullRom}
+
+	b := img.Bounds()
+	if size := int(rendition.Size); size > 0 && max(b.Dx(), b.Dy()) > size {
+		opts.Width, opts.Height = size, size
+	}
+
+	return renderVariant(img, opts, outputOptions{format: format})
+}
+
+// storeRenditions renders the configured renditions of an attachment's image
+// and stores them next to the original. Renditions that already exist are
+// kept unless force is set. It returns how many renditions it stored.
+func storeRenditions(ctx context.Context, blobs storage.BlobStore, attachment *types.Attachment, img image.Image, force bool) (int, error) {
+	stored := 0
+	for _, rendition := range configs.Envs.Renditions {
+		key, format := renditionKey(attachment.ImageKey, rendition.Name)
+		if !force {
+			_, err := blobs.Stat(ctx, key)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, storage.ErrNotFound) {
+				return stored, err
+			}
+		}
+
+		entry, err := renderRendition(img, rendition, format)
+		if err != nil {
+			return stored, err
+		}
+		if err := blobs.Put(ctx, key, bytes.NewReader(entry.Data), int64(len(entry.Data)), entry.ContentType); err != nil {
+			return stored, fmt.Errorf("failed to store rendition %s of %s: %w", rendition.Name, attachment.ImageKey, err)
+		}
+		stored++
+	}
+
+	return stored, nil
+}
+
+// deleteImage removes an original image and its renditions, including those
+// of renditions that are no longer configured.
+func deleteImage(ctx context.Context, blobs storage.BlobStore, key string) error {
+	names, err := blobs.List(ctx, renditionPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		name, ok := strings.CutSuffix(name, "/")
+		if !ok {
+			continue
+		}
+		renditionKey, _ := renditionKey(key, name)
+		if err := blobs.Delete(ctx, renditionKey); err != nil {
+			return err
+		}
+	}
+
+	return blobs.Delete(ctx, key)
+}
+
+// serveRendition serves a stored rendition of the attachment. Renditions that
+// have not been generated yet, because the upload is still being processed
+// or predates them, are rendered and stored on the spot.
+func (h *Handler) serveRendition(w http.ResponseWriter, r *http.Request, attachment *types.Attachment, rendition configs.Rendition) {
+	key, format := renditionKey(attachment.ImageKey, rendition.Name)
+	if writeCacheHeaders(w, r, etag(fmt.Sprintf("%s:%d", key, rendition.Size)), attachment.UploadedAt) {
+		return
+	}
+
+	file, info, err := h.blobs.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.serveMissingRendition(w, r, attachment, rendition, key, format)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("failed to send rendition %s of receipt %d: %v", rendition.Name, attachment.ReceiptID, err)
+	}
+}
+
+func (h *Handler) serveMissingRendition(w http.ResponseWriter, r *http.Request, attachment *types.Attachment, rendition configs.Rendition, key string, format imaging.Format) {
+	img, err := decodeAttachment(r.Context(), h.blobs, attachment)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	entry, err := renderRendition(img, rendition, format)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := h.blobs.Put(r.Context(), key, bytes.NewReader(entry.Data), int64(len(entry.Data)), entry.ContentType); err != nil {
+		log.Printf("failed to store rendition %s of receipt %d: %v", rendition.Name, attachment.ReceiptID, err)
+	}
+
+	writeImage(w, entry)
+}
+
+// RenditionBackfill generates the missing renditions of the stored images,
+// such as those uploaded before renditions existed or before a rendition was
+// added to the configuration.
+type RenditionBackfill struct {
+	store types.ReceiptStore
+	blobs storage.BlobStore
+}
+
+func NewRenditionBackfill(store types.ReceiptStore, blobs storage.BlobStore) *RenditionBackfill {
+	return &RenditionBackfill{store: store, blobs: blobs}
+}
+
+// Run goes through every attachment and returns how many renditions it
+// stored. With force, existing renditions are rendered again, e.g. after the
+// size of a rendition changed. Images that cannot be decoded are logged and
+// skipped.
+func (b *RenditionBackfill) Run(ctx context.Context, force bool) (int, error) {
+	stored := 0
+	afterID := 0
+	for {
+		attachments, err := b.store.ListAttachmentsAfter(afterID, backfillBatchSize)
+		if err != nil {
+			return stored, err
+		}
+
+		for i := range attachments {
+			if err := ctx.Err(); err != nil {
+				return stored, err
+			}
+
+			attachment := &attachments[i]
+			afterID = attachment.ID
+
+			img, err := decodeAttachment(ctx, b.blobs, attachment)
+			if errors.Is(err, imaging.ErrNoPreview) {
+				continue
+			}
+			if err != nil {
+				log.Printf("skipping attachment %d of receipt %d: %v", attachment.Position, attachment.ReceiptID, err)
+				continue
+			}
+
+			n, err := storeRenditions(ctx, b.blobs, attachment, img, force)
+			stored += n
+			if err != nil {
+				return stored, err
+			}
+		}
+
+		if len(attachments) < backfillBatchSize {
+			return stored, nil
+		}
+	}
+}
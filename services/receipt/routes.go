@@ -1,17 +1,24 @@
 package receipt
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
 	"io"
+	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/cache"
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/ocr"
 	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/storage"
 	"github.com/groshiniprasad/uploady/types"
 	"github.com/groshiniprasad/uploady/utils"
 )
@@ -19,148 +26,598 @@ import (
 type Handler struct {
 	store     types.ReceiptStore
 	userStore types.UserStore
+	blobs     storage.BlobStore
+	cache     *cache.Cache
+	// jobs queues the processing of uploaded images
+	jobs types.JobStore
+	// extractor reads the receipts' fields from their images, nil when OCR
+	// is disabled
+	extractor ocr.Extractor
+	// rates converts the amounts into the users' home currencies, nil to
+	// leave them as they are
+	rates types.ExchangeRateStore
 }
 
-func NewHandler(store types.ReceiptStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+func NewHandler(store types.ReceiptStore, userStore types.UserStore, blobs storage.BlobStore, cache *cache.Cache, jobs types.JobStore, extractor ocr.Extractor, rates types.ExchangeRateStore) *Handler {
+	return &Handler{store: store, userStore: userStore, blobs: blobs, cache: cache, jobs: jobs, extractor: extractor, rates: rates}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	// Routers to get all receipts of a user
+	router.HandleFunc("/receipts", auth.WithJWTAuth(h.handleGetReceipts, h.userStore)).Methods(http.MethodGet)
 
 	router.HandleFunc("/receipts/upload", auth.WithJWTAuth(h.handleCreateReceipt, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/receipts/bulk", auth.WithJWTAuth(h.handleBulkUpload, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/receipts/trash", auth.WithJWTAuth(h.handleGetTrash, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/receipts/summary", auth.WithJWTAuth(h.handleGetReceiptSummary, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/receipts/{id}/similar", auth.WithJWTAuth(h.handleGetSimilarReceipts, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/receipts/{id}/restore", auth.WithJWTAuth(h.handleRestoreReceipt, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/receipts/{id}/attachments", auth.WithJWTAuth(h.handleGetAttachments, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/receipts/{id}/attachments", auth.WithJWTAuth(h.handleAddAttachment, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/receipts/{id}/attachments/order", auth.WithJWTAuth(h.handleReorderAttachments, h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/receipts/{id}/attachments/{n:[0-9]+}", auth.WithJWTAuth(h.handleGetAttachmentImage, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/receipts/{id}/attachments/{n:[0-9]+}", auth.WithJWTAuth(h.handleReplaceAttachment, h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/receipts/{id}/attachments/{n:[0-9]+}", auth.WithJWTAuth(h.handleRemoveAttachment, h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/receipts/{id}/items", auth.WithJWTAuth(h.handleGetLineItems, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/receipts/{id}/items", auth.WithJWTAuth(h.handleCreateLineItem, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/receipts/{id}/items/{itemId:[0-9]+}", auth.WithJWTAuth(h.handleUpdateLineItem, h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/receipts/{id}/items/{itemId:[0-9]+}", auth.WithJWTAuth(h.handleDeleteLineItem, h.userStore)).Methods(http.MethodDelete)
 	router.HandleFunc("/receipts/{id}", auth.WithJWTAuth(h.handleGetResizedReceiptsV2, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/receipts/{id}", auth.WithJWTAuth(h.handleUpdateReceipt, h.userStore)).Methods(http.MethodPatch)
+	router.HandleFunc("/receipts/{id}", auth.WithJWTAuth(h.handleDeleteReceipt, h.userStore)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/categories", auth.WithJWTAuth(h.handleGetCategories, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/categories", auth.WithJWTAuth(h.handleCreateCategory, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/categories/{categoryId:[0-9]+}", auth.WithJWTAuth(h.handleUpdateCategory, h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/categories/{categoryId:[0-9]+}", auth.WithJWTAuth(h.handleDeleteCategory, h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/tags", auth.WithJWTAuth(h.handleGetTags, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/tags/{tagId:[0-9]+}", auth.WithJWTAuth(h.handleRenameTag, h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/tags/{tagId:[0-9]+}", auth.WithJWTAuth(h.handleDeleteTag, h.userStore)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/cache/stats", auth.WithAdminAuth(h.handleGetCacheStats, h.userStore)).Methods(http.MethodGet)
 
 }
 
 func (h *Handler) handleCreateReceipt(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 
-	// Parse the multipart form, limit file size to 10MB
-	err := r.ParseMultipartForm(10 << 20) // 10 MB
+	// Stream the form, spooling the image as it arrives
+	form, status, err := readUploadForm(w, r, "image")
 	if err != nil {
-		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, status, err)
 		return
 	}
+	defer form.Close()
+
+	h.createReceipt(w, r, userID, form.fields, form.upload)
+}
+
+// CreateReceiptFromUpload creates a receipt from a file uploaded by other
+// means than a form, such as a resumable upload, applying the same checks as
+// the upload endpoint. fields hold the receipt's form fields.
+func (h *Handler) CreateReceiptFromUpload(w http.ResponseWriter, r *http.Request, fields url.Values, file io.Reader) {
+	userID := auth.GetUserIDFromContext(r.Context())
 
-	// Extract the metadata from the form
-	name := r.FormValue("name")
-	amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
+	upload, status, err := openUpload(file)
 	if err != nil {
-		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		utils.WriteError(w, status, err)
 		return
 	}
+	defer upload.Close()
+
+	h.createReceipt(w, r, userID, fields, upload)
+}
 
-	dateStr := r.FormValue("date")
-	date, err := time.Parse("2006-01-02", dateStr)
+func (h *Handler) createReceipt(w http.ResponseWriter, r *http.Request, userID int, fields url.Values, upload *spooledUpload) {
+	// Fields left out of the form are read from the image
+	fields, suggestions := h.suggestFields(r.Context(), fields, upload)
+
+	receipt, err := newReceipt(userID, fields, upload)
 	if err != nil {
-		http.Error(w, "Invalid date format", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	receipt.Suggestions = suggestions
 
-	// Get the file from the form
-	file, fileHeader, err := r.FormFile("image")
-	if err != nil {
-		http.Error(w, "Error uploading file: "+err.Error(), http.StatusBadRequest)
+	if err := h.checkCategory(receipt.CategoryID, userID); err != nil {
+		writeCategoryError(w, err)
 		return
 	}
-	defer file.Close()
 
-	// Validate the file using the isValidFile function
-	isValid, validationMsg := utils.IsValidImageFile(file, fileHeader)
-	if !isValid {
-		http.Error(w, validationMsg, http.StatusBadRequest)
-		return
+	// Refuse identical re-uploads unless the client insists
+	force, _ := strconv.ParseBool(fields.Get("force"))
+	if !force {
+		existing, err := h.store.GetReceiptByDigest(upload.digest, userID)
+		if err == nil {
+			utils.WriteJSON(w, http.StatusConflict, map[string]any{
+				"error":     "this image has already been uploaded, set force=true to add it again",
+				"receiptId": existing.ID,
+			})
+			return
+		}
+		if !errors.Is(err, ErrReceiptNotFound) {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
 	}
 
-	// Save the image file to disk (or cloud storage)
-	filename := utils.GenerateUniqueFilename(fileHeader.Filename)
-	filePath := fmt.Sprintf("./uploads/%s", filename)
-	dst, err := os.Create(filePath)
+	// Fingerprint the picture to spot other photos of the same receipt
+	receipt.PHash, err = upload.fingerprint(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to save file: "+err.Error(), http.StatusInternalServerError)
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
-	defer dst.Close()
 
-	// Copy the file data to the destination
-	_, err = io.Copy(dst, file)
+	// Save the image file to the blob store, once per distinct content
+	written, err := storeBlob(r.Context(), h.blobs, upload)
 	if err != nil {
 		http.Error(w, "Error saving file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// date is now of type time.Timeeipt object (this could be inserted into a database)
-	receipt := types.Receipt{
-		UserID:    userID,
-		Name:      name,
-		Amount:    amount,
-		Date:      date,
-		ImagePath: filePath, // Save the path where the image is stored
-	}
-
-	_, err = h.store.CreateReceipt(receipt)
+	receipt.ID, err = h.store.CreateReceipt(*receipt)
 	if err != nil {
+		// Don't leave a new image behind without a receipt pointing at it
+		h.discardBlob(written, receipt.ImageKey)
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
+	h.keepBlob(r.Context(), upload)
+
+	res := types.UploadReceiptResponse{Receipt: *receipt}
+
+	// Warn about a likely duplicate of an earlier photo of the same receipt
+	if receipt.PHash != nil {
+		var duplicate types.ProcessReceiptResult
+		h.warnAboutDuplicate(receipt, &duplicate)
+		res.DuplicateOf, res.Warning = duplicate.DuplicateOf, duplicate.Warning
+	}
+
+	// Thumbnails, renditions and OCR happen in the background
+	res.JobID, err = h.enqueueProcessing(receipt)
+	if err != nil {
+		log.Printf("failed to queue the processing of receipt %d: %v", receipt.ID, err)
+	}
 
 	// Respond with success
-	fmt.Fprintf(w, "Receipt uploaded successfully: %+v\n", receipt)
+	utils.WriteJSON(w, http.StatusCreated, res)
+}
 
+// newReceipt builds the receipt for an upload from its form fields: name,
+// amount and currency, which defaults to the configured one, date, which
+// defaults to the photo's capture date, description, categoryId and tags.
+func newReceipt(userID int, fields url.Values, upload *spooledUpload) (*types.Receipt, error) {
+	currency := configs.Envs.DefaultCurrency
+	if code := fields.Get("currency"); code != "" {
+		var err error
+		currency, err = money.ParseCurrency(code)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if fields.Get("amount") == "" {
+		return nil, errAmountRequired
+	}
+	amount, err := money.Parse(fields.Get("amount"), currency)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Sign() <= 0 {
+		return nil, errAmountNotPositive
+	}
+
+	// Without a date the photo's capture date is used
+	var date time.Time
+	if dateStr := fields.Get("date"); dateStr != "" {
+		date, err = time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			return nil, errInvalidDate
+		}
+	}
+
+	if date.IsZero() {
+		if upload.meta.CapturedAt.IsZero() {
+			return nil, errDateRequired
+		}
+		y, m, d := upload.meta.CapturedAt.Date()
+		date = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	var categoryID *int
+	if str := fields.Get("categoryId"); str != "" {
+		id, err := strconv.Atoi(str)
+		if err != nil || id <= 0 {
+			return nil, errInvalidCategory
+		}
+		categoryID = &id
+	}
+
+	tags, err := parseTags(fields["tags"])
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &types.Receipt{
+		UserID:       userID,
+		Name:         fields.Get("name"),
+		Amount:       amount,
+		Currency:     currency,
+		Date:         date,
+		Description:  fields.Get("description"),
+		CategoryID:   categoryID,
+		Tags:         tags,
+		ImageKey:     upload.key(), // Save the key the image is stored under
+		ImageDigest:  upload.digest,
+		ImageSize:    upload.size,
+		ContentType:  upload.contentType,
+		Orientation:  upload.meta.Orientation,
+		CameraWidth:  upload.meta.Width,
+		CameraHeight: upload.meta.Height,
+		// The image is processed by a job once the receipt is created
+		ProcessingStatus: types.ProcessingPending,
+	}
+	if !upload.meta.CapturedAt.IsZero() {
+		receipt.CapturedAt = &upload.meta.CapturedAt
+	}
+
+	return receipt, nil
 }
 
-func (h *Handler) handleGetResizedReceiptsV2(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+// handleGetSimilarReceipts lists the user's receipts whose images look like
+// the given receipt's, closest first.
+func (h *Handler) handleGetSimilarReceipts(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 
-	str, ok := vars["id"]
-	if !ok {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing receipt ID"))
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	receiptID, err := strconv.Atoi(str)
+	maxDistance := int(configs.Envs.PHashThreshold)
+	if str := r.URL.Query().Get("maxDistance"); str != "" {
+		maxDistance, err = strconv.Atoi(str)
+		if err != nil || maxDistance < 0 || maxDistance > 64 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("maxDistance must be between 0 and 64"))
+			return
+		}
+	}
+
+	receipt, err := h.store.GetReceiptByID(receiptID, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	similar := []types.SimilarReceipt{}
+	if receipt.PHash != nil {
+		similar, err = h.store.FindSimilarReceipts(types.SimilarReceiptQuery{
+			UserID:      userID,
+			PHash:       *receipt.PHash,
+			MaxDistance: maxDistance,
+			ExcludeID:   receipt.ID,
+		})
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"receipts": similar})
+}
+
+func (h *Handler) handleUpdateReceipt(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid receipt ID"))
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.UpdateReceiptPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
 		return
 	}
 
 	receipt, err := h.store.GetReceiptByID(receiptID, userID)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		writeStoreError(w, err)
+		return
+	}
+
+	if payload.Name != nil {
+		receipt.Name = *payload.Name
+	}
+	if payload.Amount != nil || payload.Currency != nil {
+		// The amount is checked against the decimal places of the currency
+		// even when only one of them changes
+		previous := receipt.Currency
+		if err := updateAmount(receipt, payload.Amount, payload.Currency); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if receipt.Currency != previous {
+			err := h.checkLineItemPrices(receipt, userID)
+			if errors.Is(err, errLineItemCurrency) {
+				utils.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		}
+	}
+	if payload.Date != nil {
+		// Already checked by the datetime validation tag
+		receipt.Date, _ = time.Parse("2006-01-02", *payload.Date)
+	}
+	if payload.Description != nil {
+		receipt.Description = *payload.Description
+	}
+	if payload.CategoryID != nil {
+		receipt.CategoryID = payload.CategoryID
+		if *payload.CategoryID == 0 {
+			receipt.CategoryID = nil
+		}
+		if err := h.checkCategory(receipt.CategoryID, userID); err != nil {
+			writeCategoryError(w, err)
+			return
+		}
+	}
+	if payload.Tags != nil {
+		receipt.Tags, err = parseTags(*payload.Tags)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := h.store.UpdateReceipt(*receipt); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, receipt)
+}
+
+// updateAmount sets the receipt's amount and currency to the given ones,
+// keeping the current values of those left nil.
+func updateAmount(receipt *types.Receipt, amount *json.Number, code *string) error {
+	currency := receipt.Currency
+	if code != nil {
+		var err error
+		currency, err = money.ParseCurrency(*code)
+		if err != nil {
+			return err
+		}
+	}
+
+	str := receipt.Amount.String()
+	if amount != nil {
+		str = amount.String()
+	}
+
+	parsed, err := money.Parse(str, currency)
+	if err != nil {
+		return err
+	}
+	if parsed.Sign() <= 0 {
+		return errAmountNotPositive
+	}
+
+	receipt.Amount, receipt.Currency = parsed, currency
+	return nil
+}
+
+// handleDeleteReceipt moves the receipt to the trash. The image stays in
+// place until the purger removes the receipt for good.
+func (h *Handler) handleDeleteReceipt(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// Open the file
-	file, err := os.Open(receipt.ImagePath)
+	if err := h.store.DeleteReceipt(receiptID, userID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.cache.InvalidateReceipt(receiptID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receipts, err := h.store.ListTrashedReceipts(userID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
-	defer file.Close()
 
-	// Decode the image
-	img, _, err := image.Decode(file)
+	utils.WriteJSON(w, http.StatusOK, types.ReceiptPage{Receipts: receipts})
+}
+
+func (h *Handler) handleRestoreReceipt(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	receiptID, err := parseReceiptID(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.RestoreReceipt(receiptID, userID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	receipt, err := h.store.GetReceiptByID(receiptID, userID)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error decoding image: %v", err))
+		writeStoreError(w, err)
 		return
 	}
 
-	width, height := utils.GetWidthHeightFromQuery(r)
+	utils.WriteJSON(w, http.StatusOK, receipt)
+}
 
-	// Resize the image
-	resizedImg := utils.ResizeImage(img, width, height)
+func (h *Handler) handleGetReceipts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
 
-	// Set the content type
-	w.Header().Set("Content-Type", "image/jpeg")
+	filter, err := parseListFilter(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.UserID = userID
 
-	// Encode and write the resized image to the response writer
-	err = jpeg.Encode(w, resizedImg, nil)
+	page, err := h.store.ListReceipts(filter)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error encoding image: %v", err))
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.convertReceipts(userID, page.Receipts); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+// parseListFilter reads the listing query parameters. Dates use the same
+// 2006-01-02 layout as uploads; "to" is inclusive of the whole day. A
+// category includes its subcategories and "tag" may be repeated.
+func parseListFilter(r *http.Request) (types.ReceiptListFilter, error) {
+	q := r.URL.Query()
+	filter := types.ReceiptListFilter{
+		Cursor:     q.Get("cursor"),
+		NamePrefix: q.Get("name"),
+		Sort:       q.Get("sort"),
+	}
+
+	if _, err := parseListOrder(filter.Sort); err != nil {
+		return filter, err
+	}
+
+	if str := q.Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+		}
+		filter.Limit = limit
+	}
+
+	if str := q.Get("from"); str != "" {
+		from, err := time.Parse("2006-01-02", str)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date, expected YYYY-MM-DD")
+		}
+		filter.DateFrom = &from
+	}
+
+	if str := q.Get("to"); str != "" {
+		to, err := time.Parse("2006-01-02", str)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date, expected YYYY-MM-DD")
+		}
+		to = to.AddDate(0, 0, 1)
+		filter.DateTo = &to
+	}
+
+	// Amounts are read with the decimal places of the currency filtered by,
+	// or else of the default currency
+	amountCurrency := configs.Envs.DefaultCurrency
+	if str := q.Get("currency"); str != "" {
+		currency, err := money.ParseCurrency(str)
+		if err != nil {
+			return filter, err
+		}
+		filter.Currency, amountCurrency = currency, currency
+	}
+
+	if str := q.Get("minAmount"); str != "" {
+		min, err := money.Parse(str, amountCurrency)
+		if err != nil || min.Sign() < 0 {
+			return filter, fmt.Errorf("invalid minAmount")
+		}
+		filter.MinAmount = &min
+	}
+
+	if str := q.Get("maxAmount"); str != "" {
+		max, err := money.Parse(str, amountCurrency)
+		if err != nil || max.Sign() < 0 {
+			return filter, fmt.Errorf("invalid maxAmount")
+		}
+		filter.MaxAmount = &max
+	}
+
+	if str := q.Get("category"); str != "" {
+		categoryID, err := strconv.Atoi(str)
+		if err != nil || categoryID <= 0 {
+			return filter, fmt.Errorf("invalid category")
+		}
+		filter.CategoryID = &categoryID
+	}
+
+	// Receipts must carry all of the tags
+	tags, err := parseTags(q["tag"])
+	if err != nil {
+		return filter, err
+	}
+	if len(tags) > 0 {
+		filter.Tags = tags
+	}
+
+	if filter.DateFrom != nil && filter.DateTo != nil && !filter.DateFrom.Before(*filter.DateTo) {
+		return filter, fmt.Errorf("from date must not be after to date")
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && filter.MinAmount.Cmp(*filter.MaxAmount) > 0 {
+		return filter, fmt.Errorf("minAmount must not exceed maxAmount")
+	}
+
+	return filter, nil
+}
+
+func parseReceiptID(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, fmt.Errorf("missing receipt ID")
+	}
+
+	receiptID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid receipt ID")
+	}
+
+	return receiptID, nil
 }
 
-//func (h *Handler) handleGetReceipts(w http.ResponseWriter, r *http.Request) {}
+// writeStoreError maps store errors onto HTTP status codes.
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrReceiptNotFound), errors.Is(err, ErrAttachmentNotFound), errors.Is(err, ErrLineItemNotFound),
+		errors.Is(err, ErrCategoryNotFound), errors.Is(err, ErrTagNotFound), errors.Is(err, storage.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrLastAttachment), errors.Is(err, ErrCategoryInUse), errors.Is(err, ErrTagExists):
+		utils.WriteError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrInvalidOrder), errors.Is(err, ErrInvalidParent):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,1926 @@
+package receipt
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/cache"
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/imaging"
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/ocr"
+	"github.com/groshiniprasad/uploady/queue"
+	"github.com/groshiniprasad/uploady/storage"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+func TestReceiptServiceHandlers(t *testing.T) {
+	store := &mockReceiptStore{}
+	handler := NewHandler(store, &mockUserStore{}, nil, nil, nil, nil, nil)
+
+	t.Run("should fail if the limit is out of range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/receipts?limit=1000", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts", handler.handleGetReceipts).Methods(http.MethodGet)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should fail if the sort key is unknown", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/receipts?sort=imagePath", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts", handler.handleGetReceipts).Methods(http.MethodGet)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should fail if the requested image is too large", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/receipts/1?width=100000&mode=fit", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts/{id}", handler.handleGetResizedReceiptsV2).Methods(http.MethodGet)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should pass the filters to the store", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/receipts?from=2024-01-01&to=2024-01-31&minAmount=5&name=caf&sort=-amount&limit=10", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts", handler.handleGetReceipts).Methods(http.MethodGet)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		f := store.lastFilter
+		if f.Limit != 10 || f.Sort != "-amount" || f.NamePrefix != "caf" {
+			t.Errorf("unexpected filter: %+v", f)
+		}
+		if f.MinAmount == nil || f.MinAmount.String() != "5.00" || f.MaxAmount != nil {
+			t.Errorf("unexpected amount range: %+v", f)
+		}
+		if f.DateTo == nil || !f.DateTo.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected to date to include the whole day, got %v", f.DateTo)
+		}
+	})
+}
+
+func TestReceiptUpdateAndDelete(t *testing.T) {
+	t.Run("should fail if the amount is not positive", func(t *testing.T) {
+		store := &mockReceiptStore{receipt: &types.Receipt{ID: 1, Name: "Lunch", Amount: money.New(1200, "USD"), Currency: "USD"}}
+		handler := NewHandler(store, &mockUserStore{}, nil, nil, nil, nil, nil)
+
+		req, err := http.NewRequest(http.MethodPatch, "/receipts/1", strings.NewReader(`{"amount": 0}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts/{id}", handler.handleUpdateReceipt).Methods(http.MethodPatch)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should only update the fields that are set", func(t *testing.T) {
+		store := &mockReceiptStore{receipt: &types.Receipt{ID: 1, Name: "Lunch", Amount: money.New(1200, "USD"), Currency: "USD"}}
+		handler := NewHandler(store, &mockUserStore{}, nil, nil, nil, nil, nil)
+
+		req, err := http.NewRequest(http.MethodPatch, "/receipts/1", strings.NewReader(`{"name": "Dinner"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts/{id}", handler.handleUpdateReceipt).Methods(http.MethodPatch)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if store.updated == nil || store.updated.Name != "Dinner" || store.updated.Amount.String() != "12.00" {
+			t.Errorf("unexpected update: %+v", store.updated)
+		}
+	})
+
+	t.Run("should refuse a currency the line items do not fit", func(t *testing.T) {
+		store := &mockReceiptStore{
+			receipt: &types.Receipt{ID: 1, Name: "Lunch", Amount: money.New(1200, "USD"), Currency: "USD"},
+			items:   []types.LineItem{{ID: 1, ReceiptID: 1, Description: "Soup", Quantity: 1, UnitPrice: money.New(1250, "USD")}},
+		}
+		handler := NewHandler(store, &mockUserStore{}, nil, nil, nil, nil, nil)
+
+		router := mux.NewRouter()
+		router.HandleFunc("/receipts/{id}", handler.handleUpdateReceipt).Methods(http.MethodPatch)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPatch, "/receipts/1", strings.NewReader(`{"amount": "1300", "currency": "JPY"}`)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body)
+		}
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPatch, "/receipts/1", strings.NewReader(`{"amount": "11.00", "currency": "eur"}`)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if store.updated.Currency != "EUR" || store.updated.Amount.String() != "11.00" {
+			t.Errorf("unexpected update: %+v", store.updated)
+		}
+	})
+
+	t.Run("should move the receipt to the trash", func(t *testing.T) {
+		store := &mockReceiptStore{receipt: &types.Receipt{ID: 1}}
+		handler := NewHandler(store, &mockUserStore{}, nil, nil, nil, nil, nil)
+
+		req, err := http.NewRequest(http.MethodDelete, "/receipts/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts/{id}", handler.handleDeleteReceipt).Methods(http.MethodDelete)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if store.trashed != 1 {
+			t.Errorf("expected receipt 1 to be trashed, got %d", store.trashed)
+		}
+	})
+}
+
+func TestReceiptUpload(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &mockReceiptStore{}
+	jobs := &mockJobStore{}
+	handler := NewHandler(store, &mockUserStore{}, blobs, nil, jobs, nil, nil)
+	image := testPNG(t)
+
+	upload := func(force bool) *httptest.ResponseRecorder {
+		req := newUploadRequest(t, map[string]string{
+			"name":   "Lunch",
+			"amount": "12.50",
+			"date":   "2024-03-04",
+			"force":  strconv.FormatBool(force),
+		}, "receipt.png", image)
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		return rr
+	}
+
+	t.Run("should store a new upload by its digest", func(t *testing.T) {
+		rr := upload(false)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		key := store.created[0].ImageKey
+		if !strings.HasPrefix(key, "sha256/") || !strings.HasSuffix(key, ".png") {
+			t.Errorf("expected a content addressed key, got %q", key)
+		}
+		if _, err := blobs.Stat(context.Background(), key); err != nil {
+			t.Errorf("expected image to be stored: %v", err)
+		}
+	})
+
+	t.Run("should reject an identical re-upload", func(t *testing.T) {
+		rr := upload(false)
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), `"receiptId":1`) {
+			t.Errorf("expected the existing receipt ID in %s", rr.Body)
+		}
+	})
+
+	t.Run("should share the image when forced", func(t *testing.T) {
+		rr := upload(true)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if len(store.created) != 2 || store.created[1].ImageKey != store.created[0].ImageKey {
+			t.Errorf("expected both receipts to share one image: %+v", store.created)
+		}
+	})
+
+	t.Run("should store the image again if it was deleted meanwhile", func(t *testing.T) {
+		// The image is found, then removed by the last receipt sharing it
+		store.onCreate = func(r types.Receipt) {
+			if err := blobs.Delete(context.Background(), r.ImageKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		defer func() { store.onCreate = nil }()
+
+		rr := upload(true)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if _, err := blobs.Stat(context.Background(), store.created[0].ImageKey); err != nil {
+			t.Errorf("expected the image to be stored again: %v", err)
+		}
+	})
+
+	t.Run("should warn about a similar receipt", func(t *testing.T) {
+		store.similar = []types.SimilarReceipt{{Receipt: types.Receipt{ID: 1}, Distance: 3}}
+		defer func() { store.similar = nil }()
+
+		rr := upload(true)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), `"duplicateOf":1`) {
+			t.Errorf("expected a duplicateOf warning in %s", rr.Body)
+		}
+		if store.created[len(store.created)-1].PHash == nil {
+			t.Error("expected the perceptual hash to be stored")
+		}
+	})
+
+	t.Run("should queue the processing of the receipt", func(t *testing.T) {
+		rr := upload(true)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), `"processingStatus":"pending"`) {
+			t.Errorf("expected a pending receipt in %s", rr.Body)
+		}
+
+		job := jobs.enqueued[len(jobs.enqueued)-1]
+		if job.Kind != JobProcessReceipt || string(job.Payload) != `{"receiptId":5}` {
+			t.Errorf("unexpected job %+v", job)
+		}
+		if !strings.Contains(rr.Body.String(), fmt.Sprintf(`"jobId":%d`, len(jobs.enqueued))) {
+			t.Errorf("expected the job ID in %s", rr.Body)
+		}
+	})
+
+	t.Run("should reject unsupported files by their content", func(t *testing.T) {
+		req := newUploadRequest(t, map[string]string{"name": "Lunch", "amount": "1", "date": "2024-03-04"}, "receipt.png", []byte("just some text"))
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("expected status code %d, got %d", http.StatusUnsupportedMediaType, rr.Code)
+		}
+	})
+
+	t.Run("should refuse oversized uploads with 413", func(t *testing.T) {
+		big := append(append([]byte{}, image[:16]...), make([]byte, utils.MaxFileSize)...)
+		req := newUploadRequest(t, map[string]string{"name": "Lunch", "amount": "1", "date": "2024-03-04"}, "receipt.png", big)
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("should refuse images too large to decode with 422", func(t *testing.T) {
+		limit := configs.Envs.MaxImageDimension
+		configs.Envs.MaxImageDimension = 4
+		defer func() { configs.Envs.MaxImageDimension = limit }()
+
+		req := newUploadRequest(t, map[string]string{"name": "Lunch", "amount": "1", "date": "2024-03-04"}, "huge.png", testPNG(t))
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusUnprocessableEntity, rr.Code, rr.Body)
+		}
+		if !strings.Contains(rr.Body.String(), "8x8 pixels") {
+			t.Errorf("expected the dimensions in %s", rr.Body)
+		}
+	})
+
+	t.Run("should store files without a preview as they are", func(t *testing.T) {
+		heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+		req := newUploadRequest(t, map[string]string{"name": "Lunch", "amount": "1", "date": "2024-03-04"}, "IMG_0001.HEIC", heic)
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		created := store.created[len(store.created)-1]
+		if created.ContentType != "image/heic" || !strings.HasSuffix(created.ImageKey, ".heic") || created.PHash != nil {
+			t.Errorf("expected an unhashed heic receipt, got %+v", created)
+		}
+	})
+
+	t.Run("should strip the metadata and default to the capture date", func(t *testing.T) {
+		req := newUploadRequest(t, map[string]string{"name": "Taxi", "amount": "30"}, "photo.jpg", testExifJPEG(t))
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		created := store.created[len(store.created)-1]
+		if created.Date.Format("2006-01-02") != "2024-10-05" || created.CapturedAt == nil {
+			t.Errorf("expected the capture date, got %v", created.Date)
+		}
+		if created.Orientation != 6 {
+			t.Errorf("expected orientation 6, got %d", created.Orientation)
+		}
+
+		file, _, err := blobs.Get(context.Background(), created.ImageKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		stored, _ := io.ReadAll(file)
+		if bytes.Contains(stored, []byte("PhoneMaker")) {
+			t.Error("expected the device details to be stripped")
+		}
+		if meta, err := imaging.ReadExif(bytes.NewReader(stored)); err != nil || meta.Orientation != 6 {
+			t.Errorf("expected the orientation to be kept, got %+v, %v", meta, err)
+		}
+	})
+
+	t.Run("should validate a finished resumable upload like a form", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/uploads/abc/finalize", nil)
+
+		rr := httptest.NewRecorder()
+		handler.CreateReceiptFromUpload(rr, req, url.Values{"name": {"Lunch"}, "amount": {"1"}}, bytes.NewReader(image))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d without a date, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		rr = httptest.NewRecorder()
+		handler.CreateReceiptFromUpload(rr, req, url.Values{"name": {"Lunch"}, "amount": {"1"}, "date": {"2024-03-04"}, "force": {"true"}}, bytes.NewReader(image))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		if created := store.created[len(store.created)-1]; created.Name != "Lunch" || created.ContentType != "image/png" {
+			t.Errorf("unexpected receipt %+v", created)
+		}
+	})
+
+	t.Run("should require the amount without OCR", func(t *testing.T) {
+		req := newUploadRequest(t, map[string]string{"name": "Lunch", "date": "2024-03-04", "force": "true"}, "receipt.png", image)
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errAmountRequired.Error()) {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("should refuse an amount that is not positive", func(t *testing.T) {
+		for _, amount := range []string{"0", "-12.50"} {
+			req := newUploadRequest(t, map[string]string{"name": "Lunch", "amount": amount, "date": "2024-03-04", "force": "true"}, "receipt.png", image)
+
+			rr := httptest.NewRecorder()
+			handler.handleCreateReceipt(rr, req)
+			if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errAmountNotPositive.Error()) {
+				t.Errorf("expected status code %d for %s, got %d: %s", http.StatusBadRequest, amount, rr.Code, rr.Body)
+			}
+		}
+	})
+
+	t.Run("should keep the amount exact in the given currency", func(t *testing.T) {
+		req := newUploadRequest(t, map[string]string{"name": "Ramen", "amount": "1200.5", "currency": "jpy", "date": "2024-03-04", "force": "true"}, "receipt.png", image)
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d for a fraction of a yen, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		req = newUploadRequest(t, map[string]string{"name": "Ramen", "amount": "1200", "currency": "jpy", "date": "2024-03-04", "force": "true"}, "receipt.png", image)
+
+		rr = httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		if created := store.created[len(store.created)-1]; created.Currency != "JPY" || created.Amount.Minor() != 1200 {
+			t.Errorf("unexpected receipt %+v", created)
+		}
+		if !strings.Contains(rr.Body.String(), `"amount":"1200","currency":"JPY"`) {
+			t.Errorf("expected the amount as a string with its currency in %s", rr.Body)
+		}
+	})
+
+	t.Run("should fill in the missing fields from OCR", func(t *testing.T) {
+		handler := NewHandler(store, &mockUserStore{}, blobs, nil, jobs, ocr.NewFake("Corner Grocery", "05.11.2024", "TOTAL 4.09"), nil)
+		req := newUploadRequest(t, map[string]string{"name": "Groceries", "force": "true"}, "receipt.png", image)
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		created := store.created[len(store.created)-1]
+		if created.Name != "Groceries" || created.Amount.String() != "4.09" {
+			t.Errorf("expected the given name and the read amount, got %+v", created)
+		}
+		if created.Date.Format("2006-01-02") != "2024-11-05" {
+			t.Errorf("expected the read date, got %v", created.Date)
+		}
+		if created.Suggestions == nil || created.Suggestions.Name == nil || created.Suggestions.Name.Value != "Corner Grocery" {
+			t.Errorf("expected the suggestions to be stored, got %+v", created.Suggestions)
+		}
+		if !strings.Contains(rr.Body.String(), `"suggestions":{`) {
+			t.Errorf("expected the suggestions in %s", rr.Body)
+		}
+	})
+
+	t.Run("should still require what OCR cannot read", func(t *testing.T) {
+		handler := NewHandler(store, &mockUserStore{}, blobs, nil, jobs, &ocr.Fake{Err: errors.New("tesseract failed")}, nil)
+		req := newUploadRequest(t, map[string]string{"name": "Lunch", "date": "2024-03-04", "force": "true"}, "receipt.png", image)
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body)
+		}
+	})
+}
+
+func TestProcessReceiptJob(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := testPNG(t)
+	if err := blobs.Put(context.Background(), "receipt.png", bytes.NewReader(original), int64(len(original)), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	imageCache, err := cache.New(1<<20, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &types.Job{Kind: JobProcessReceipt, Payload: json.RawMessage(`{"receiptId":2}`), Attempts: 1, MaxAttempts: 3}
+
+	t.Run("should hash the image and warn about a similar receipt", func(t *testing.T) {
+		store := &mockReceiptStore{
+			receipt: &types.Receipt{ID: 2, ImageKey: "receipt.png", ContentType: "image/png"},
+			similar: []types.SimilarReceipt{{Receipt: types.Receipt{ID: 1}, Distance: 3}},
+		}
+		handler := NewHandler(store, &mockUserStore{}, blobs, imageCache, nil, nil, nil)
+
+		result, err := handler.ProcessReceiptJob(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := result.(*types.ProcessReceiptResult)
+		if res.DuplicateOf == nil || *res.DuplicateOf != 1 {
+			t.Errorf("expected a duplicate of receipt 1, got %+v", res)
+		}
+		if store.receipt.PHash == nil {
+			t.Error("expected the perceptual hash to be stored")
+		}
+		if got := strings.Join(store.statuses, ","); got != "processing,done" {
+			t.Errorf("unexpected processing statuses %s", got)
+		}
+		if imageCache.Stats().Entries != 1 {
+			t.Error("expected the thumbnail to be cached")
+		}
+		for _, r := range configs.Envs.Renditions {
+			if key, _ := renditionKey("receipt.png", r.Name); !blobExists(t, blobs, key) {
+				t.Errorf("expected the %s rendition to be stored", r.Name)
+			}
+		}
+	})
+
+	t.Run("should leave the hash taken at upload", func(t *testing.T) {
+		phash := uint64(42)
+		store := &mockReceiptStore{
+			receipt: &types.Receipt{ID: 2, ImageKey: "receipt.png", ContentType: "image/png", PHash: &phash},
+			similar: []types.SimilarReceipt{{Receipt: types.Receipt{ID: 1}, Distance: 3}},
+		}
+		handler := NewHandler(store, &mockUserStore{}, blobs, nil, nil, nil, nil)
+
+		result, err := handler.ProcessReceiptJob(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res := result.(*types.ProcessReceiptResult); res.DuplicateOf != nil {
+			t.Errorf("expected the duplicate check to be left to the upload, got %+v", res)
+		}
+		if *store.receipt.PHash != 42 {
+			t.Errorf("expected the hash to be kept, got %d", *store.receipt.PHash)
+		}
+	})
+
+	t.Run("should store the fields read from the image", func(t *testing.T) {
+		store := &mockReceiptStore{receipt: &types.Receipt{ID: 2, ImageKey: "receipt.png", ContentType: "image/png"}}
+		handler := NewHandler(store, &mockUserStore{}, blobs, imageCache, nil, ocr.NewFake("Corner Grocery", "TOTAL 4.09"), nil)
+
+		if _, err := handler.ProcessReceiptJob(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+		s := store.receipt.Suggestions
+		if s == nil || s.Amount == nil || s.Amount.Value != "4.09" || s.Date != nil {
+			t.Errorf("expected a suggested amount only, got %+v", s)
+		}
+	})
+
+	t.Run("should fail undecodable images for good", func(t *testing.T) {
+		if err := blobs.Put(context.Background(), "broken.png", strings.NewReader("not a png"), 9, "image/png"); err != nil {
+			t.Fatal(err)
+		}
+		store := &mockReceiptStore{receipt: &types.Receipt{ID: 2, ImageKey: "broken.png", ContentType: "image/png"}}
+		handler := NewHandler(store, &mockUserStore{}, blobs, nil, nil, nil, nil)
+
+		_, err := handler.ProcessReceiptJob(context.Background(), job)
+		if !errors.Is(err, errUndecodable) || !queue.IsFinalAttempt(job, err) {
+			t.Fatalf("expected a permanent error, got %v", err)
+		}
+		if got := store.statuses[len(store.statuses)-1]; got != types.ProcessingFailed {
+			t.Errorf("expected the receipt to be marked failed, got %s", got)
+		}
+	})
+}
+
+func TestRenditionBackfill(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renditions := configs.Envs.Renditions
+	configs.Envs.Renditions = []configs.Rendition{{Name: "thumb", Size: 4}, {Name: "full"}}
+	defer func() { configs.Envs.Renditions = renditions }()
+
+	ctx := context.Background()
+	original := testPNG(t)
+	for _, key := range []string{"a.png", "b.png"} {
+		if err := blobs.Put(ctx, key, bytes.NewReader(original), int64(len(original)), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+	if err := blobs.Put(ctx, "c.heic", bytes.NewReader(heic), int64(len(heic)), "image/heic"); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &mockReceiptStore{attachments: []types.Attachment{
+		{ID: 1, ReceiptID: 1, ImageKey: "a.png", ContentType: "image/png"},
+		{ID: 2, ReceiptID: 2, ImageKey: "b.png", ContentType: "image/png"},
+		{ID: 3, ReceiptID: 3, ImageKey: "c.heic", ContentType: "image/heic"},
+	}}
+	backfill := NewRenditionBackfill(store, blobs)
+
+	t.Run("should store the missing renditions", func(t *testing.T) {
+		thumb := []byte("existing thumbnail")
+		if err := blobs.Put(ctx, "renditions/thumb/a.png", bytes.NewReader(thumb), int64(len(thumb)), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := backfill.Run(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != 3 {
+			t.Errorf("expected 3 renditions to be stored, got %d", stored)
+		}
+
+		file, _, err := blobs.Get(ctx, "renditions/thumb/b.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		img, _, err := image.Decode(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 4 || b.Dy() != 4 {
+			t.Errorf("expected a 4x4 thumbnail, got %v", b)
+		}
+	})
+
+	t.Run("should render existing renditions again when forced", func(t *testing.T) {
+		stored, err := backfill.Run(ctx, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != 4 {
+			t.Errorf("expected 4 renditions to be stored, got %d", stored)
+		}
+	})
+
+	t.Run("should remove renditions with their image", func(t *testing.T) {
+		// A rendition rendered before its name was dropped from the config
+		if err := blobs.Put(ctx, "renditions/retired/b.png", strings.NewReader("x"), 1, "image/png"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := deleteImage(ctx, blobs, "b.png"); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"b.png", "renditions/thumb/b.png", "renditions/full/b.png", "renditions/retired/b.png"} {
+			if blobExists(t, blobs, key) {
+				t.Errorf("expected %s to be removed", key)
+			}
+		}
+	})
+}
+
+func TestBulkUpload(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := testPNG(t)
+	bulk := func(store *mockReceiptStore, extractor ocr.Extractor, files map[string][]byte) types.BulkUploadReport {
+		t.Helper()
+
+		var archive bytes.Buffer
+		zw := zip.NewWriter(&archive)
+		for _, name := range []string{"manifest.csv", "a.png", "b.png", "notes.txt", "../evil.png", "bomb.png", "scans/c.png", "__MACOSX/._a.png"} {
+			content, ok := files[name]
+			if !ok {
+				continue
+			}
+			fw, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(content)
+		}
+		zw.Close()
+
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, err := mw.CreateFormFile("archive", "receipts.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(archive.Bytes())
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/receipts/bulk", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		NewHandler(store, &mockUserStore{}, blobs, nil, &mockJobStore{}, extractor, nil).handleBulkUpload(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var report types.BulkUploadReport
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	t.Run("should report on every file", func(t *testing.T) {
+		store := &mockReceiptStore{}
+		report := bulk(store, nil, map[string][]byte{
+			"manifest.csv": []byte("filename,name,amount,date\n" +
+				"a.png,Lunch,12.50,2024-03-04\n" +
+				"b.png,Lunch again,12.50,2024-03-04\n" +
+				"notes.txt,Notes,1,2024-03-04\n" +
+				"c.png,Taxi,30,2024-03-05\n" +
+				"missing.png,Missing,1,2024-03-04\n"),
+			"a.png":            image,
+			"b.png":            image,
+			"notes.txt":        []byte("just some text"),
+			"../evil.png":      image,
+			"bomb.png":         append(append([]byte{}, image[:16]...), make([]byte, 1<<20)...),
+			"scans/c.png":      testExifJPEG(t),
+			"__MACOSX/._a.png": []byte("resource fork"),
+		})
+
+		want := map[string]string{
+			"a.png":       "created",
+			"b.png":       "skipped",
+			"notes.txt":   "failed",
+			"../evil.png": "failed",
+			"bomb.png":    "failed",
+			"scans/c.png": "created",
+			"missing.png": "failed",
+		}
+		if len(report.Results) != len(want) {
+			t.Fatalf("expected %d results, got %+v", len(want), report.Results)
+		}
+		for _, result := range report.Results {
+			if result.Status != want[result.Filename] {
+				t.Errorf("expected %s to be %s, got %+v", result.Filename, want[result.Filename], result)
+			}
+		}
+		if report.Created != 2 || report.Skipped != 1 || report.Failed != 4 {
+			t.Errorf("unexpected totals: %+v", report)
+		}
+
+		if len(store.created) != 2 || store.created[1].Name != "Taxi" || store.created[1].Amount.String() != "30.00" {
+			t.Fatalf("expected the manifest to be applied, got %+v", store.created)
+		}
+		if report.Results[0].ReceiptID != 1 || report.Results[0].JobID == 0 {
+			t.Errorf("expected the receipt and job IDs in the report, got %+v", report.Results[0])
+		}
+	})
+
+	t.Run("should fail files without an amount", func(t *testing.T) {
+		store := &mockReceiptStore{}
+		report := bulk(store, nil, map[string][]byte{"a.png": image})
+
+		if report.Failed != 1 || len(store.created) != 0 {
+			t.Errorf("expected the file to fail without a manifest, got %+v", report)
+		}
+	})
+
+	t.Run("should read the amount and date of files without a manifest", func(t *testing.T) {
+		store := &mockReceiptStore{}
+		report := bulk(store, ocr.NewFake("Corner Grocery", "05.11.2024", "TOTAL 4.09"), map[string][]byte{"a.png": image})
+
+		if report.Created != 1 || len(store.created) != 1 {
+			t.Fatalf("expected the receipt to be created, got %+v", report)
+		}
+		created := store.created[0]
+		if created.Name != "a" || created.Amount.String() != "4.09" || created.Date.Format("2006-01-02") != "2024-11-05" {
+			t.Errorf("expected the amount and date read from the image, got %+v", created)
+		}
+		if created.Suggestions == nil {
+			t.Error("expected the suggestions to be stored")
+		}
+	})
+}
+
+func TestReceiptImage(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := testPNG(t)
+	if err := blobs.Put(context.Background(), "receipt.png", bytes.NewReader(original), int64(len(original)), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &mockReceiptStore{receipt: &types.Receipt{ID: 1, ImageKey: "receipt.png", ContentType: "image/png"}}
+	handler := NewHandler(store, &mockUserStore{}, blobs, nil, nil, nil, nil)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts/{id}", handler.handleGetResizedReceiptsV2).Methods(http.MethodGet)
+
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	get := func(url, accept string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return serve(req)
+	}
+
+	t.Run("should keep the original format by default", func(t *testing.T) {
+		rr := get("/receipts/1?width=4", "")
+		if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("expected image/png, got %q", ct)
+		}
+	})
+
+	t.Run("should honour the format parameter", func(t *testing.T) {
+		rr := get("/receipts/1?width=4&format=jpeg&quality=50", "image/png")
+		if ct := rr.Header().Get("Content-Type"); ct != "image/jpeg" {
+			t.Errorf("expected image/jpeg, got %q", ct)
+		}
+	})
+
+	t.Run("should negotiate the Accept header", func(t *testing.T) {
+		rr := get("/receipts/1?width=4", "image/gif, image/jpeg;q=0.5")
+		if ct := rr.Header().Get("Content-Type"); ct != "image/gif" {
+			t.Errorf("expected image/gif, got %q", ct)
+		}
+
+		rr = get("/receipts/1?width=4", "text/html")
+		if rr.Code != http.StatusNotAcceptable {
+			t.Errorf("expected status code %d, got %d", http.StatusNotAcceptable, rr.Code)
+		}
+	})
+
+	t.Run("should generate a missing rendition and store it", func(t *testing.T) {
+		rr := get("/receipts/1?rendition=thumb", "")
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("expected a png thumbnail, got status %d: %s", rr.Code, rr.Body)
+		}
+		if _, err := blobs.Stat(context.Background(), "renditions/thumb/receipt.png"); err != nil {
+			t.Errorf("expected the rendition to be stored: %v", err)
+		}
+	})
+
+	t.Run("should serve a stored rendition", func(t *testing.T) {
+		stored := []byte("stored preview")
+		if err := blobs.Put(context.Background(), "renditions/preview/receipt.png", bytes.NewReader(stored), int64(len(stored)), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+
+		rr := get("/receipts/1?rendition=preview", "")
+		if !bytes.Equal(rr.Body.Bytes(), stored) {
+			t.Errorf("expected the stored rendition, got %q", rr.Body)
+		}
+	})
+
+	t.Run("should serve the enhanced rendition in greyscale", func(t *testing.T) {
+		renditions := configs.Envs.Renditions
+		configs.Envs.Renditions = append(renditions, configs.Rendition{Name: "enhanced", Size: 100, Enhance: true})
+		defer func() { configs.Envs.Renditions = renditions }()
+
+		rr := get("/receipts/1?rendition=enhanced", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		img, err := png.Decode(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := img.(*image.Gray); !ok {
+			t.Errorf("expected a greyscale image, got %T", img)
+		}
+	})
+
+	t.Run("should reject unknown or customised renditions", func(t *testing.T) {
+		if rr := get("/receipts/1?rendition=poster", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if rr := get("/receipts/1?rendition=thumb&width=40", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should refuse to decode images over the limits", func(t *testing.T) {
+		pixels := configs.Envs.MaxImagePixels
+		configs.Envs.MaxImagePixels = 16
+		defer func() { configs.Envs.MaxImagePixels = pixels }()
+
+		if rr := get("/receipts/1?width=5", ""); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+		}
+	})
+
+	t.Run("should stream the original bytes in raw mode", func(t *testing.T) {
+		rr := get("/receipts/1?raw=true", "")
+		if !bytes.Equal(rr.Body.Bytes(), original) {
+			t.Error("expected the original bytes")
+		}
+		if cl := rr.Header().Get("Content-Length"); cl != strconv.Itoa(len(original)) {
+			t.Errorf("expected Content-Length %d, got %s", len(original), cl)
+		}
+	})
+
+	t.Run("should serve repeated requests from the cache", func(t *testing.T) {
+		imageCache, err := cache.New(1<<20, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.cache = imageCache
+
+		if rr := get("/receipts/1?width=8&mode=fit", ""); rr.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("expected a cache miss, got %q", rr.Header().Get("X-Cache"))
+		}
+		rr := get("/receipts/1?width=8&mode=fit", "")
+		if rr.Header().Get("X-Cache") != "HIT" || rr.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("expected a cached png, got %q %q", rr.Header().Get("X-Cache"), rr.Header().Get("Content-Type"))
+		}
+		if rr := get("/receipts/1?width=8&mode=fill", ""); rr.Header().Get("X-Cache") != "MISS" {
+			t.Error("expected another mode to miss the cache")
+		}
+
+		// Spellings of the same options share an entry
+		get("/receipts/1?width=8&mode=pad&bg=FFF", "")
+		if rr := get("/receipts/1?width=8&mode=pad&bg=ffffffff&kernel=bilinear", ""); rr.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("expected the same colour and kernel to hit the cache, got %q", rr.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("should answer conditional requests with 304", func(t *testing.T) {
+		rr := get("/receipts/1?width=4", "")
+		tag := rr.Header().Get("ETag")
+		if tag == "" || !strings.HasPrefix(rr.Header().Get("Cache-Control"), "private") {
+			t.Fatalf("expected caching headers, got %v", rr.Header())
+		}
+		if other := get("/receipts/1?width=5", "").Header().Get("ETag"); other == tag {
+			t.Error("expected another size to have another ETag")
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "/receipts/1?width=4", nil)
+		req.Header.Set("If-None-Match", tag)
+		if rr := serve(req); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Errorf("expected status code %d, got %d", http.StatusNotModified, rr.Code)
+		}
+
+		store.receipt.CreatedAt = time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+		req, _ = http.NewRequest(http.MethodGet, "/receipts/1?raw=true", nil)
+		req.Header.Set("If-Modified-Since", store.receipt.CreatedAt.Format(http.TimeFormat))
+		if rr := serve(req); rr.Code != http.StatusNotModified {
+			t.Errorf("expected status code %d, got %d", http.StatusNotModified, rr.Code)
+		}
+	})
+
+	t.Run("should serve byte ranges of the original", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/receipts/1?raw=true", nil)
+		req.Header.Set("Range", "bytes=0-7")
+
+		rr := serve(req)
+		if rr.Code != http.StatusPartialContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusPartialContent, rr.Code)
+		}
+		if !bytes.Equal(rr.Body.Bytes(), original[:8]) {
+			t.Error("expected the first 8 bytes of the original")
+		}
+		if cr := rr.Header().Get("Content-Range"); cr != fmt.Sprintf("bytes 0-7/%d", len(original)) {
+			t.Errorf("unexpected Content-Range %q", cr)
+		}
+	})
+}
+
+func TestReceiptAttachments(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := types.Attachment{ID: 1, ReceiptID: 1, ImageKey: "primary.png", ContentType: "image/png"}
+	store := &mockReceiptStore{
+		receipt:     &types.Receipt{ID: 1},
+		attachments: []types.Attachment{primary},
+	}
+	jobs := &mockJobStore{}
+	handler := NewHandler(store, &mockUserStore{}, blobs, nil, jobs, nil, nil)
+
+	serve := func(method, url string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts/{id}/attachments", handler.handleAddAttachment).Methods(http.MethodPost)
+		router.HandleFunc("/receipts/{id}/attachments/order", handler.handleReorderAttachments).Methods(http.MethodPut)
+		router.HandleFunc("/receipts/{id}/attachments/{n:[0-9]+}", handler.handleGetAttachmentImage).Methods(http.MethodGet)
+		router.HandleFunc("/receipts/{id}/attachments/{n:[0-9]+}", handler.handleRemoveAttachment).Methods(http.MethodDelete)
+
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	image := testPNG(t)
+
+	t.Run("should append an attachment", func(t *testing.T) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "part2.png")
+		fw.Write(image)
+		mw.Close()
+
+		rr := serve(http.MethodPost, "/receipts/1/attachments", &body, mw.FormDataContentType())
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		if len(store.attachments) != 2 || store.attachments[1].Position != 1 {
+			t.Fatalf("expected a second attachment, got %+v", store.attachments)
+		}
+		if len(jobs.enqueued) != 1 {
+			t.Errorf("expected the receipt to be processed again, got %+v", jobs.enqueued)
+		}
+	})
+
+	t.Run("should serve a specific attachment", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/receipts/1/attachments/1?raw=true", nil, "")
+		if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), image) {
+			t.Errorf("expected the second attachment, got status %d", rr.Code)
+		}
+
+		if rr := serve(http.MethodGet, "/receipts/1/attachments/5", nil, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should reorder the attachments", func(t *testing.T) {
+		rr := serve(http.MethodPut, "/receipts/1/attachments/order", strings.NewReader(`{"order": [0, 0]}`), "application/json")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		rr = serve(http.MethodPut, "/receipts/1/attachments/order", strings.NewReader(`{"order": [1, 0]}`), "application/json")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if store.attachments[1].ImageKey != "primary.png" {
+			t.Errorf("expected the primary image to move to the end, got %+v", store.attachments)
+		}
+		if len(jobs.enqueued) != 2 {
+			t.Errorf("expected the new primary image to be processed, got %+v", jobs.enqueued)
+		}
+	})
+
+	t.Run("should keep the last attachment", func(t *testing.T) {
+		if rr := serve(http.MethodDelete, "/receipts/1/attachments/0", nil, ""); rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if len(jobs.enqueued) != 3 {
+			t.Errorf("expected the new primary image to be processed, got %+v", jobs.enqueued)
+		}
+		if rr := serve(http.MethodDelete, "/receipts/1/attachments/0", nil, ""); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+}
+
+func TestLineItems(t *testing.T) {
+	store := &mockReceiptStore{receipt: &types.Receipt{ID: 1, Amount: money.New(23000, "USD"), Currency: "USD"}}
+	handler := NewHandler(store, &mockUserStore{}, nil, nil, nil, nil, nil)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts/{id}/items", handler.handleGetLineItems).Methods(http.MethodGet)
+		router.HandleFunc("/receipts/{id}/items", handler.handleCreateLineItem).Methods(http.MethodPost)
+		router.HandleFunc("/receipts/{id}/items/{itemId:[0-9]+}", handler.handleUpdateLineItem).Methods(http.MethodPut)
+		router.HandleFunc("/receipts/{id}/items/{itemId:[0-9]+}", handler.handleDeleteLineItem).Methods(http.MethodDelete)
+
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should add a line item with its tax", func(t *testing.T) {
+		rr := serve(http.MethodPost, "/receipts/1/items", `{"description": "Lodging", "quantity": 2, "unitPrice": 90, "taxRate": 10, "category": "lodging"}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var res types.LineItemResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.ID != 1 || res.Tax.String() != "18.00" || res.Amount.String() != "198.00" {
+			t.Errorf("unexpected line item %+v", res.LineItem)
+		}
+		if !strings.Contains(res.Warning, "198.00") {
+			t.Errorf("expected a warning about the sum, got %q", res.Warning)
+		}
+	})
+
+	t.Run("should not warn once the line items add up", func(t *testing.T) {
+		// 3 x 10.67 is a cent over the rest of the bill, within rounding
+		rr := serve(http.MethodPost, "/receipts/1/items", `{"description": "Parking", "quantity": 3, "unitPrice": "10.67"}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		rr = serve(http.MethodGet, "/receipts/1/items", "")
+		var list types.LineItemList
+		if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Items) != 2 || list.Total.String() != "230.01" || list.Warning != "" {
+			t.Errorf("unexpected listing %+v", list)
+		}
+	})
+
+	t.Run("should default the quantity to one", func(t *testing.T) {
+		rr := serve(http.MethodPut, "/receipts/1/items/2", `{"description": "Parking", "unitPrice": 32.01}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if store.items[1].Quantity != 1 || store.items[1].UnitPrice.String() != "32.01" {
+			t.Errorf("unexpected update %+v", store.items[1])
+		}
+	})
+
+	t.Run("should reject invalid line items", func(t *testing.T) {
+		for _, body := range []string{`{"unitPrice": 1}`, `{"description": "Tip", "quantity": -1}`, `{"description": "Tip", "taxRate": 150}`} {
+			if rr := serve(http.MethodPost, "/receipts/1/items", body); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, body, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should fail for missing receipts and items", func(t *testing.T) {
+		if rr := serve(http.MethodPost, "/receipts/2/items", `{"description": "Tip"}`); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if rr := serve(http.MethodDelete, "/receipts/1/items/9", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should delete a line item", func(t *testing.T) {
+		if rr := serve(http.MethodDelete, "/receipts/1/items/1", ""); rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if len(store.items) != 1 || store.items[0].ID != 2 {
+			t.Errorf("unexpected line items %+v", store.items)
+		}
+	})
+}
+
+func TestCurrencyConversion(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+	eurUSD, _ := money.ParseRate("1.0565")
+	eurGBP, _ := money.ParseRate("0.8329")
+
+	store := &mockReceiptStore{
+		listed: []types.Receipt{
+			{ID: 1, Amount: money.New(1000, "EUR"), Currency: "EUR", Date: day("2024-11-30")},
+			{ID: 2, Amount: money.New(1000, "GBP"), Currency: "GBP", Date: day("2024-11-29")},
+			{ID: 3, Amount: money.New(500, "USD"), Currency: "USD", Date: day("2024-11-29")},
+			{ID: 4, Amount: money.New(1000, "EUR"), Currency: "EUR", Date: day("2024-10-01")},
+		},
+		totals: []types.ReceiptTotal{
+			{Currency: "EUR", Date: day("2024-10-01"), Total: money.New(1000, "EUR"), Count: 1},
+			{Currency: "EUR", Date: day("2024-11-30"), Total: money.New(1000, "EUR"), Count: 1},
+			{Currency: "GBP", Date: day("2024-11-29"), Total: money.New(1000, "GBP"), Count: 1},
+			{Currency: "USD", Date: day("2024-11-29"), Total: money.New(500, "USD"), Count: 1},
+		},
+	}
+	rates := &mockRateStore{rates: []types.ExchangeRate{
+		{Date: day("2024-11-29"), Base: "EUR", Quote: "USD", Rate: eurUSD},
+		{Date: day("2024-11-29"), Base: "EUR", Quote: "GBP", Rate: eurGBP},
+	}}
+	handler := NewHandler(store, &mockUserStore{}, nil, nil, nil, nil, rates)
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts", handler.handleGetReceipts).Methods(http.MethodGet)
+		router.HandleFunc("/receipts/summary", handler.handleGetReceiptSummary).Methods(http.MethodGet)
+
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should convert listed amounts at the rate of their date", func(t *testing.T) {
+		rr := serve("/receipts")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var page types.ReceiptPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"10.57", "12.68", "5.00", ""}
+		for i, receipt := range page.Receipts {
+			got := ""
+			if receipt.Converted != nil {
+				got = receipt.Converted.Amount.String()
+			}
+			if got != expected[i] {
+				t.Errorf("receipt %d: expected %q USD, got %q", receipt.ID, expected[i], got)
+			}
+		}
+		if c := page.Receipts[0].Converted; c.Currency != "USD" || c.Rate.String() != "1.0565" || !c.RateDate.Equal(day("2024-11-29")) {
+			t.Errorf("unexpected conversion %+v", c)
+		}
+	})
+
+	t.Run("should sum up in the home currency", func(t *testing.T) {
+		rr := serve("/receipts/summary?from=2024-10-01")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		body := rr.Body.String()
+		for _, s := range []string{`"currency":"USD","total":"28.25","count":4,"unconverted":1`, `{"currency":"EUR","total":"20.00","count":2}`} {
+			if !strings.Contains(body, s) {
+				t.Errorf("expected %s in %s", s, body)
+			}
+		}
+		if store.lastFilter.DateFrom == nil {
+			t.Errorf("expected the filters to be passed on, got %+v", store.lastFilter)
+		}
+	})
+}
+
+func TestCategoriesAndTags(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &mockReceiptStore{}
+	handler := NewHandler(store, &mockUserStore{}, blobs, nil, &mockJobStore{}, nil, nil)
+	image := testPNG(t)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/receipts", handler.handleGetReceipts).Methods(http.MethodGet)
+		router.HandleFunc("/receipts/{id}", handler.handleUpdateReceipt).Methods(http.MethodPatch)
+		router.HandleFunc("/categories", handler.handleCreateCategory).Methods(http.MethodPost)
+		router.HandleFunc("/categories/{categoryId:[0-9]+}", handler.handleDeleteCategory).Methods(http.MethodDelete)
+
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should create nested categories", func(t *testing.T) {
+		if rr := serve(http.MethodPost, "/categories", `{"name": "Travel", "colour": "#1E88E5", "icon": "plane"}`); rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		rr := serve(http.MethodPost, "/categories", `{"name": "Lodging", "parentId": 1}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		if !strings.Contains(rr.Body.String(), `"parentId":1`) || store.categories[0].Colour != "#1e88e5" {
+			t.Errorf("unexpected categories %+v: %s", store.categories, rr.Body)
+		}
+	})
+
+	t.Run("should refuse invalid categories", func(t *testing.T) {
+		for _, body := range []string{`{"name": ""}`, `{"name": "Food", "colour": "blue"}`, `{"name": "Food", "parentId": 9}`} {
+			if rr := serve(http.MethodPost, "/categories", body); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, body, rr.Code)
+			}
+		}
+		if rr := serve(http.MethodDelete, "/categories/1", ""); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d for a category with subcategories, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should set the category and tags at upload", func(t *testing.T) {
+		fields := map[string]string{"name": "Hotel", "amount": "120", "date": "2024-03-04", "categoryId": "9", "tags": "Client-X, q1"}
+
+		rr := httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, newUploadRequest(t, fields, "receipt.png", image))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d for another user's category, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		fields["categoryId"] = "2"
+		rr = httptest.NewRecorder()
+		handler.handleCreateReceipt(rr, newUploadRequest(t, fields, "receipt.png", image))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		created := store.created[len(store.created)-1]
+		if created.CategoryID == nil || *created.CategoryID != 2 || strings.Join(created.Tags, ",") != "client-x,q1" {
+			t.Errorf("unexpected receipt %+v", created)
+		}
+	})
+
+	t.Run("should replace the category and tags on update", func(t *testing.T) {
+		category := 2
+		store.receipt = &types.Receipt{ID: 1, Name: "Hotel", Amount: money.New(12000, "USD"), Currency: "USD", CategoryID: &category, Tags: []string{"q1"}}
+
+		rr := serve(http.MethodPatch, "/receipts/1", `{"categoryId": 0, "tags": ["Travel", "travel", "q2"]}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if store.updated.CategoryID != nil || strings.Join(store.updated.Tags, ",") != "travel,q2" {
+			t.Errorf("unexpected update %+v", store.updated)
+		}
+
+		if rr := serve(http.MethodPatch, "/receipts/1", `{"categoryId": 7}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d for an unknown category, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should filter the listing by category and tags", func(t *testing.T) {
+		if rr := serve(http.MethodGet, "/receipts?category=1&tag=Travel&tag=q2", ""); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		f := store.lastFilter
+		if f.CategoryID == nil || *f.CategoryID != 1 || strings.Join(f.Tags, ",") != "travel,q2" {
+			t.Errorf("unexpected filter %+v", f)
+		}
+
+		if rr := serve(http.MethodGet, "/receipts?category=travel", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should limit the tags of a receipt", func(t *testing.T) {
+		tags := make([]string, MaxTags+1)
+		for i := range tags {
+			tags[i] = strconv.Itoa(i)
+		}
+		if _, err := parseTags([]string{strings.Join(tags, ",")}); err == nil {
+			t.Errorf("expected more than %d tags to be refused", MaxTags)
+		}
+		if _, err := parseTags([]string{strings.Repeat("x", maxTagLength+1)}); err == nil {
+			t.Error("expected a long tag to be refused")
+		}
+	})
+}
+
+func TestPurger(t *testing.T) {
+	t.Run("should remove the image with the receipt", func(t *testing.T) {
+		blobs, err := storage.NewLocalStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := blobs.Put(context.Background(), "receipt.jpg", strings.NewReader("image"), 5, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+
+		store := &mockReceiptStore{purgeable: []types.Receipt{{ID: 1, ImageKey: "receipt.jpg"}}}
+		purger := NewPurger(store, blobs, nil, time.Hour, time.Hour)
+
+		n, err := purger.PurgeOnce(context.Background(), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 1 {
+			t.Errorf("expected 1 receipt to be purged, got %d", n)
+		}
+		if _, err := blobs.Stat(context.Background(), "receipt.jpg"); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected image to be removed, got %v", err)
+		}
+	})
+
+	t.Run("should keep an image that was referenced again", func(t *testing.T) {
+		blobs, err := storage.NewLocalStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := blobs.Put(context.Background(), "receipt.jpg", strings.NewReader("image"), 5, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+
+		store := &mockReceiptStore{
+			purgeable: []types.Receipt{{ID: 1, ImageKey: "receipt.jpg"}},
+			created:   []types.Receipt{{ID: 2, ImageKey: "receipt.jpg"}},
+		}
+		purger := NewPurger(store, blobs, nil, time.Hour, time.Hour)
+
+		if _, err := purger.PurgeOnce(context.Background(), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := blobs.Stat(context.Background(), "receipt.jpg"); err != nil {
+			t.Errorf("expected image to be kept: %v", err)
+		}
+	})
+
+	t.Run("should keep the image if the row cannot be deleted", func(t *testing.T) {
+		blobs, err := storage.NewLocalStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := blobs.Put(context.Background(), "receipt.jpg", strings.NewReader("image"), 5, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+
+		store := &mockReceiptStore{purgeable: []types.Receipt{{ID: 1, ImageKey: "receipt.jpg"}}, deleteErr: errors.New("db down")}
+		purger := NewPurger(store, blobs, nil, time.Hour, time.Hour)
+
+		if _, err := purger.PurgeOnce(context.Background(), time.Now()); err == nil {
+			t.Error("expected purge to fail")
+		}
+		if _, err := blobs.Stat(context.Background(), "receipt.jpg"); err != nil {
+			t.Errorf("expected image to be kept: %v", err)
+		}
+	})
+}
+
+func TestListCursor(t *testing.T) {
+	order, err := parseListOrder("-date")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	cursor := encodeCursor(order, types.Receipt{ID: 7, Date: date})
+
+	value, id, err := decodeCursor(order, cursor)
+	if err != nil {
+		t.Fatalf("error decoding cursor: %v", err)
+	}
+	if id != 7 || !value.(time.Time).Equal(date) {
+		t.Errorf("expected (%v, 7), got (%v, %d)", date, value, id)
+	}
+
+	other, _ := parseListOrder("amount")
+	if _, _, err := decodeCursor(other, cursor); err == nil {
+		t.Error("expected cursor to be rejected for a different sort")
+	}
+}
+
+// mockReceiptStore embeds the interface so tests only implement what they use.
+type mockReceiptStore struct {
+	types.ReceiptStore
+	lastFilter types.ReceiptListFilter
+	receipt    *types.Receipt
+	updated    *types.Receipt
+	trashed    int
+	purgeable  []types.Receipt
+	created    []types.Receipt
+	similar    []types.SimilarReceipt
+	statuses   []string
+	deleteErr  error
+	// onCreate runs inside CreateReceipt, standing in for a concurrent request
+	onCreate func(types.Receipt)
+
+	attachments []types.Attachment
+	items       []types.LineItem
+
+	listed []types.Receipt
+	totals []types.ReceiptTotal
+
+	categories []types.Category
+}
+
+// GetAttachment serves m.attachments, or the receipt's image as its only
+// attachment when there are none.
+func (m *mockReceiptStore) GetAttachment(receiptId int, userId int, position int) (*types.Attachment, error) {
+	if m.receipt == nil || m.receipt.ID != receiptId {
+		return nil, ErrReceiptNotFound
+	}
+
+	if m.attachments == nil {
+		if position != 0 {
+			return nil, ErrAttachmentNotFound
+		}
+		return &types.Attachment{
+			ReceiptID:   m.receipt.ID,
+			ImageKey:    m.receipt.ImageKey,
+			ImageDigest: m.receipt.ImageDigest,
+			ContentType: m.receipt.ContentType,
+			Orientation: m.receipt.Orientation,
+			UploadedAt:  m.receipt.CreatedAt,
+		}, nil
+	}
+
+	if position >= len(m.attachments) {
+		return nil, ErrAttachmentNotFound
+	}
+	a := m.attachments[position]
+	return &a, nil
+}
+
+func (m *mockReceiptStore) ListAttachments(receiptId int, userId int) ([]types.Attachment, error) {
+	if m.attachments == nil {
+		primary, err := m.GetAttachment(receiptId, userId, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Attachment{*primary}, nil
+	}
+	return m.attachments, nil
+}
+
+func (m *mockReceiptStore) ListAttachmentsAfter(afterId int, limit int) ([]types.Attachment, error) {
+	var page []types.Attachment
+	for _, a := range m.attachments {
+		if a.ID > afterId && len(page) < limit {
+			page = append(page, a)
+		}
+	}
+	return page, nil
+}
+
+func (m *mockReceiptStore) AddAttachment(a types.Attachment, userId int) (*types.Attachment, error) {
+	if m.receipt == nil || m.receipt.ID != a.ReceiptID {
+		return nil, ErrReceiptNotFound
+	}
+	a.ID = len(m.attachments) + 1
+	a.Position = len(m.attachments)
+	m.attachments = append(m.attachments, a)
+	return &a, nil
+}
+
+func (m *mockReceiptStore) RemoveAttachment(receiptId int, userId int, position int) (string, error) {
+	if position >= len(m.attachments) {
+		return "", ErrAttachmentNotFound
+	}
+	if len(m.attachments) == 1 {
+		return "", ErrLastAttachment
+	}
+
+	key := m.attachments[position].ImageKey
+	m.attachments = append(m.attachments[:position], m.attachments[position+1:]...)
+	for i := range m.attachments {
+		m.attachments[i].Position = i
+	}
+	return key, nil
+}
+
+func (m *mockReceiptStore) ReorderAttachments(receiptId int, userId int, order []int) error {
+	if !isPermutation(order, len(m.attachments)) {
+		return ErrInvalidOrder
+	}
+
+	reordered := make([]types.Attachment, len(order))
+	for i, p := range order {
+		reordered[i] = m.attachments[p]
+		reordered[i].Position = i
+	}
+	m.attachments = reordered
+	return nil
+}
+
+func (m *mockReceiptStore) GetReceiptByID(receiptId int, userId int) (*types.Receipt, error) {
+	if m.receipt == nil || m.receipt.ID != receiptId {
+		return nil, ErrReceiptNotFound
+	}
+	r := *m.receipt
+	return &r, nil
+}
+
+func (m *mockReceiptStore) UpdateReceipt(r types.Receipt) error {
+	m.updated = &r
+	return nil
+}
+
+func (m *mockReceiptStore) DeleteReceipt(receiptId int, userId int) error {
+	m.trashed = receiptId
+	return nil
+}
+
+func (m *mockReceiptStore) ListPurgeableReceipts(deletedBefore time.Time, limit int) ([]types.Receipt, error) {
+	return m.purgeable, nil
+}
+
+func (m *mockReceiptStore) PurgeReceipt(receiptId int) ([]string, error) {
+	if m.deleteErr != nil {
+		return nil, m.deleteErr
+	}
+	for _, r := range m.purgeable {
+		if r.ID == receiptId {
+			return []string{r.ImageKey}, nil
+		}
+	}
+	return nil, ErrReceiptNotFound
+}
+
+// DeleteBlob keeps images that a created receipt or attachment refers to.
+func (m *mockReceiptStore) DeleteBlob(key string, remove func() error) error {
+	for _, r := range m.created {
+		if r.ImageKey == key {
+			return nil
+		}
+	}
+	for _, a := range m.attachments {
+		if a.ImageKey == key {
+			return nil
+		}
+	}
+	return remove()
+}
+
+func (m *mockReceiptStore) GetReceiptByDigest(digest string, userId int) (*types.Receipt, error) {
+	for _, r := range m.created {
+		if r.ImageDigest == digest {
+			return &r, nil
+		}
+	}
+	return nil, ErrReceiptNotFound
+}
+
+func (m *mockReceiptStore) FindSimilarReceipts(q types.SimilarReceiptQuery) ([]types.SimilarReceipt, error) {
+	return m.similar, nil
+}
+
+func (m *mockReceiptStore) CreateReceipt(r types.Receipt) (int, error) {
+	if m.onCreate != nil {
+		m.onCreate(r)
+	}
+	r.ID = len(m.created) + 1
+	m.created = append(m.created, r)
+	return r.ID, nil
+}
+
+func (m *mockReceiptStore) CreateReceipts(receipts []types.Receipt) ([]int, error) {
+	ids := make([]int, len(receipts))
+	for i, r := range receipts {
+		ids[i], _ = m.CreateReceipt(r)
+	}
+	return ids, nil
+}
+
+func (m *mockReceiptStore) SetProcessingStatus(receiptId int, status string) error {
+	m.statuses = append(m.statuses, status)
+	return nil
+}
+
+func (m *mockReceiptStore) SetReceiptPHash(receiptId int, phash uint64) error {
+	m.receipt.PHash = &phash
+	return nil
+}
+
+func (m *mockReceiptStore) ListLineItems(receiptId int, userId int) ([]types.LineItem, error) {
+	items := []types.LineItem{}
+	for _, item := range m.items {
+		if item.ReceiptID == receiptId {
+			priceLineItem(&item)
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *mockReceiptStore) CreateLineItem(item types.LineItem, userId int) (int, error) {
+	if m.receipt == nil || m.receipt.ID != item.ReceiptID {
+		return 0, ErrReceiptNotFound
+	}
+	item.ID = len(m.items) + 1
+	m.items = append(m.items, item)
+	return item.ID, nil
+}
+
+func (m *mockReceiptStore) UpdateLineItem(item types.LineItem, userId int) error {
+	for i := range m.items {
+		if m.items[i].ID == item.ID && m.items[i].ReceiptID == item.ReceiptID {
+			m.items[i] = item
+			return nil
+		}
+	}
+	return ErrLineItemNotFound
+}
+
+func (m *mockReceiptStore) DeleteLineItem(receiptId int, userId int, itemId int) error {
+	for i, item := range m.items {
+		if item.ID == itemId && item.ReceiptID == receiptId {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			return nil
+		}
+	}
+	return ErrLineItemNotFound
+}
+
+func (m *mockReceiptStore) SetReceiptSuggestions(receiptId int, suggestions *types.ReceiptSuggestions) error {
+	m.receipt.Suggestions = suggestions
+	return nil
+}
+
+func (m *mockReceiptStore) ListReceipts(filter types.ReceiptListFilter) (*types.ReceiptPage, error) {
+	m.lastFilter = filter
+	return &types.ReceiptPage{Receipts: append([]types.Receipt{}, m.listed...)}, nil
+}
+
+func (m *mockReceiptStore) SummarizeReceipts(filter types.ReceiptListFilter) ([]types.ReceiptTotal, error) {
+	m.lastFilter = filter
+	return m.totals, nil
+}
+
+func (m *mockReceiptStore) GetCategory(categoryId int, userId int) (*types.Category, error) {
+	for _, c := range m.categories {
+		if c.ID == categoryId {
+			return &c, nil
+		}
+	}
+	return nil, ErrCategoryNotFound
+}
+
+func (m *mockReceiptStore) CreateCategory(category types.Category) (int, error) {
+	if category.ParentID != nil {
+		if _, err := m.GetCategory(*category.ParentID, category.UserID); err != nil {
+			return 0, ErrInvalidParent
+		}
+	}
+	category.ID = len(m.categories) + 1
+	m.categories = append(m.categories, category)
+	return category.ID, nil
+}
+
+func (m *mockReceiptStore) DeleteCategory(categoryId int, userId int) error {
+	for _, c := range m.categories {
+		if c.ParentID != nil && *c.ParentID == categoryId {
+			return ErrCategoryInUse
+		}
+	}
+	return nil
+}
+
+// mockRateStore serves a fixed set of exchange rates.
+type mockRateStore struct {
+	rates []types.ExchangeRate
+}
+
+func (m *mockRateStore) UpsertExchangeRates(rates []types.ExchangeRate) error {
+	return nil
+}
+
+func (m *mockRateStore) ListExchangeRates(currencies []money.Currency, from, to time.Time) ([]types.ExchangeRate, error) {
+	return m.rates, nil
+}
+
+// mockJobStore records the jobs handlers enqueue.
+type mockJobStore struct {
+	types.JobStore
+	enqueued []types.Job
+}
+
+func (m *mockJobStore) EnqueueJob(job types.Job) (int, error) {
+	m.enqueued = append(m.enqueued, job)
+	return len(m.enqueued), nil
+}
+
+type mockUserStore struct{}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
+
+func (m *mockUserStore) CreateUser(u types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	return &types.User{}, nil
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		img.Set(x, x, color.White)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testExifJPEG is a JPEG carrying an orientation, a capture time and the
+// camera maker in its EXIF metadata.
+func testExifJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	be := binary.BigEndian
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = be.AppendUint16(tiff, 3)
+	for _, e := range [][4]uint32{
+		{0x010f, 2, 11, 50}, // Make
+		{0x0112, 3, 1, 6 << 16},
+		{0x0132, 2, 20, 61}, // DateTime
+	} {
+		tiff = be.AppendUint16(tiff, uint16(e[0]))
+		tiff = be.AppendUint16(tiff, uint16(e[1]))
+		tiff = be.AppendUint32(tiff, e[2])
+		tiff = be.AppendUint32(tiff, e[3])
+	}
+	tiff = be.AppendUint32(tiff, 0)
+	tiff = append(tiff, "PhoneMaker\x00"...)
+	tiff = append(tiff, "2024:10:05 14:30:00\x00"...)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:2])
+	out.Write([]byte{0xff, 0xe1})
+	binary.Write(&out, be, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(img.Bytes()[2:])
+	return out.Bytes()
+}
+
+func blobExists(t *testing.T, blobs storage.BlobStore, key string) bool {
+	t.Helper()
+
+	_, err := blobs.Stat(context.Background(), key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func newUploadRequest(t *testing.T, fields map[string]string, filename string, content []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, err := mw.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(content)
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, "/receipts/upload", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
@@ -2,12 +2,32 @@ package receipt
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/groshiniprasad/uploady/money"
 	"github.com/groshiniprasad/uploady/types"
 )
 
+var (
+	ErrReceiptNotFound    = errors.New("receipt not found")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrLastAttachment     = errors.New("a receipt must keep at least one attachment")
+	ErrInvalidOrder       = errors.New("order must list every attachment position exactly once")
+	ErrLineItemNotFound   = errors.New("line item not found")
+	ErrCategoryNotFound   = errors.New("category not found")
+	ErrInvalidParent      = errors.New("the parent must be one of your categories outside of the category itself")
+	ErrCategoryInUse      = errors.New("the category has subcategories, move or delete them first")
+	ErrTagNotFound        = errors.New("tag not found")
+	ErrTagExists          = errors.New("a tag with this name already exists")
+)
+
 type Store struct {
 	db *sql.DB
 }
@@ -21,10 +41,77 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+// CreateReceipt inserts the receipt with its image as the primary
+// attachment and, for content addressed images, takes a reference on the
+// shared blob in the same transaction.
 func (s *Store) CreateReceipt(receipt types.Receipt) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create receipt: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := insertReceipt(tx, receipt)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to create receipt: %w", err)
+	}
+
+	return id, nil
+}
+
+// CreateReceipts inserts the receipts like CreateReceipt, all or none of
+// them, and returns their IDs in order.
+func (s *Store) CreateReceipts(receipts []types.Receipt) ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create receipts: %w", err)
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(receipts))
+	for i, receipt := range receipts {
+		ids[i], err = insertReceipt(tx, receipt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to create receipts: %w", err)
+	}
+
+	return ids, nil
+}
+
+func insertReceipt(tx *sql.Tx, receipt types.Receipt) (int, error) {
+	if err := referenceBlob(tx, receipt.ImageDigest, receipt.ImageKey); err != nil {
+		return 0, err
+	}
+
+	orientation := orientationOrDefault(receipt.Orientation)
+
+	status := receipt.ProcessingStatus
+	if status == "" {
+		status = types.ProcessingDone
+	}
+
+	var suggestions []byte
+	if receipt.Suggestions != nil {
+		data, err := json.Marshal(receipt.Suggestions)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create receipt: %w", err)
+		}
+		suggestions = data
+	}
+
 	// Execute the SQL insert statement
-	res, err := s.db.Exec("INSERT INTO receipts (userId, name, amount, imagePath, date, description) VALUES (?, ?, ?, ?, ?, ?)",
-		receipt.UserID, receipt.Name, receipt.Amount, receipt.ImagePath, receipt.Date, receipt.Description)
+	res, err := tx.Exec("INSERT INTO receipts (userId, name, amount, currency, imageKey, imageDigest, imageSize, contentType, phash, orientation, capturedAt, cameraWidth, cameraHeight, processingStatus, suggestions, date, description, categoryId) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		receipt.UserID, receipt.Name, receipt.Amount, receipt.Currency, receipt.ImageKey, nullString(receipt.ImageDigest), receipt.ImageSize, receipt.ContentType, receipt.PHash,
+		orientation, receipt.CapturedAt, receipt.CameraWidth, receipt.CameraHeight, status, suggestions, receipt.Date, receipt.Description, receipt.CategoryID)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create receipt: %w", err)
 	}
@@ -35,54 +122,1451 @@ func (s *Store) CreateReceipt(receipt types.Receipt) (int, error) {
 		return 0, fmt.Errorf("failed to retrieve last insert ID: %w", err)
 	}
 
+	_, err = tx.Exec("INSERT INTO receipt_attachments (receiptId, position, imageKey, imageDigest, imageSize, contentType, phash, orientation) VALUES (?, 0, ?, ?, ?, ?, ?, ?)",
+		id, receipt.ImageKey, nullString(receipt.ImageDigest), receipt.ImageSize, receipt.ContentType, receipt.PHash, orientation)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create attachment: %w", err)
+	}
+
+	if err := setTags(tx, int(id), receipt.UserID, receipt.Tags); err != nil {
+		return 0, err
+	}
+
 	return int(id), nil
 }
 
 func (s *Store) GetReceiptByID(receiptId int, userId int) (*types.Receipt, error) {
 	// Query the receipts table instead of users
-	query := "SELECT id, userId, name, amount, date, imagePath FROM receipts WHERE id = ? AND userId = ?"
+	query := "SELECT " + receiptColumns + " FROM receipts WHERE id = ? AND userId = ? AND deletedAt IS NULL"
 	row := s.db.QueryRow(query, receiptId, userId)
 	log.Println("Querying the  with query:", row)
 
-	r := new(types.Receipt)
-
-	err := row.Scan(&r.ID, &r.UserID, &r.Name, &r.Amount, &r.Date, &r.ImagePath)
+	r, err := scanRowIntoReceipt(row)
 
 	if err == sql.ErrNoRows {
 		// Handle the case where no rows are returned
 		fmt.Println("No receipt found for the given ID and UserID")
-		return nil, fmt.Errorf("receipt not found")
+		return nil, ErrReceiptNotFound
 	} else if err != nil {
 		fmt.Println("Error scanning row:", err)
 		return nil, err
 	}
 
+	receipts := []types.Receipt{*r}
+	if err := loadTags(s.db, receipts); err != nil {
+		return nil, err
+	}
+
+	return &receipts[0], nil
+}
+
+// GetReceiptByDigest returns the user's most recent receipt whose image has
+// the given SHA-256 digest.
+func (s *Store) GetReceiptByDigest(digest string, userId int) (*types.Receipt, error) {
+	row := s.db.QueryRow("SELECT "+receiptColumns+" FROM receipts WHERE imageDigest = ? AND userId = ? AND deletedAt IS NULL ORDER BY id DESC LIMIT 1", digest, userId)
+
+	r, err := scanRowIntoReceipt(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrReceiptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	return r, nil
 }
 
-func (s *Store) GetReceiptsByName(receipt types.Receipt) ([]types.Receipt, error) {
-	rows, err := s.db.Query("SELECT * FROM receipts WHERE userId = ? AND name LIKE ?", receipt.UserID, receipt.Name+"%")
+// FindSimilarReceipts returns the closest matches first. The Hamming distance
+// is computed by MySQL with BIT_COUNT over the XOR of the hashes.
+func (s *Store) FindSimilarReceipts(q types.SimilarReceiptQuery) ([]types.SimilarReceipt, error) {
+	where := []string{"userId = ?", "deletedAt IS NULL", "phash IS NOT NULL", "id <> ?"}
+	args := []any{q.PHash, q.UserID, q.ExcludeID}
+
+	if q.Amount != nil {
+		where = append(where, "amount = ?", "currency = ?")
+		args = append(args, *q.Amount, q.Currency)
+	}
+	if q.Date != nil {
+		where = append(where, "date = ?")
+		args = append(args, *q.Date)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, q.MaxDistance, limit)
+
+	query := fmt.Sprintf("SELECT %s, BIT_COUNT(phash ^ ?) AS distance FROM receipts WHERE %s HAVING distance <= ? ORDER BY distance, id DESC LIMIT ?",
+		receiptColumns, strings.Join(where, " AND "))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find similar receipts: %w", err)
+	}
+	defer rows.Close()
+
+	similar := []types.SimilarReceipt{}
+	for rows.Next() {
+		var sr types.SimilarReceipt
+		r, err := scanRowIntoReceipt(rows, &sr.Distance)
+		if err != nil {
+			return nil, err
+		}
+		sr.Receipt = *r
+		similar = append(similar, sr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return similar, nil
+}
+
+// UpdateReceipt overwrites the editable metadata of a receipt owned by
+// receipt.UserID, its category and its tags. The image key is never changed
+// here.
+func (s *Store) UpdateReceipt(receipt types.Receipt) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update receipt: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockReceipt(tx, receipt.ID, receipt.UserID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE receipts SET name = ?, amount = ?, currency = ?, date = ?, description = ?, categoryId = ? WHERE id = ?",
+		receipt.Name, receipt.Amount, receipt.Currency, receipt.Date, receipt.Description, receipt.CategoryID, receipt.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update receipt: %w", err)
+	}
+
+	if err := setTags(tx, receipt.ID, receipt.UserID, receipt.Tags); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update receipt: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteReceipt moves a receipt to the trash. The row and its image are kept
+// until the purger removes them or the receipt is restored.
+func (s *Store) DeleteReceipt(receiptId int, userId int) error {
+	res, err := s.db.Exec("UPDATE receipts SET deletedAt = CURRENT_TIMESTAMP WHERE id = ? AND userId = ? AND deletedAt IS NULL", receiptId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete receipt: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete receipt: %w", err)
+	}
+	if n == 0 {
+		return ErrReceiptNotFound
+	}
+
+	return nil
+}
+
+// ListTrashedReceipts returns the user's trashed receipts, most recently
+// deleted first.
+func (s *Store) ListTrashedReceipts(userId int) ([]types.Receipt, error) {
+	rows, err := s.db.Query("SELECT "+receiptColumns+" FROM receipts WHERE userId = ? AND deletedAt IS NOT NULL ORDER BY deletedAt DESC, id DESC", userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trashed receipts: %w", err)
+	}
+	defer rows.Close()
+
+	receipts, err := scanRowsIntoReceipts(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return receipts, loadTags(s.db, receipts)
+}
+
+func (s *Store) RestoreReceipt(receiptId int, userId int) error {
+	res, err := s.db.Exec("UPDATE receipts SET deletedAt = NULL WHERE id = ? AND userId = ? AND deletedAt IS NOT NULL", receiptId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to restore receipt: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to restore receipt: %w", err)
+	}
+	if n == 0 {
+		return ErrReceiptNotFound
+	}
+
+	return nil
+}
+
+// ListPurgeableReceipts returns up to limit receipts of any user that were
+// trashed before deletedBefore.
+func (s *Store) ListPurgeableReceipts(deletedBefore time.Time, limit int) ([]types.Receipt, error) {
+	rows, err := s.db.Query("SELECT "+receiptColumns+" FROM receipts WHERE deletedAt IS NOT NULL AND deletedAt < ? ORDER BY deletedAt LIMIT ?", deletedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list purgeable receipts: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRowsIntoReceipts(rows)
+}
+
+// PurgeReceipt permanently deletes a trashed receipt with its attachments
+// and releases their blobs.
+func (s *Store) PurgeReceipt(receiptId int) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge receipt: %w", err)
+	}
+	defer tx.Rollback()
+
+	var imageKey string
+	var digest sql.NullString
+	err = tx.QueryRow("SELECT imageKey, imageDigest FROM receipts WHERE id = ? AND deletedAt IS NOT NULL FOR UPDATE", receiptId).Scan(&imageKey, &digest)
+	if err == sql.ErrNoRows {
+		return nil, ErrReceiptNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge receipt: %w", err)
+	}
+
+	images, err := attachmentImages(tx, receiptId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge receipt: %w", err)
+	}
+	if len(images) == 0 {
+		images = []blobRef{{key: imageKey, digest: digest}}
+	}
+
+	if _, err := tx.Exec("DELETE FROM receipt_attachments WHERE receiptId = ?", receiptId); err != nil {
+		return nil, fmt.Errorf("failed to purge receipt: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM receipts WHERE id = ?", receiptId); err != nil {
+		return nil, fmt.Errorf("failed to purge receipt: %w", err)
+	}
+
+	orphaned := []string{}
+	for _, image := range images {
+		key, err := releaseBlob(tx, image)
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			orphaned = append(orphaned, key)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to purge receipt: %w", err)
+	}
+
+	return orphaned, nil
+}
+
+// ListReceipts returns one page of the user's receipts using keyset
+// pagination on the requested sort column, with the receipt ID as tiebreaker.
+func (s *Store) ListReceipts(filter types.ReceiptListFilter) (*types.ReceiptPage, error) {
+	order, err := parseListOrder(filter.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	where, args := filterConditions(filter)
+
+	op, dir := ">", "ASC"
+	if order.desc {
+		op, dir = "<", "DESC"
+	}
+
+	if filter.Cursor != "" {
+		value, id, err := decodeCursor(order, filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", order.column, op))
+		args = append(args, value, value, id)
+	}
+
+	// Fetch one extra row to find out whether another page follows.
+	query := fmt.Sprintf("SELECT %s FROM receipts WHERE %s ORDER BY %s %s, id %s LIMIT ?",
+		receiptColumns, strings.Join(where, " AND "), order.column, dir, dir)
+	args = append(args, limit+1)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list receipts: %w", err)
+	}
+	defer rows.Close()
+
+	receipts, err := scanRowsIntoReceipts(rows)
 	if err != nil {
 		return nil, err
 	}
+	if err := loadTags(s.db, receipts); err != nil {
+		return nil, err
+	}
+
+	page := &types.ReceiptPage{Receipts: receipts}
+
+	if len(page.Receipts) > limit {
+		page.Receipts = page.Receipts[:limit]
+		page.NextCursor = encodeCursor(order, page.Receipts[limit-1])
+	}
+
+	return page, nil
+}
+
+// SummarizeReceipts sums up the live receipts of the filter by currency and
+// day, leaving out its cursor, limit and order.
+func (s *Store) SummarizeReceipts(filter types.ReceiptListFilter) ([]types.ReceiptTotal, error) {
+	where, args := filterConditions(filter)
+
+	query := fmt.Sprintf("SELECT currency, DATE(date), SUM(amount), COUNT(*) FROM receipts WHERE %s GROUP BY currency, DATE(date) ORDER BY currency, DATE(date)",
+		strings.Join(where, " AND "))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize receipts: %w", err)
+	}
+	defer rows.Close()
+
+	totals := []types.ReceiptTotal{}
+	for rows.Next() {
+		var total types.ReceiptTotal
+		var sum string
+		if err := rows.Scan(&total.Currency, &total.Date, &sum, &total.Count); err != nil {
+			return nil, fmt.Errorf("failed to summarize receipts: %w", err)
+		}
+		if total.Total, err = money.Parse(sum, total.Currency); err != nil {
+			return nil, fmt.Errorf("failed to summarize receipts: %w", err)
+		}
+		totals = append(totals, total)
+	}
+
+	return totals, rows.Err()
+}
+
+// filterConditions returns the WHERE conditions of the filter's range and
+// search, with their arguments.
+func filterConditions(filter types.ReceiptListFilter) ([]string, []any) {
+	where := []string{"userId = ?", "deletedAt IS NULL"}
+	args := []any{filter.UserID}
+
+	if filter.DateFrom != nil {
+		where = append(where, "date >= ?")
+		args = append(args, *filter.DateFrom)
+	}
+	if filter.DateTo != nil {
+		where = append(where, "date < ?")
+		args = append(args, *filter.DateTo)
+	}
+	if filter.MinAmount != nil {
+		where = append(where, "amount >= ?")
+		args = append(args, *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		where = append(where, "amount <= ?")
+		args = append(args, *filter.MaxAmount)
+	}
+	if filter.Currency != "" {
+		where = append(where, "currency = ?")
+		args = append(args, filter.Currency)
+	}
+	if filter.CategoryID != nil {
+		where = append(where, "categoryId IN ("+categoryTree+")")
+		args = append(args, *filter.CategoryID, filter.UserID)
+	}
+	if len(filter.Tags) > 0 {
+		where = append(where, fmt.Sprintf(`id IN (SELECT rt.receiptId FROM receipt_tags rt JOIN tags t ON t.id = rt.tagId
+			WHERE t.userId = ? AND t.name IN (%s) GROUP BY rt.receiptId HAVING COUNT(*) = ?)`, placeholders(len(filter.Tags))))
+		args = append(args, filter.UserID)
+		for _, tag := range filter.Tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(filter.Tags))
+	}
+	if filter.NamePrefix != "" {
+		where = append(where, "name LIKE ?")
+		args = append(args, escapeLike(filter.NamePrefix)+"%")
+	}
+
+	return where, args
+}
+
+// ListAttachments returns the attachments of the user's receipt in order.
+func (s *Store) ListAttachments(receiptId int, userId int) ([]types.Attachment, error) {
+	rows, err := s.db.Query("SELECT "+attachmentColumns+" FROM receipt_attachments WHERE receiptId = ? AND "+ownedReceipt+" ORDER BY position",
+		receiptId, receiptId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
 	defer rows.Close()
 
-	// Initialize a slice to hold the results
-	var receipts []types.Receipt
+	attachments := []types.Attachment{}
+	for rows.Next() {
+		a, err := scanRowIntoAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Every receipt has a primary attachment
+	if len(attachments) == 0 {
+		return nil, ErrReceiptNotFound
+	}
+
+	return attachments, nil
+}
+
+func (s *Store) ListAttachmentsAfter(afterId int, limit int) ([]types.Attachment, error) {
+	rows, err := s.db.Query("SELECT "+attachmentColumns+" FROM receipt_attachments WHERE id > ? ORDER BY id LIMIT ?", afterId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	defer rows.Close()
 
-	// Iterate over the result rows and collect the receipts
+	attachments := []types.Attachment{}
 	for rows.Next() {
-		var foundReceipt types.Receipt
-		if err := rows.Scan(&foundReceipt.ID, &foundReceipt.UserID, &foundReceipt.Name); err != nil {
+		a, err := scanRowIntoAttachment(rows)
+		if err != nil {
 			return nil, err
 		}
-		receipts = append(receipts, foundReceipt)
+		attachments = append(attachments, *a)
 	}
 
-	// If no receipts are found, return an appropriate error
-	if len(receipts) == 0 {
-		return nil, fmt.Errorf("no receipts found with name: %s", receipt.Name)
+	return attachments, rows.Err()
+}
+
+func (s *Store) GetAttachment(receiptId int, userId int, position int) (*types.Attachment, error) {
+	row := s.db.QueryRow("SELECT "+attachmentColumns+" FROM receipt_attachments WHERE receiptId = ? AND position = ? AND "+ownedReceipt,
+		receiptId, position, receiptId, userId)
+
+	a, err := scanRowIntoAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return receipts, nil
+	return a, nil
+}
+
+func (s *Store) AddAttachment(attachment types.Attachment, userId int) (*types.Attachment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to add attachment: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockReceipt(tx, attachment.ReceiptID, userId); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow("SELECT COALESCE(MAX(position) + 1, 0) FROM receipt_attachments WHERE receiptId = ?", attachment.ReceiptID).Scan(&attachment.Position)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add attachment: %w", err)
+	}
+
+	if err := referenceBlob(tx, attachment.ImageDigest, attachment.ImageKey); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec("INSERT INTO receipt_attachments (receiptId, position, imageKey, imageDigest, imageSize, contentType, phash, orientation) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		attachment.ReceiptID, attachment.Position, attachment.ImageKey, nullString(attachment.ImageDigest), attachment.ImageSize, attachment.ContentType, attachment.PHash, orientationOrDefault(attachment.Orientation))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add attachment: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve last insert ID: %w", err)
+	}
+	attachment.ID = int(id)
+	attachment.UploadedAt = time.Now()
+
+	if attachment.Position == 0 {
+		if err := syncPrimary(tx, attachment.ReceiptID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to add attachment: %w", err)
+	}
+
+	return &attachment, nil
+}
+
+// ReplaceAttachment swaps the image of the attachment at
+// attachment.Position, keeping its place.
+func (s *Store) ReplaceAttachment(attachment types.Attachment, userId int) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to replace attachment: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockReceipt(tx, attachment.ReceiptID, userId); err != nil {
+		return "", err
+	}
+
+	previous, err := lockAttachment(tx, attachment.ReceiptID, attachment.Position)
+	if err != nil {
+		return "", err
+	}
+
+	// Reference the new blob before releasing the old one, they may be the same
+	if err := referenceBlob(tx, attachment.ImageDigest, attachment.ImageKey); err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec("UPDATE receipt_attachments SET imageKey = ?, imageDigest = ?, imageSize = ?, contentType = ?, phash = ?, orientation = ?, uploadedAt = CURRENT_TIMESTAMP WHERE receiptId = ? AND position = ?",
+		attachment.ImageKey, nullString(attachment.ImageDigest), attachment.ImageSize, attachment.ContentType, attachment.PHash, orientationOrDefault(attachment.Orientation),
+		attachment.ReceiptID, attachment.Position)
+	if err != nil {
+		return "", fmt.Errorf("failed to replace attachment: %w", err)
+	}
+
+	orphaned, err := releaseBlob(tx, previous)
+	if err != nil {
+		return "", err
+	}
+
+	if attachment.Position == 0 {
+		if err := syncPrimary(tx, attachment.ReceiptID); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to replace attachment: %w", err)
+	}
+
+	return orphaned, nil
+}
+
+// RemoveAttachment deletes an attachment and closes the gap it leaves. The
+// next attachment becomes the primary one when the primary is removed.
+func (s *Store) RemoveAttachment(receiptId int, userId int, position int) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to remove attachment: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockReceipt(tx, receiptId, userId); err != nil {
+		return "", err
+	}
+
+	previous, err := lockAttachment(tx, receiptId, position)
+	if err != nil {
+		return "", err
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM receipt_attachments WHERE receiptId = ?", receiptId).Scan(&count); err != nil {
+		return "", fmt.Errorf("failed to remove attachment: %w", err)
+	}
+	if count <= 1 {
+		return "", ErrLastAttachment
+	}
+
+	if _, err := tx.Exec("DELETE FROM receipt_attachments WHERE receiptId = ? AND position = ?", receiptId, position); err != nil {
+		return "", fmt.Errorf("failed to remove attachment: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE receipt_attachments SET position = position - 1 WHERE receiptId = ? AND position > ?", receiptId, position); err != nil {
+		return "", fmt.Errorf("failed to remove attachment: %w", err)
+	}
+
+	orphaned, err := releaseBlob(tx, previous)
+	if err != nil {
+		return "", err
+	}
+
+	if position == 0 {
+		if err := syncPrimary(tx, receiptId); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to remove attachment: %w", err)
+	}
+
+	return orphaned, nil
+}
+
+func (s *Store) ReorderAttachments(receiptId int, userId int, order []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to reorder attachments: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockReceipt(tx, receiptId, userId); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id FROM receipt_attachments WHERE receiptId = ? ORDER BY position FOR UPDATE", receiptId)
+	if err != nil {
+		return fmt.Errorf("failed to reorder attachments: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to reorder attachments: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to reorder attachments: %w", err)
+	}
+
+	if !isPermutation(order, len(ids)) {
+		return ErrInvalidOrder
+	}
+
+	for position, previous := range order {
+		if _, err := tx.Exec("UPDATE receipt_attachments SET position = ? WHERE id = ?", position, ids[previous]); err != nil {
+			return fmt.Errorf("failed to reorder attachments: %w", err)
+		}
+	}
+
+	if err := syncPrimary(tx, receiptId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to reorder attachments: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) ListLineItems(receiptId int, userId int) ([]types.LineItem, error) {
+	rows, err := s.db.Query(`SELECT `+lineItemColumns+` FROM receipt_line_items i JOIN receipts r ON r.id = i.receiptId
+		WHERE i.receiptId = ? AND r.userId = ? AND r.deletedAt IS NULL ORDER BY i.id`,
+		receiptId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list line items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []types.LineItem{}
+	for rows.Next() {
+		item, err := scanRowIntoLineItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+
+	return items, rows.Err()
+}
+
+func (s *Store) CreateLineItem(item types.LineItem, userId int) (int, error) {
+	res, err := s.db.Exec(`INSERT INTO receipt_line_items (receiptId, description, quantity, unitPrice, taxRate, category)
+		SELECT id, ?, ?, ?, ?, ? FROM receipts WHERE id = ? AND userId = ? AND deletedAt IS NULL`,
+		item.Description, item.Quantity, item.UnitPrice, item.TaxRate, item.Category, item.ReceiptID, userId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create line item: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create line item: %w", err)
+	}
+	if n == 0 {
+		return 0, ErrReceiptNotFound
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve last insert ID: %w", err)
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateLineItem(item types.LineItem, userId int) error {
+	res, err := s.db.Exec("UPDATE receipt_line_items SET description = ?, quantity = ?, unitPrice = ?, taxRate = ?, category = ? WHERE id = ? AND receiptId = ? AND "+ownedReceipt,
+		item.Description, item.Quantity, item.UnitPrice, item.TaxRate, item.Category, item.ID, item.ReceiptID, item.ReceiptID, userId)
+	if err != nil {
+		return fmt.Errorf("failed to update line item: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update line item: %w", err)
+	}
+	if n == 0 {
+		// MySQL counts only changed rows, so an item saved with the same
+		// values looks like a missing one until it is looked up
+		var id int
+		err := s.db.QueryRow("SELECT id FROM receipt_line_items WHERE id = ? AND receiptId = ? AND "+ownedReceipt,
+			item.ID, item.ReceiptID, item.ReceiptID, userId).Scan(&id)
+		if err == sql.ErrNoRows {
+			return ErrLineItemNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update line item: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteLineItem(receiptId int, userId int, itemId int) error {
+	res, err := s.db.Exec("DELETE FROM receipt_line_items WHERE id = ? AND receiptId = ? AND "+ownedReceipt,
+		itemId, receiptId, receiptId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete line item: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete line item: %w", err)
+	}
+	if n == 0 {
+		return ErrLineItemNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) ListCategories(userId int) ([]types.Category, error) {
+	rows, err := s.db.Query("SELECT "+categoryColumns+" FROM categories WHERE userId = ? ORDER BY name, id", userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []types.Category{}
+	for rows.Next() {
+		category, err := scanRowIntoCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, *category)
+	}
+
+	return categories, rows.Err()
+}
+
+func (s *Store) GetCategory(categoryId int, userId int) (*types.Category, error) {
+	row := s.db.QueryRow("SELECT "+categoryColumns+" FROM categories WHERE id = ? AND userId = ?", categoryId, userId)
+
+	category, err := scanRowIntoCategory(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return category, nil
+}
+
+func (s *Store) CreateCategory(category types.Category) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create category: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkParent(tx, category); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec("INSERT INTO categories (userId, parentId, name, colour, icon) VALUES (?, ?, ?, ?, ?)",
+		category.UserID, category.ParentID, category.Name, category.Colour, category.Icon)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create category: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve last insert ID: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to create category: %w", err)
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateCategory(category types.Category) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update category: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("SELECT id FROM categories WHERE id = ? AND userId = ? FOR UPDATE", category.ID, category.UserID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrCategoryNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update category: %w", err)
+	}
+
+	if err := checkParent(tx, category); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE categories SET parentId = ?, name = ?, colour = ?, icon = ? WHERE id = ?",
+		category.ParentID, category.Name, category.Colour, category.Icon, category.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update category: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update category: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteCategory(categoryId int, userId int) error {
+	var children int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM categories WHERE parentId = ? AND userId = ?", categoryId, userId).Scan(&children)
+	if err != nil {
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+	if children > 0 {
+		return ErrCategoryInUse
+	}
+
+	// Its receipts are left without a category by the foreign key
+	res, err := s.db.Exec("DELETE FROM categories WHERE id = ? AND userId = ?", categoryId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+	if n == 0 {
+		return ErrCategoryNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) ListTags(userId int) ([]types.Tag, error) {
+	rows, err := s.db.Query(`SELECT t.id, t.name, COUNT(r.id) FROM tags t
+		LEFT JOIN receipt_tags rt ON rt.tagId = t.id
+		LEFT JOIN receipts r ON r.id = rt.receiptId AND r.deletedAt IS NULL
+		WHERE t.userId = ? GROUP BY t.id, t.name ORDER BY t.name`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []types.Tag{}
+	for rows.Next() {
+		var tag types.Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Receipts); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (s *Store) RenameTag(tagId int, userId int, name string) error {
+	res, err := s.db.Exec("UPDATE tags SET name = ? WHERE id = ? AND userId = ?", name, tagId, userId)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return ErrTagExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename tag: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to rename tag: %w", err)
+	}
+	if n == 0 {
+		// Nothing changed or the tag is gone, see UpdateLineItem
+		var id int
+		err := s.db.QueryRow("SELECT id FROM tags WHERE id = ? AND userId = ?", tagId, userId).Scan(&id)
+		if err == sql.ErrNoRows {
+			return ErrTagNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to rename tag: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteTag(tagId int, userId int) error {
+	res, err := s.db.Exec("DELETE FROM tags WHERE id = ? AND userId = ?", tagId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete tag: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete tag: %w", err)
+	}
+	if n == 0 {
+		return ErrTagNotFound
+	}
+
+	return nil
+}
+
+// ownedReceipt restricts attachment and line item queries to a live receipt
+// of the user.
+// It takes the receipt ID and user ID as arguments.
+const ownedReceipt = "receiptId IN (SELECT id FROM receipts WHERE id = ? AND userId = ? AND deletedAt IS NULL)"
+
+func (s *Store) SetProcessingStatus(receiptId int, status string) error {
+	_, err := s.db.Exec("UPDATE receipts SET processingStatus = ? WHERE id = ?", status, receiptId)
+	if err != nil {
+		return fmt.Errorf("failed to update processing status: %w", err)
+	}
+
+	return nil
+}
+
+// SetReceiptPHash stores the hash on the primary attachment, as long as it
+// still holds the receipt's image, and mirrors it onto the receipt.
+func (s *Store) SetReceiptPHash(receiptId int, phash uint64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update phash: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE receipt_attachments a JOIN receipts r ON r.id = a.receiptId AND r.imageKey = a.imageKey
+		SET a.phash = ?
+		WHERE a.receiptId = ? AND a.position = 0`, phash, receiptId)
+	if err != nil {
+		return fmt.Errorf("failed to update phash: %w", err)
+	}
+
+	if err := syncPrimary(tx, receiptId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update phash: %w", err)
+	}
+
+	return nil
+}
+
+// SetReceiptSuggestions stores the values read from the receipt's image.
+func (s *Store) SetReceiptSuggestions(receiptId int, suggestions *types.ReceiptSuggestions) error {
+	data, err := json.Marshal(suggestions)
+	if err != nil {
+		return fmt.Errorf("failed to update suggestions: %w", err)
+	}
+
+	_, err = s.db.Exec("UPDATE receipts SET suggestions = ? WHERE id = ?", data, receiptId)
+	if err != nil {
+		return fmt.Errorf("failed to update suggestions: %w", err)
+	}
+
+	return nil
+}
+
+// lockReceipt locks a live receipt of the user for the rest of the
+// transaction, serialising changes to its attachments.
+func lockReceipt(tx *sql.Tx, receiptId int, userId int) error {
+	var id int
+	err := tx.QueryRow("SELECT id FROM receipts WHERE id = ? AND userId = ? AND deletedAt IS NULL FOR UPDATE", receiptId, userId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrReceiptNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock receipt: %w", err)
+	}
+	return nil
+}
+
+// checkParent returns ErrInvalidParent unless the category's parent is a
+// category of the same user that is not the category or nested under it.
+func checkParent(tx *sql.Tx, category types.Category) error {
+	if category.ParentID == nil {
+		return nil
+	}
+
+	rows, err := tx.Query(`WITH RECURSIVE ancestors AS (
+			SELECT id, parentId FROM categories WHERE id = ? AND userId = ?
+			UNION ALL
+			SELECT c.id, c.parentId FROM categories c JOIN ancestors a ON c.id = a.parentId
+		) SELECT id FROM ancestors`, *category.ParentID, category.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to check parent category: %w", err)
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to check parent category: %w", err)
+		}
+		if id == category.ID {
+			return ErrInvalidParent
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check parent category: %w", err)
+	}
+	if !found {
+		return ErrInvalidParent
+	}
+
+	return nil
+}
+
+// setTags replaces the tags of the receipt, creating the user's tags that
+// do not exist yet.
+func setTags(tx *sql.Tx, receiptId int, userId int, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM receipt_tags WHERE receiptId = ?", receiptId); err != nil {
+		return fmt.Errorf("failed to set tags: %w", err)
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+
+	args := []any{}
+	for _, tag := range tags {
+		args = append(args, userId, tag)
+	}
+	values := strings.TrimSuffix(strings.Repeat("(?, ?), ", len(tags)), ", ")
+	if _, err := tx.Exec("INSERT INTO tags (userId, name) VALUES "+values+" ON DUPLICATE KEY UPDATE id = id", args...); err != nil {
+		return fmt.Errorf("failed to set tags: %w", err)
+	}
+
+	args = []any{receiptId, userId}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	_, err := tx.Exec("INSERT INTO receipt_tags (receiptId, tagId) SELECT ?, id FROM tags WHERE userId = ? AND name IN ("+placeholders(len(tags))+")", args...)
+	if err != nil {
+		return fmt.Errorf("failed to set tags: %w", err)
+	}
+
+	return nil
+}
+
+// loadTags sets the tags of the receipts, in alphabetical order.
+func loadTags(db *sql.DB, receipts []types.Receipt) error {
+	if len(receipts) == 0 {
+		return nil
+	}
+
+	index := map[int]int{}
+	args := make([]any, len(receipts))
+	for i, receipt := range receipts {
+		index[receipt.ID] = i
+		args[i] = receipt.ID
+	}
+
+	rows, err := db.Query("SELECT rt.receiptId, t.name FROM receipt_tags rt JOIN tags t ON t.id = rt.tagId WHERE rt.receiptId IN ("+placeholders(len(receipts))+") ORDER BY t.name", args...)
+	if err != nil {
+		return fmt.Errorf("failed to load tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var receiptId int
+		var name string
+		if err := rows.Scan(&receiptId, &name); err != nil {
+			return fmt.Errorf("failed to load tags: %w", err)
+		}
+		i := index[receiptId]
+		receipts[i].Tags = append(receipts[i].Tags, name)
+	}
+
+	return rows.Err()
+}
+
+func lockAttachment(tx *sql.Tx, receiptId int, position int) (blobRef, error) {
+	var image blobRef
+	err := tx.QueryRow("SELECT imageKey, imageDigest FROM receipt_attachments WHERE receiptId = ? AND position = ? FOR UPDATE", receiptId, position).
+		Scan(&image.key, &image.digest)
+	if err == sql.ErrNoRows {
+		return image, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return image, fmt.Errorf("failed to lock attachment: %w", err)
+	}
+	return image, nil
+}
+
+func attachmentImages(tx *sql.Tx, receiptId int) ([]blobRef, error) {
+	rows, err := tx.Query("SELECT imageKey, imageDigest FROM receipt_attachments WHERE receiptId = ? FOR UPDATE", receiptId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []blobRef
+	for rows.Next() {
+		var image blobRef
+		if err := rows.Scan(&image.key, &image.digest); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	return images, rows.Err()
+}
+
+// syncPrimary copies the image of the attachment at position 0 onto the
+// receipt, where duplicate and similarity detection look for it. The
+// suggestions read from a previous primary image are dropped, so that
+// processing reads them again.
+func syncPrimary(tx *sql.Tx, receiptId int) error {
+	_, err := tx.Exec(`UPDATE receipts r JOIN receipt_attachments a ON a.receiptId = r.id AND a.position = 0
+		SET r.suggestions = NULL
+		WHERE r.id = ? AND NOT (r.imageKey <=> a.imageKey)`, receiptId)
+	if err != nil {
+		return fmt.Errorf("failed to update primary image: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE receipts r JOIN receipt_attachments a ON a.receiptId = r.id AND a.position = 0
+		SET r.imageKey = a.imageKey, r.imageDigest = a.imageDigest, r.imageSize = a.imageSize,
+			r.contentType = a.contentType, r.phash = a.phash, r.orientation = a.orientation
+		WHERE r.id = ?`, receiptId)
+	if err != nil {
+		return fmt.Errorf("failed to update primary image: %w", err)
+	}
+	return nil
+}
+
+// isPermutation reports whether order holds each of 0 to n-1 exactly once.
+func isPermutation(order []int, n int) bool {
+	if len(order) != n {
+		return false
+	}
+
+	seen := make([]bool, n)
+	for _, p := range order {
+		if p < 0 || p >= n || seen[p] {
+			return false
+		}
+		seen[p] = true
+	}
+	return true
+}
+
+func orientationOrDefault(orientation int) int {
+	if orientation == 0 {
+		return 1
+	}
+	return orientation
+}
+
+const attachmentColumns = "id, receiptId, position, imageKey, imageDigest, imageSize, contentType, phash, orientation, uploadedAt"
+
+func scanRowIntoAttachment(row rowScanner) (*types.Attachment, error) {
+	a := new(types.Attachment)
+	var digest sql.NullString
+	var phash sql.Null[uint64]
+
+	err := row.Scan(&a.ID, &a.ReceiptID, &a.Position, &a.ImageKey, &digest, &a.ImageSize, &a.ContentType, &phash, &a.Orientation, &a.UploadedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.ImageDigest = digest.String
+	if phash.Valid {
+		a.PHash = &phash.V
+	}
+
+	return a, nil
+}
+
+// lineItemColumns selects line items as i along with the currency of their
+// receipt as r, which their prices are in.
+const lineItemColumns = "i.id, i.receiptId, i.description, i.quantity, i.unitPrice, i.taxRate, i.category, i.createdAt, r.currency"
+
+func scanRowIntoLineItem(row rowScanner) (*types.LineItem, error) {
+	item := new(types.LineItem)
+	var unitPrice, currency string
+
+	err := row.Scan(&item.ID, &item.ReceiptID, &item.Description, &item.Quantity, &unitPrice, &item.TaxRate, &item.Category, &item.CreatedAt, &currency)
+	if err != nil {
+		return nil, err
+	}
+	item.UnitPrice, err = money.Parse(unitPrice, money.Currency(currency))
+	if err != nil {
+		return nil, fmt.Errorf("invalid unit price of line item %d: %w", item.ID, err)
+	}
+	priceLineItem(item)
+
+	return item, nil
+}
+
+const categoryColumns = "id, userId, parentId, name, colour, icon, createdAt"
+
+func scanRowIntoCategory(row rowScanner) (*types.Category, error) {
+	category := new(types.Category)
+	var parentID sql.Null[int]
+
+	err := row.Scan(&category.ID, &category.UserID, &parentID, &category.Name, &category.Colour, &category.Icon, &category.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		category.ParentID = &parentID.V
+	}
+
+	return category, nil
+}
+
+// categoryTree selects the IDs of a category of the user and of all the
+// categories nested under it. It takes the category ID and user ID as
+// arguments.
+const categoryTree = `WITH RECURSIVE tree AS (
+		SELECT id FROM categories WHERE id = ? AND userId = ?
+		UNION ALL
+		SELECT c.id FROM categories c JOIN tree ON c.parentId = tree.id
+	) SELECT id FROM tree`
+
+// mysqlDuplicateEntry is the MySQL error number of unique key violations.
+const mysqlDuplicateEntry = 1062
+
+const receiptColumns = "id, userId, name, amount, currency, date, description, categoryId, imageKey, imageDigest, imageSize, contentType, phash, orientation, capturedAt, cameraWidth, cameraHeight, processingStatus, suggestions, createdAt, deletedAt"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRowIntoReceipt scans the receiptColumns, followed by any extra
+// selected columns into extra.
+func scanRowIntoReceipt(row rowScanner, extra ...any) (*types.Receipt, error) {
+	r := new(types.Receipt)
+	var amount, currency string
+	var description sql.NullString
+	var categoryID sql.Null[int]
+	var digest sql.NullString
+	var phash sql.Null[uint64]
+	var capturedAt sql.NullTime
+	var suggestions []byte
+	var deletedAt sql.NullTime
+
+	dest := []any{
+		&r.ID,
+		&r.UserID,
+		&r.Name,
+		&amount,
+		&currency,
+		&r.Date,
+		&description,
+		&categoryID,
+		&r.ImageKey,
+		&digest,
+		&r.ImageSize,
+		&r.ContentType,
+		&phash,
+		&r.Orientation,
+		&capturedAt,
+		&r.CameraWidth,
+		&r.CameraHeight,
+		&r.ProcessingStatus,
+		&suggestions,
+		&r.CreatedAt,
+		&deletedAt,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	r.Currency = money.Currency(currency)
+	r.Amount, err = money.Parse(amount, r.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount of receipt %d: %w", r.ID, err)
+	}
+	r.Description = description.String
+	if categoryID.Valid {
+		r.CategoryID = &categoryID.V
+	}
+	r.ImageDigest = digest.String
+	if phash.Valid {
+		r.PHash = &phash.V
+	}
+	if capturedAt.Valid {
+		r.CapturedAt = &capturedAt.Time
+	}
+	if len(suggestions) > 0 {
+		if err := json.Unmarshal(suggestions, &r.Suggestions); err != nil {
+			return nil, fmt.Errorf("invalid suggestions: %w", err)
+		}
+	}
+	if deletedAt.Valid {
+		r.DeletedAt = &deletedAt.Time
+	}
+
+	return r, nil
+}
+
+func scanRowsIntoReceipts(rows *sql.Rows) ([]types.Receipt, error) {
+	receipts := []types.Receipt{}
+	for rows.Next() {
+		r, err := scanRowIntoReceipt(rows)
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+// blobRef is an image as stored on a receipt or attachment row. Images
+// uploaded before content addressing have no digest.
+type blobRef struct {
+	key    string
+	digest sql.NullString
+}
+
+// referenceBlob takes a reference on a content addressed blob, registering
+// it on first use.
+func referenceBlob(tx *sql.Tx, digest, key string) error {
+	if digest == "" {
+		return nil
+	}
+
+	_, err := tx.Exec("INSERT INTO blobs (digest, objectKey, refCount) VALUES (?, ?, 1) ON DUPLICATE KEY UPDATE refCount = refCount + 1", digest, key)
+	if err != nil {
+		return fmt.Errorf("failed to reference blob: %w", err)
+	}
+	return nil
+}
+
+// releaseBlob drops a reference on the image's blob. It returns the image
+// key once nothing refers to it any more, and "" while it is still shared.
+func releaseBlob(tx *sql.Tx, image blobRef) (string, error) {
+	// Images uploaded before content addressing are not shared
+	if !image.digest.Valid {
+		return image.key, nil
+	}
+
+	var refCount int
+	err := tx.QueryRow("SELECT refCount FROM blobs WHERE digest = ? FOR UPDATE", image.digest.String).Scan(&refCount)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to release blob: %w", err)
+	}
+
+	orphaned := image.key
+	if refCount > 1 {
+		orphaned = ""
+		_, err = tx.Exec("UPDATE blobs SET refCount = refCount - 1 WHERE digest = ?", image.digest.String)
+	} else {
+		_, err = tx.Exec("DELETE FROM blobs WHERE digest = ?", image.digest.String)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to release blob: %w", err)
+	}
+
+	return orphaned, nil
+}
+
+// DeleteBlob removes an orphaned object while holding its blob row, which a
+// new reference has to wait for. An uploader that found the object still
+// there stores it again once its reference is committed, see keepBlob.
+func (s *Store) DeleteBlob(key string, remove func() error) error {
+	digest := blobDigest(key)
+	if digest == "" {
+		// Images uploaded before content addressing are not shared
+		return remove()
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Objects discarded before anything referenced them have no row yet
+	_, err = tx.Exec("INSERT INTO blobs (digest, objectKey, refCount) VALUES (?, ?, 0) ON DUPLICATE KEY UPDATE refCount = refCount", digest, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	var refCount int
+	err = tx.QueryRow("SELECT refCount FROM blobs WHERE digest = ? FOR UPDATE", digest).Scan(&refCount)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	if refCount > 0 {
+		return tx.Commit()
+	}
+
+	if err := remove(); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM blobs WHERE digest = ?", digest); err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// blobDigest is the digest of a content addressed key such as
+// "sha256/ab/ab12...ef.jpg", or "" for older keys.
+func blobDigest(key string) string {
+	if !strings.HasPrefix(key, "sha256/") {
+		return ""
+	}
+	base := path.Base(key)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// placeholders returns n comma-separated placeholders for an IN list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied prefix.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
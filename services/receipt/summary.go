@@ -0,0 +1,130 @@
+package receipt
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/money"
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/services/rate"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+// handleGetReceiptSummary sums up the receipts matching the listing filters
+// per currency and, converted at the rate of each receipt's date, in the
+// user's home currency.
+func (h *Handler) handleGetReceiptSummary(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	filter, err := parseListFilter(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.UserID = userID
+
+	totals, err := h.store.SummarizeReceipts(filter)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	home, err := h.homeCurrency(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	dates := make([]types.Receipt, len(totals))
+	for i, total := range totals {
+		dates[i] = types.Receipt{Currency: total.Currency, Date: total.Date}
+	}
+	table, err := h.loadRates(home, dates)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	summary := types.ReceiptSummary{Currency: home, Total: money.New(0, home), ByCurrency: []types.CurrencyTotal{}}
+	for _, total := range totals {
+		summary.Count += total.Count
+
+		if n := len(summary.ByCurrency); n > 0 && summary.ByCurrency[n-1].Currency == total.Currency {
+			summary.ByCurrency[n-1].Total = summary.ByCurrency[n-1].Total.Add(total.Total)
+			summary.ByCurrency[n-1].Count += total.Count
+		} else {
+			summary.ByCurrency = append(summary.ByCurrency, types.CurrencyTotal{Currency: total.Currency, Total: total.Total, Count: total.Count})
+		}
+
+		converted := table.Convert(total.Total, total.Currency, home, total.Date)
+		if converted == nil {
+			summary.Unconverted += total.Count
+			continue
+		}
+		summary.Total = summary.Total.Add(converted.Amount)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, summary)
+}
+
+// convertReceipts sets the amounts of the receipts in the user's home
+// currency, for those with a rate on their date.
+func (h *Handler) convertReceipts(userID int, receipts []types.Receipt) error {
+	if h.rates == nil || len(receipts) == 0 {
+		return nil
+	}
+
+	home, err := h.homeCurrency(userID)
+	if err != nil {
+		return err
+	}
+
+	table, err := h.loadRates(home, receipts)
+	if err != nil {
+		return err
+	}
+
+	for i := range receipts {
+		receipts[i].Converted = table.Convert(receipts[i].Amount, receipts[i].Currency, home, receipts[i].Date)
+	}
+
+	return nil
+}
+
+// homeCurrency returns the currency the user's amounts are converted into.
+func (h *Handler) homeCurrency(userID int) (money.Currency, error) {
+	u, err := h.userStore.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if u.HomeCurrency == "" {
+		return configs.Envs.DefaultCurrency, nil
+	}
+	return u.HomeCurrency, nil
+}
+
+// loadRates reads the rates to convert the receipts into the currency on
+// their dates. Without rates set up, only amounts already in the currency
+// are converted.
+func (h *Handler) loadRates(to money.Currency, receipts []types.Receipt) (*rate.Table, error) {
+	if h.rates == nil || len(receipts) == 0 {
+		return rate.NewTable(nil, rate.MaxAge()), nil
+	}
+
+	currencies := []money.Currency{to}
+	from, until := receipts[0].Date, receipts[0].Date
+	for _, receipt := range receipts {
+		currencies = append(currencies, receipt.Currency)
+		if receipt.Date.Before(from) {
+			from = receipt.Date
+		}
+		if receipt.Date.After(until) {
+			until = receipt.Date
+		}
+	}
+	slices.Sort(currencies)
+
+	return rate.Load(h.rates, slices.Compact(currencies), from, until, rate.MaxAge())
+}
@@ -0,0 +1,443 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/groshiniprasad/uploady/imaging"
+	"github.com/groshiniprasad/uploady/storage"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+var (
+	errInvalidImage = errors.New("file is not a valid image, please upload a valid image file")
+	errEmptyFile    = errors.New("the uploaded file is empty")
+	errSavingFile   = errors.New("error saving file")
+
+	errAmountRequired    = errors.New("an amount is required")
+	errAmountNotPositive = errors.New("amount must be positive")
+	errInvalidDate       = errors.New("invalid date format")
+	errDateRequired      = errors.New("a date is required when the photo has no capture date")
+	errInvalidCategory   = errors.New("invalid category, use the ID of one of your categories")
+)
+
+// spooledUpload is an uploaded file copied to a temporary file while its
+// SHA-256 digest is computed, so that it can be stored under that digest.
+type spooledUpload struct {
+	file        *os.File
+	size        int64
+	digest      string
+	contentType string
+	// meta is the EXIF metadata read by stripMetadata, never nil after it
+	meta *imaging.Metadata
+}
+
+// openUpload spools an uploaded file and strips its metadata. On failure it
+// also returns the HTTP status to report the error with.
+func openUpload(r io.Reader) (*spooledUpload, int, error) {
+	upload, err := spoolUpload(r)
+	if err != nil {
+		return nil, uploadErrorStatus(err), err
+	}
+
+	// Drop location and device details before anything is stored
+	if err := upload.stripMetadata(); err != nil {
+		upload.Close()
+		return nil, http.StatusBadRequest, errInvalidImage
+	}
+
+	if err := upload.checkDimensions(); err != nil {
+		upload.Close()
+		return nil, uploadErrorStatus(err), err
+	}
+
+	return upload, 0, nil
+}
+
+// spoolUpload copies r to a temporary file in a single pass, hashing it,
+// sniffing its type from the leading bytes and enforcing utils.MaxFileSize.
+// Unsupported types are refused before the rest of the file is read.
+func spoolUpload(r io.Reader) (*spooledUpload, error) {
+	src := &sourceReader{r: r}
+
+	head := make([]byte, 3072)
+	n, err := io.ReadFull(src, head)
+	if err == io.EOF {
+		return nil, errEmptyFile
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	contentType, err := utils.SniffUploadType(head)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "uploady-*")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSavingFile, err)
+	}
+
+	hasher := sha256.New()
+	body := io.LimitReader(io.MultiReader(bytes.NewReader(head), src), utils.MaxFileSize+1)
+
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), body)
+	switch {
+	case src.err != nil:
+		err = src.err
+	case err != nil:
+		err = fmt.Errorf("%w: %v", errSavingFile, err)
+	case size > utils.MaxFileSize:
+		err = utils.ErrFileTooLarge
+	default:
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return &spooledUpload{
+		file:        tmp,
+		size:        size,
+		digest:      hex.EncodeToString(hasher.Sum(nil)),
+		contentType: contentType,
+	}, nil
+}
+
+// stripMetadata removes the EXIF, XMP and IPTC metadata of image uploads,
+// which may hold the GPS position and device details, keeping the fields
+// worth storing in u.meta. The orientation survives in the stripped file.
+// The digest and size are updated to match the stripped file.
+func (u *spooledUpload) stripMetadata() error {
+	u.meta = &imaging.Metadata{Orientation: 1}
+	switch u.contentType {
+	case "image/jpeg":
+		return u.stripJPEG()
+	case "image/png", "image/webp", "image/tiff", "image/heic", "image/heif":
+	default:
+		return nil
+	}
+
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// The upload is already limited to utils.MaxFileSize
+	data, err := io.ReadAll(u.file)
+	if err != nil {
+		return err
+	}
+	data, meta, err := imaging.StripMetadata(data, u.contentType)
+	if err != nil {
+		return err
+	}
+
+	u.meta = meta
+	return u.rewrite(func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (u *spooledUpload) stripJPEG() error {
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	meta, err := imaging.ReadExif(u.file)
+	if err == nil {
+		u.meta = meta
+	} else if !errors.Is(err, imaging.ErrNoExif) {
+		// Unreadable metadata is dropped along with the rest
+		log.Printf("failed to read exif metadata: %v", err)
+	}
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return u.rewrite(func(w io.Writer) error {
+		return imaging.StripJPEGMetadata(w, u.file, u.meta.Orientation)
+	})
+}
+
+// rewrite replaces the spooled file with what write produces, hashing it.
+func (u *spooledUpload) rewrite(write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp("", "uploady-*")
+	if err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	err = write(io.MultiWriter(tmp, hasher))
+	var size int64
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	u.Close()
+	u.file = tmp
+	u.size = size
+	u.digest = hex.EncodeToString(hasher.Sum(nil))
+	return nil
+}
+
+// checkDimensions reads the dimensions from the image header and refuses
+// images too large to be decoded, so that they are never stored. Files
+// without a preview pass unchecked.
+func (u *spooledUpload) checkDimensions() error {
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	config, err := imaging.DecodeConfig(u.file, u.contentType)
+	switch {
+	case errors.Is(err, imaging.ErrNoPreview):
+	case err != nil:
+		return errInvalidImage
+	default:
+		if err := imageLimits().Check(config.Width, config.Height); err != nil {
+			return err
+		}
+	}
+
+	_, err = u.file.Seek(0, io.SeekStart)
+	return err
+}
+
+// decode decodes the spooled image, turned upright, and rewinds the file for
+// the next reader.
+func (u *spooledUpload) decode(ctx context.Context) (image.Image, error) {
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	img, err := decodeImage(ctx, u.file, u.contentType)
+	if err != nil {
+		return nil, err
+	}
+	if u.meta != nil {
+		img = imaging.Orient(img, u.meta.Orientation)
+	}
+
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// fingerprint returns the perceptual hash of the upload, or nil for files
+// without a preview, such as HEIC photos.
+func (u *spooledUpload) fingerprint(ctx context.Context) (*uint64, error) {
+	img, err := u.decode(ctx)
+	if errors.Is(err, imaging.ErrNoPreview) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errInvalidImage
+	}
+
+	phash := imaging.DHash(img)
+	return &phash, nil
+}
+
+// Close removes the temporary file.
+func (u *spooledUpload) Close() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// key is the content addressed object key, e.g. "sha256/ab/ab12...ef.jpg".
+func (u *spooledUpload) key() string {
+	return "sha256/" + u.digest[:2] + "/" + u.digest + extensionForType(u.contentType)
+}
+
+// storeBlob uploads the spooled file unless an identical object is already
+// stored. It reports whether it wrote the object.
+func storeBlob(ctx context.Context, blobs storage.BlobStore, u *spooledUpload) (bool, error) {
+	key := u.key()
+
+	_, err := blobs.Stat(ctx, key)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if err := blobs.Put(ctx, key, u.file, u.size, u.contentType); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func extensionForType(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/tiff":
+		return ".tiff"
+	case "image/heic":
+		return ".heic"
+	case "image/heif":
+		return ".heif"
+	case "application/pdf":
+		return ".pdf"
+	default:
+		return ""
+	}
+}
+
+// sourceReader remembers the error of the request body, to tell a failing or
+// oversized client apart from a failing disk.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// uploadErrorStatus maps the errors of reading an upload onto HTTP status
+// codes.
+func uploadErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr), errors.Is(err, utils.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, utils.ErrUnsupportedFileType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, imaging.ErrTooLarge):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errSavingFile):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+const (
+	// maxUploadBodySize bounds a whole upload request: the file plus room
+	// for the form fields and multipart framing
+	maxUploadBodySize = utils.MaxFileSize + 1<<20
+	// maxFormFieldSize bounds each form field other than the file
+	maxFormFieldSize = 64 << 10
+)
+
+// uploadForm is a multipart upload read by readUploadForm.
+type uploadForm struct {
+	fields url.Values
+	upload *spooledUpload
+}
+
+// readUploadForm streams a multipart request part by part. The file part
+// named fileField goes straight into the spool pipeline instead of being
+// buffered in memory first, and the body as a whole is capped so that
+// oversized requests fail early with 413. The form must be closed.
+func readUploadForm(w http.ResponseWriter, r *http.Request, fileField string) (*uploadForm, int, error) {
+	form := &uploadForm{}
+
+	fields, status, err := readForm(w, r, maxUploadBodySize, fileField, func(part io.Reader) (int, error) {
+		upload, status, err := openUpload(part)
+		if err != nil {
+			return status, err
+		}
+		form.upload = upload
+		return 0, nil
+	})
+	if err != nil {
+		form.Close()
+		return nil, status, err
+	}
+	form.fields = fields
+
+	return form, 0, nil
+}
+
+// readForm reads a multipart request of at most limit bytes, handing the
+// first file part named fileField to readFile as it arrives and collecting
+// the other fields. readFile returns the status to report its error with.
+func readForm(w http.ResponseWriter, r *http.Request, limit int64, fileField string, readFile func(io.Reader) (int, error)) (url.Values, int, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to parse form: %v", err)
+	}
+
+	fields := url.Values{}
+	hasFile := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, uploadErrorStatus(err), fmt.Errorf("failed to parse form: %w", err)
+		}
+
+		if part.FormName() == fileField && part.FileName() != "" && !hasFile {
+			if status, err := readFile(part); err != nil {
+				return nil, status, err
+			}
+			hasFile = true
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+		if err != nil {
+			return nil, uploadErrorStatus(err), fmt.Errorf("failed to parse form: %w", err)
+		}
+		if len(value) > maxFormFieldSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("form field %s is too large", part.FormName())
+		}
+		fields.Add(part.FormName(), string(value))
+	}
+
+	if !hasFile {
+		return nil, http.StatusBadRequest, fmt.Errorf("missing %s file", fileField)
+	}
+
+	return fields, 0, nil
+}
+
+// Close removes the spooled file.
+func (f *uploadForm) Close() error {
+	if f.upload == nil {
+		return nil
+	}
+	return f.upload.Close()
+}
@@ -0,0 +1,88 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// expireBatchSize caps how many uploads a single expiry pass looks at.
+const expireBatchSize = 100
+
+// Expirer removes resumable uploads that were started longer than the
+// maximum age ago and never finalized, together with their files.
+type Expirer struct {
+	handler  *Handler
+	maxAge   time.Duration
+	interval time.Duration
+}
+
+func NewExpirer(handler *Handler, maxAge, interval time.Duration) *Expirer {
+	return &Expirer{handler: handler, maxAge: maxAge, interval: interval}
+}
+
+// Run expires once immediately and then on every interval until ctx is done.
+func (e *Expirer) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := e.ExpireOnce(time.Now())
+		if err != nil {
+			log.Printf("failed to expire abandoned uploads: %v", err)
+		} else if n > 0 {
+			log.Printf("Expired %d abandoned uploads", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireOnce removes the uploads created before now minus the maximum age
+// and returns how many were removed. Uploads a request is writing at the
+// moment are left for the next pass.
+func (e *Expirer) ExpireOnce(now time.Time) (int, error) {
+	h := e.handler
+
+	expired := 0
+	for {
+		uploads, err := h.store.ListExpiredUploads(now.Add(-e.maxAge), expireBatchSize)
+		if err != nil {
+			return expired, err
+		}
+
+		removed := 0
+		for _, upload := range uploads {
+			unlock, err := h.lock(upload.ID)
+			if err != nil {
+				continue
+			}
+
+			// The row goes first, an orphaned file is merely wasted space
+			err = h.store.DeleteUpload(upload.ID, upload.UserID)
+			switch {
+			case err == nil:
+				h.discard(upload.ID)
+				expired++
+				removed++
+			case errors.Is(err, ErrUploadNotFound):
+				// Finalized or terminated since it was listed
+			default:
+				unlock()
+				return expired, fmt.Errorf("upload %s: %w", upload.ID, err)
+			}
+			unlock()
+		}
+
+		// Stop when the batch was the last one, or only held busy uploads
+		if len(uploads) < expireBatchSize || removed == 0 {
+			return expired, nil
+		}
+	}
+}
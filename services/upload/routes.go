@@ -0,0 +1,436 @@
+// Package upload implements resumable uploads with the tus 1.0 protocol
+// (https://tus.io/protocols/resumable-upload), core plus the creation and
+// termination extensions. A completed upload is finalized into a receipt.
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/services/auth"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+
+	// uploadIDSize is the number of random bytes in an upload ID
+	uploadIDSize = 16
+)
+
+var (
+	errUploadBusy       = errors.New("the upload is being written by another request")
+	errOffsetMismatch   = errors.New("Upload-Offset does not match the offset of the upload")
+	errUploadIncomplete = errors.New("the upload is not complete yet")
+)
+
+// ReceiptCreator turns a completed upload into a receipt. It is implemented
+// by the receipt handler, so that finalized uploads are validated exactly
+// like form uploads.
+type ReceiptCreator interface {
+	CreateReceiptFromUpload(w http.ResponseWriter, r *http.Request, fields url.Values, file io.Reader)
+}
+
+type Handler struct {
+	store     types.UploadStore
+	userStore types.UserStore
+	receipts  ReceiptCreator
+	// dir holds the bytes received so far of every upload, one file each
+	dir string
+
+	// locks keeps a *sync.Mutex per upload ID, so that two requests never
+	// write the same upload at once
+	locks sync.Map
+}
+
+func NewHandler(store types.UploadStore, userStore types.UserStore, receipts ReceiptCreator, dir string) *Handler {
+	return &Handler{store: store, userStore: userStore, receipts: receipts, dir: dir}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// Clients discover the server's capabilities without authenticating
+	router.HandleFunc("/uploads", h.handleOptions).Methods(http.MethodOptions)
+	router.HandleFunc("/uploads/{id}", h.handleOptions).Methods(http.MethodOptions)
+
+	router.HandleFunc("/uploads", auth.WithJWTAuth(tusResumable(h.handleCreateUpload), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/uploads/{id}", auth.WithJWTAuth(tusResumable(h.handleGetOffset), h.userStore)).Methods(http.MethodHead)
+	router.HandleFunc("/uploads/{id}", auth.WithJWTAuth(tusResumable(h.handleWriteChunk), h.userStore)).Methods(http.MethodPatch)
+	router.HandleFunc("/uploads/{id}", auth.WithJWTAuth(tusResumable(h.handleDeleteUpload), h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/uploads/{id}/finalize", auth.WithJWTAuth(h.handleFinalizeUpload, h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(utils.MaxFileSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreateUpload starts an upload of Upload-Length bytes. The receipt's
+// fields travel in Upload-Metadata, e.g. "name THVuY2g=,amount MTI=", and are
+// applied when the upload is finalized.
+func (h *Handler) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid Upload-Length"))
+		return
+	}
+	if length > utils.MaxFileSize {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, utils.ErrFileTooLarge)
+		return
+	}
+
+	metadata := r.Header.Get("Upload-Metadata")
+	if _, err := parseMetadata(metadata); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := os.MkdirAll(h.dir, 0o755); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error saving file: %v", err))
+		return
+	}
+	file, err := os.OpenFile(h.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error saving file: %v", err))
+		return
+	}
+	file.Close()
+
+	err = h.store.CreateUpload(types.Upload{
+		ID:       id,
+		UserID:   userID,
+		Length:   length,
+		Metadata: metadata,
+	})
+	if err != nil {
+		os.Remove(h.path(id))
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Location", path.Join(r.URL.Path, id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleGetOffset tells a client where to resume the upload.
+func (h *Handler) handleGetOffset(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	upload, err := h.store.GetUpload(mux.Vars(r)["id"], userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleWriteChunk appends the request body to the upload at Upload-Offset.
+// Whatever arrives is kept, so an interrupted request can be resumed from
+// the last byte written.
+func (h *Handler) handleWriteChunk(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		utils.WriteError(w, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be application/offset+octet-stream"))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid Upload-Offset"))
+		return
+	}
+
+	unlock, err := h.lock(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer unlock()
+
+	upload, err := h.store.GetUpload(id, userID)
+	if err != nil {
+		h.forget(id, err)
+		writeStoreError(w, err)
+		return
+	}
+	if offset != upload.Offset {
+		utils.WriteError(w, http.StatusConflict, errOffsetMismatch)
+		return
+	}
+
+	remaining := upload.Length - upload.Offset
+	if r.ContentLength > remaining {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the chunk exceeds Upload-Length"))
+		return
+	}
+
+	file, err := os.OpenFile(h.path(id), os.O_WRONLY, 0)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error saving file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	// Drop anything written past the recorded offset by a request that
+	// failed before recording it
+	if err := file.Truncate(upload.Offset); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error saving file: %v", err))
+		return
+	}
+	if _, err := file.Seek(upload.Offset, io.SeekStart); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error saving file: %v", err))
+		return
+	}
+
+	n, copyErr := io.Copy(file, io.LimitReader(r.Body, remaining))
+	if err := file.Sync(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error saving file: %v", err))
+		return
+	}
+
+	// Record the progress even when the body was cut short
+	upload.Offset += n
+	if err := h.store.UpdateUploadOffset(id, upload.Offset); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if copyErr != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("failed to read chunk: %v", copyErr))
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	unlock, err := h.lock(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer unlock()
+
+	if err := h.store.DeleteUpload(id, userID); err != nil {
+		h.forget(id, err)
+		writeStoreError(w, err)
+		return
+	}
+	h.discard(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFinalizeUpload creates a receipt from a completed upload and its
+// metadata, then discards the upload. A rejected upload is kept, so that the
+// client may retry, e.g. with force=true or a date.
+func (h *Handler) handleFinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	unlock, err := h.lock(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer unlock()
+
+	upload, err := h.store.GetUpload(id, userID)
+	if err != nil {
+		h.forget(id, err)
+		writeStoreError(w, err)
+		return
+	}
+	if upload.Offset < upload.Length {
+		utils.WriteError(w, http.StatusConflict, errUploadIncomplete)
+		return
+	}
+
+	fields, err := parseMetadata(upload.Metadata)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Fields in the finalize request take precedence, e.g. a missing date
+	if err := r.ParseForm(); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	for key, values := range r.Form {
+		fields[key] = values
+	}
+
+	file, err := os.Open(h.path(id))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer file.Close()
+
+	sw := &statusWriter{ResponseWriter: w}
+	h.receipts.CreateReceiptFromUpload(sw, r, fields, io.LimitReader(file, upload.Length))
+	if sw.status >= http.StatusBadRequest {
+		return
+	}
+
+	if err := h.store.DeleteUpload(id, userID); err == nil {
+		h.discard(id)
+	}
+}
+
+// discard removes the file and lock of a deleted upload.
+func (h *Handler) discard(id string) {
+	os.Remove(h.path(id))
+	h.locks.Delete(id)
+}
+
+// lock claims the upload for the request, failing instead of waiting when
+// another request holds it. IDs that could not have been handed out are
+// refused before a lock is made for them.
+func (h *Handler) lock(id string) (func(), error) {
+	if !validUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+
+	mu, _ := h.locks.LoadOrStore(id, new(sync.Mutex))
+	if !mu.(*sync.Mutex).TryLock() {
+		return nil, errUploadBusy
+	}
+	return mu.(*sync.Mutex).Unlock, nil
+}
+
+// forget drops the lock of an ID that turned out not to be an upload of the
+// user, so that made up IDs leave nothing behind.
+func (h *Handler) forget(id string, err error) {
+	if errors.Is(err, ErrUploadNotFound) {
+		h.locks.Delete(id)
+	}
+}
+
+// path is where the bytes of the upload are kept. IDs are checked, so that
+// a crafted ID cannot point outside of the upload directory.
+func (h *Handler) path(id string) string {
+	if !validUploadID(id) {
+		id = "invalid"
+	}
+	return filepath.Join(h.dir, id)
+}
+
+// validUploadID reports whether id has the form of the IDs of newUploadID.
+func validUploadID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == uploadIDSize
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, uploadIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create upload id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseMetadata decodes an Upload-Metadata header: comma separated pairs of
+// a key and an optional base64 encoded value.
+func parseMetadata(header string) (url.Values, error) {
+	fields := url.Values{}
+	if strings.TrimSpace(header) == "" {
+		return fields, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("invalid Upload-Metadata")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %s", key)
+		}
+		fields.Set(key, string(value))
+	}
+
+	return fields, nil
+}
+
+// tusResumable answers requests of other protocol versions with 412 and
+// marks every response with the version spoken.
+func tusResumable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			utils.WriteError(w, http.StatusPreconditionFailed, fmt.Errorf("unsupported tus version %q", r.Header.Get("Tus-Resumable")))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUploadNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, errUploadBusy) {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
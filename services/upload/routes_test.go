@@ -0,0 +1,399 @@
+package upload
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/groshiniprasad/uploady/types"
+	"github.com/groshiniprasad/uploady/utils"
+)
+
+func TestUploadServiceHandlers(t *testing.T) {
+	t.Run("should advertise the supported extensions", func(t *testing.T) {
+		handler := NewHandler(newMockUploadStore(), &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+
+		rr := serve(handler, http.MethodOptions, "/uploads", nil, nil)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if got := rr.Header().Get("Tus-Extension"); got != "creation,termination" {
+			t.Errorf("expected creation and termination, got %q", got)
+		}
+		if got := rr.Header().Get("Tus-Max-Size"); got != strconv.Itoa(utils.MaxFileSize) {
+			t.Errorf("unexpected max size %q", got)
+		}
+	})
+
+	t.Run("should fail without a supported protocol version", func(t *testing.T) {
+		handler := NewHandler(newMockUploadStore(), &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+
+		rr := serve(handler, http.MethodPost, "/uploads", map[string]string{"Upload-Length": "10"}, nil)
+
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected status code %d, got %d", http.StatusPreconditionFailed, rr.Code)
+		}
+	})
+
+	t.Run("should fail if the upload is too large", func(t *testing.T) {
+		handler := NewHandler(newMockUploadStore(), &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+
+		rr := serve(handler, http.MethodPost, "/uploads", tusHeaders("Upload-Length", strconv.Itoa(utils.MaxFileSize+1)), nil)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status code %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+		}
+	})
+
+	t.Run("should resume an interrupted upload", func(t *testing.T) {
+		store := newMockUploadStore()
+		handler := NewHandler(store, &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+		location := createUpload(t, handler, 10, "")
+
+		// The first chunk is cut short by a dropped connection
+		rr := serve(handler, http.MethodPatch, location, tusHeaders(
+			"Content-Type", "application/offset+octet-stream",
+			"Upload-Offset", "0",
+		), io.MultiReader(strings.NewReader("hello"), errReader{}))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		rr = serve(handler, http.MethodHead, location, tusHeaders(), nil)
+		if got := rr.Header().Get("Upload-Offset"); got != "5" {
+			t.Fatalf("expected the received bytes to be kept, got offset %q", got)
+		}
+
+		rr = serve(handler, http.MethodPatch, location, tusHeaders(
+			"Content-Type", "application/offset+octet-stream",
+			"Upload-Offset", "0",
+		), strings.NewReader("hello"))
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d for a stale offset, got %d", http.StatusConflict, rr.Code)
+		}
+
+		rr = serve(handler, http.MethodPatch, location, tusHeaders(
+			"Content-Type", "application/offset+octet-stream",
+			"Upload-Offset", "5",
+		), strings.NewReader("world"))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+		if got := rr.Header().Get("Upload-Offset"); got != "10" {
+			t.Errorf("expected offset 10, got %q", got)
+		}
+	})
+
+	t.Run("should fail if the content type is not an offset stream", func(t *testing.T) {
+		handler := NewHandler(newMockUploadStore(), &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+		location := createUpload(t, handler, 5, "")
+
+		rr := serve(handler, http.MethodPatch, location, tusHeaders(
+			"Content-Type", "image/png",
+			"Upload-Offset", "0",
+		), strings.NewReader("hello"))
+
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("expected status code %d, got %d", http.StatusUnsupportedMediaType, rr.Code)
+		}
+	})
+
+	t.Run("should terminate an upload", func(t *testing.T) {
+		store := newMockUploadStore()
+		handler := NewHandler(store, &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+		location := createUpload(t, handler, 5, "")
+
+		rr := serve(handler, http.MethodDelete, location, tusHeaders(), nil)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		rr = serve(handler, http.MethodHead, location, tusHeaders(), nil)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should not keep locks for unknown uploads", func(t *testing.T) {
+		handler := NewHandler(newMockUploadStore(), &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+
+		for _, id := range []string{"0123456789abcdef0123456789abcdef", "not-an-upload"} {
+			rr := serve(handler, http.MethodPatch, "/uploads/"+id, tusHeaders(
+				"Content-Type", "application/offset+octet-stream",
+				"Upload-Offset", "0",
+			), strings.NewReader("hello"))
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusNotFound, id, rr.Code)
+			}
+		}
+
+		handler.locks.Range(func(id, _ any) bool {
+			t.Errorf("expected no lock, found one for %s", id)
+			return true
+		})
+	})
+}
+
+func TestExpirer(t *testing.T) {
+	t.Run("should remove abandoned uploads with their files", func(t *testing.T) {
+		store := newMockUploadStore()
+		handler := NewHandler(store, &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+		id := strings.TrimPrefix(createUpload(t, handler, 5, ""), "/uploads/")
+
+		expirer := NewExpirer(handler, time.Hour, time.Hour)
+
+		n, err := expirer.ExpireOnce(time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 || len(store.uploads) != 1 {
+			t.Fatalf("expected a recent upload to be kept, expired %d", n)
+		}
+
+		n, err = expirer.ExpireOnce(time.Now().Add(2 * time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 || len(store.uploads) != 0 {
+			t.Errorf("expected the upload to be expired, expired %d", n)
+		}
+		if _, err := os.Stat(handler.path(id)); !os.IsNotExist(err) {
+			t.Errorf("expected the file to be removed, got %v", err)
+		}
+	})
+
+	t.Run("should leave uploads that are being written", func(t *testing.T) {
+		store := newMockUploadStore()
+		handler := NewHandler(store, &mockUserStore{}, &mockReceiptCreator{}, t.TempDir())
+		id := strings.TrimPrefix(createUpload(t, handler, 5, ""), "/uploads/")
+
+		unlock, err := handler.lock(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unlock()
+
+		n, err := NewExpirer(handler, time.Hour, time.Hour).ExpireOnce(time.Now().Add(2 * time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 || len(store.uploads) != 1 {
+			t.Errorf("expected the busy upload to be kept, expired %d", n)
+		}
+	})
+}
+
+func TestFinalizeUpload(t *testing.T) {
+	metadata := "name " + base64.StdEncoding.EncodeToString([]byte("Lunch")) +
+		",amount " + base64.StdEncoding.EncodeToString([]byte("12.50")) + ",force"
+
+	t.Run("should fail if the upload is incomplete", func(t *testing.T) {
+		receipts := &mockReceiptCreator{}
+		handler := NewHandler(newMockUploadStore(), &mockUserStore{}, receipts, t.TempDir())
+		location := createUpload(t, handler, 5, metadata)
+
+		rr := serve(handler, http.MethodPost, location+"/finalize", nil, nil)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if receipts.calls != 0 {
+			t.Errorf("expected no receipt to be created")
+		}
+	})
+
+	t.Run("should create a receipt from the upload", func(t *testing.T) {
+		store := newMockUploadStore()
+		receipts := &mockReceiptCreator{}
+		handler := NewHandler(store, &mockUserStore{}, receipts, t.TempDir())
+		location := createUpload(t, handler, 5, metadata)
+
+		rr := serve(handler, http.MethodPatch, location, tusHeaders(
+			"Content-Type", "application/offset+octet-stream",
+			"Upload-Offset", "0",
+		), strings.NewReader("hello"))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		rr = serve(handler, http.MethodPost, location+"/finalize", map[string]string{
+			"Content-Type": "application/x-www-form-urlencoded",
+		}, strings.NewReader("date=2024-11-16"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+
+		if receipts.body != "hello" {
+			t.Errorf("expected the uploaded bytes, got %q", receipts.body)
+		}
+		want := url.Values{"name": {"Lunch"}, "amount": {"12.50"}, "force": {""}, "date": {"2024-11-16"}}
+		for key := range want {
+			if receipts.fields.Get(key) != want.Get(key) {
+				t.Errorf("expected %s=%q, got %q", key, want.Get(key), receipts.fields.Get(key))
+			}
+		}
+		if len(store.uploads) != 0 {
+			t.Errorf("expected the upload to be discarded")
+		}
+	})
+
+	t.Run("should keep a rejected upload", func(t *testing.T) {
+		store := newMockUploadStore()
+		receipts := &mockReceiptCreator{status: http.StatusBadRequest}
+		handler := NewHandler(store, &mockUserStore{}, receipts, t.TempDir())
+		location := createUpload(t, handler, 0, metadata)
+
+		rr := serve(handler, http.MethodPost, location+"/finalize", nil, nil)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if len(store.uploads) != 1 {
+			t.Errorf("expected the upload to be kept for a retry")
+		}
+	})
+}
+
+func serve(handler *Handler, method, target string, headers map[string]string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/uploads", handler.handleOptions).Methods(http.MethodOptions)
+	router.HandleFunc("/uploads", tusResumable(handler.handleCreateUpload)).Methods(http.MethodPost)
+	router.HandleFunc("/uploads/{id}", tusResumable(handler.handleGetOffset)).Methods(http.MethodHead)
+	router.HandleFunc("/uploads/{id}", tusResumable(handler.handleWriteChunk)).Methods(http.MethodPatch)
+	router.HandleFunc("/uploads/{id}", tusResumable(handler.handleDeleteUpload)).Methods(http.MethodDelete)
+	router.HandleFunc("/uploads/{id}/finalize", handler.handleFinalizeUpload).Methods(http.MethodPost)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func createUpload(t *testing.T, handler *Handler, length int, metadata string) string {
+	t.Helper()
+
+	rr := serve(handler, http.MethodPost, "/uploads", tusHeaders(
+		"Upload-Length", strconv.Itoa(length),
+		"Upload-Metadata", metadata,
+	), nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+
+	location := rr.Header().Get("Location")
+	if !strings.HasPrefix(location, "/uploads/") {
+		t.Fatalf("unexpected location %q", location)
+	}
+	return location
+}
+
+func tusHeaders(pairs ...string) map[string]string {
+	headers := map[string]string{"Tus-Resumable": "1.0.0"}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		headers[pairs[i]] = pairs[i+1]
+	}
+	return headers
+}
+
+// errReader fails like a dropped connection.
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+type mockUploadStore struct {
+	uploads map[string]types.Upload
+}
+
+func newMockUploadStore() *mockUploadStore {
+	return &mockUploadStore{uploads: map[string]types.Upload{}}
+}
+
+func (m *mockUploadStore) CreateUpload(upload types.Upload) error {
+	upload.CreatedAt = time.Now()
+	m.uploads[upload.ID] = upload
+	return nil
+}
+
+func (m *mockUploadStore) GetUpload(id string, userId int) (*types.Upload, error) {
+	upload, ok := m.uploads[id]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	return &upload, nil
+}
+
+func (m *mockUploadStore) UpdateUploadOffset(id string, offset int64) error {
+	upload := m.uploads[id]
+	upload.Offset = offset
+	m.uploads[id] = upload
+	return nil
+}
+
+func (m *mockUploadStore) DeleteUpload(id string, userId int) error {
+	if _, ok := m.uploads[id]; !ok {
+		return ErrUploadNotFound
+	}
+	delete(m.uploads, id)
+	return nil
+}
+
+func (m *mockUploadStore) ListExpiredUploads(createdBefore time.Time, limit int) ([]types.Upload, error) {
+	uploads := []types.Upload{}
+	for _, upload := range m.uploads {
+		if upload.CreatedAt.Before(createdBefore) && len(uploads) < limit {
+			uploads = append(uploads, upload)
+		}
+	}
+	return uploads, nil
+}
+
+// mockReceiptCreator records what it was given and answers with status,
+// 201 by default.
+type mockReceiptCreator struct {
+	status int
+	calls  int
+	fields url.Values
+	body   string
+}
+
+func (m *mockReceiptCreator) CreateReceiptFromUpload(w http.ResponseWriter, r *http.Request, fields url.Values, file io.Reader) {
+	m.calls++
+	m.fields = fields
+	body, _ := io.ReadAll(file)
+	m.body = string(body)
+
+	status := m.status
+	if status == 0 {
+		status = http.StatusCreated
+	}
+	w.WriteHeader(status)
+}
+
+type mockUserStore struct{}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return nil, nil
+}
+
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	return nil, nil
+}
+
+func (m *mockUserStore) CreateUser(u types.User) error {
+	return nil
+}
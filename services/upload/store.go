@@ -0,0 +1,101 @@
+package upload
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/groshiniprasad/uploady/types"
+)
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreateUpload(upload types.Upload) error {
+	_, err := s.db.Exec(
+		"INSERT INTO uploads (id, userId, uploadLength, uploadOffset, metadata) VALUES (?, ?, ?, ?, ?)",
+		upload.ID, upload.UserID, upload.Length, upload.Offset, upload.Metadata,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetUpload(id string, userId int) (*types.Upload, error) {
+	row := s.db.QueryRow(
+		"SELECT id, userId, uploadLength, uploadOffset, metadata, createdAt FROM uploads WHERE id = ? AND userId = ?",
+		id, userId,
+	)
+
+	upload := new(types.Upload)
+	err := row.Scan(&upload.ID, &upload.UserID, &upload.Length, &upload.Offset, &upload.Metadata, &upload.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload: %w", err)
+	}
+
+	return upload, nil
+}
+
+func (s *Store) UpdateUploadOffset(id string, offset int64) error {
+	_, err := s.db.Exec("UPDATE uploads SET uploadOffset = ? WHERE id = ?", offset, id)
+	if err != nil {
+		return fmt.Errorf("failed to update upload: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteUpload(id string, userId int) error {
+	res, err := s.db.Exec("DELETE FROM uploads WHERE id = ? AND userId = ?", id, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	if n == 0 {
+		return ErrUploadNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) ListExpiredUploads(createdBefore time.Time, limit int) ([]types.Upload, error) {
+	rows, err := s.db.Query(
+		"SELECT id, userId, uploadLength, uploadOffset, metadata, createdAt FROM uploads WHERE createdAt < ? ORDER BY createdAt LIMIT ?",
+		createdBefore, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired uploads: %w", err)
+	}
+	defer rows.Close()
+
+	uploads := []types.Upload{}
+	for rows.Next() {
+		var upload types.Upload
+		if err := rows.Scan(&upload.ID, &upload.UserID, &upload.Length, &upload.Offset, &upload.Metadata, &upload.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to list expired uploads: %w", err)
+		}
+		uploads = append(uploads, upload)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list expired uploads: %w", err)
+	}
+
+	return uploads, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/groshiniprasad/uploady/configs"
+	"github.com/groshiniprasad/uploady/money"
 	"github.com/groshiniprasad/uploady/services/auth"
 	"github.com/groshiniprasad/uploady/types"
 	"github.com/groshiniprasad/uploady/utils"
@@ -76,6 +77,16 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	homeCurrency := configs.Envs.DefaultCurrency
+	if user.HomeCurrency != "" {
+		currency, err := money.ParseCurrency(user.HomeCurrency)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid homeCurrency: %w", err))
+			return
+		}
+		homeCurrency = currency
+	}
+
 	// check if user exists
 	_, err := h.store.GetUserByEmail(user.Email)
 	if err == nil {
@@ -91,10 +102,11 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.store.CreateUser(types.User{
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		Email:     user.Email,
-		Password:  hashedPassword,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Email:        user.Email,
+		Password:     hashedPassword,
+		HomeCurrency: homeCurrency,
 	})
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
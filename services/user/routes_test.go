@@ -3,6 +3,7 @@ package user
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -48,6 +49,24 @@ func TestUserServiceHandlers(t *testing.T) {
 			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
 		}
 	})
+
+	t.Run("should refuse an unknown home currency", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/register", strings.NewReader(`{"firstName": "Ana", "lastName": "Silva", "email": "ana@example.com", "password": "secret", "homeCurrency": "EURO"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/register", handler.handleRegister).Methods(http.MethodPost)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "homeCurrency") {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body)
+		}
+	})
 }
 
 type mockUserStore struct{}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBlobStore(t, store)
+
+	t.Run("should reject keys that escape the root", func(t *testing.T) {
+		err := store.Put(context.Background(), "../escape.jpg", strings.NewReader("x"), 1, "image/jpeg")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected ErrInvalidKey, got %v", err)
+		}
+	})
+}
+
+func TestS3Store(t *testing.T) {
+	fake := newFakeS3("receipts")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := NewS3Store(S3Config{
+		Endpoint:     server.URL,
+		Bucket:       "receipts",
+		AccessKey:    "access",
+		SecretKey:    "secret",
+		UsePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBlobStore(t, store)
+}
+
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+
+	t.Run("should round trip an object", func(t *testing.T) {
+		if err := store.Put(ctx, "a/b.png", strings.NewReader("hello"), 5, "image/png"); err != nil {
+			t.Fatal(err)
+		}
+
+		rc, info, err := store.Get(ctx, "a/b.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+
+		b, _ := io.ReadAll(rc)
+		if string(b) != "hello" {
+			t.Errorf("expected hello, got %q", b)
+		}
+		if info.Size != 5 || info.ContentType != "image/png" {
+			t.Errorf("unexpected object info: %+v", info)
+		}
+	})
+
+	t.Run("should report missing objects", func(t *testing.T) {
+		if _, err := store.Stat(ctx, "missing.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("should list the entries below a prefix", func(t *testing.T) {
+		for _, key := range []string{"list/a.png", "list/b/c.png", "list/b/d.png", "other/e.png"} {
+			if err := store.Put(ctx, key, strings.NewReader("x"), 1, "image/png"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		names, err := store.List(ctx, "list/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(names)
+		if got := strings.Join(names, ","); got != "a.png,b/" {
+			t.Errorf("expected a.png,b/, got %s", got)
+		}
+
+		if names, err := store.List(ctx, "missing/"); err != nil || len(names) != 0 {
+			t.Errorf("expected nothing below a missing prefix, got %v %v", names, err)
+		}
+		if _, err := store.List(ctx, "list"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected ErrInvalidKey for a prefix without a slash, got %v", err)
+		}
+	})
+
+	t.Run("should delete objects", func(t *testing.T) {
+		if err := store.Delete(ctx, "a/b.png"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := store.Get(ctx, "a/b.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		if err := store.Delete(ctx, "a/b.png"); err != nil {
+			t.Errorf("expected deleting a missing object to succeed, got %v", err)
+		}
+	})
+}
+
+// fakeS3 is a minimal in-memory stand-in for a path-style S3 bucket.
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	body        []byte
+	contentType string
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: map[string]fakeObject{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key == "" && r.Method == http.MethodGet {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		f.objects[key] = fakeObject{body: b, contentType: r.Header.Get("Content-Type")}
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.body)))
+		if r.Method == http.MethodGet {
+			w.Write(obj.body)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list answers a ListObjectsV2 request with a slash delimiter, in one page.
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	var contents, prefixes []string
+	for key := range f.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if dir, _, ok := strings.Cut(rest, "/"); ok {
+			if p := prefix + dir + "/"; !slices.Contains(prefixes, p) {
+				prefixes = append(prefixes, p)
+			}
+		} else {
+			contents = append(contents, key)
+		}
+	}
+
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, key := range contents {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", key)
+	}
+	for _, p := range prefixes {
+		fmt.Fprintf(w, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", p)
+	}
+	fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+}
@@ -1,7 +1,10 @@
 package types
 
 import (
+	"encoding/json"
 	"time"
+
+	"github.com/groshiniprasad/uploady/money"
 )
 
 type Config struct {
@@ -19,6 +22,10 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  string    `json:"-"`
 	CreatedAt time.Time `json:"createdAt"`
+	// HomeCurrency is the currency receipt amounts are converted into
+	HomeCurrency money.Currency `json:"homeCurrency"`
+	// IsAdmin lets the user manage the exchange rates
+	IsAdmin bool `json:"isAdmin"`
 }
 
 type UserStore interface {
@@ -40,6 +47,8 @@ type RegisterUserPayload struct {
 	LastName  string `json:"lastName" validate:"required"`
 	Email     string `json:"email" validate:"required,email"`
 	Password  string `json:"password" validate:"required,min=4,max=13"`
+	// HomeCurrency defaults to DEFAULT_CURRENCY
+	HomeCurrency string `json:"homeCurrency"`
 }
 
 type LoginUserPayload struct {
@@ -48,27 +57,453 @@ type LoginUserPayload struct {
 }
 
 type Receipt struct {
-	ID          int       `json:"id"`
-	UserID      int       `json:"userID"`
-	Name        string    `json:"name"`
-	Amount      float64   `json:"amount"`
-	Date        time.Time `json:"date"`
-	Description string    `json:"description"`
-	ImagePath   string    `json:"imagePath"`
-	CreatedAt   time.Time `json:"createdAt"`
+	ID          int            `json:"id"`
+	UserID      int            `json:"userID"`
+	Name        string         `json:"name"`
+	Amount      money.Amount   `json:"amount"`
+	Currency    money.Currency `json:"currency"`
+	Date        time.Time      `json:"date"`
+	Description string         `json:"description"`
+	CategoryID  *int           `json:"categoryId,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	ImageKey    string         `json:"-"`
+	ImageDigest string         `json:"-"`
+	ImageSize   int64          `json:"imageSize"`
+	ContentType string         `json:"contentType"`
+	PHash       *uint64        `json:"-"`
+	// Orientation is the EXIF orientation applied when the image is served
+	Orientation  int        `json:"-"`
+	CapturedAt   *time.Time `json:"capturedAt,omitempty"`
+	CameraWidth  int        `json:"cameraWidth,omitempty"`
+	CameraHeight int        `json:"cameraHeight,omitempty"`
+	// ProcessingStatus tells whether the post-upload work on the image is
+	// "pending", "processing", "done" or "failed"
+	ProcessingStatus string `json:"processingStatus"`
+	// Suggestions are the values read from the image by OCR
+	Suggestions *ReceiptSuggestions `json:"suggestions,omitempty"`
+	// Converted is the amount in the user's home currency, set in listings
+	// when there is a rate for the receipt's date
+	Converted *ConvertedAmount `json:"converted,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+	DeletedAt *time.Time       `json:"deletedAt,omitempty"`
+}
+
+// Suggestion is a value read from a receipt's image, with how confident the
+// reading is, between 0 and 1.
+type Suggestion[T any] struct {
+	Value      T       `json:"value"`
+	Confidence float64 `json:"confidence"`
+}
+
+// ReceiptSuggestions are the merchant name, total and date found on a
+// receipt's image. Fields that could not be found are nil.
+type ReceiptSuggestions struct {
+	Name   *Suggestion[string]    `json:"name,omitempty"`
+	Amount *Suggestion[string]    `json:"amount,omitempty"`
+	Date   *Suggestion[time.Time] `json:"date,omitempty"`
 }
 
+// Receipt processing statuses
+const (
+	ProcessingPending = "pending"
+	ProcessingRunning = "processing"
+	ProcessingDone    = "done"
+	ProcessingFailed  = "failed"
+)
+
 type ReceiptStore interface {
 	GetReceiptByName(name string, userId int) (*User, error)
 	CreateReceipt(Receipt) (int, error)
+	// CreateReceipts creates all of the receipts or none of them.
+	CreateReceipts([]Receipt) ([]int, error)
 	GetReceiptByID(receiptId int, userId int) (*Receipt, error)
+	GetReceiptByDigest(digest string, userId int) (*Receipt, error)
+	FindSimilarReceipts(query SimilarReceiptQuery) ([]SimilarReceipt, error)
+	ListReceipts(filter ReceiptListFilter) (*ReceiptPage, error)
+	SummarizeReceipts(filter ReceiptListFilter) ([]ReceiptTotal, error)
+	UpdateReceipt(Receipt) error
+	DeleteReceipt(receiptId int, userId int) error
+	ListTrashedReceipts(userId int) ([]Receipt, error)
+	RestoreReceipt(receiptId int, userId int) error
+	ListPurgeableReceipts(deletedBefore time.Time, limit int) ([]Receipt, error)
+	// PurgeReceipt returns the keys of the receipt's images that no receipt
+	// refers to any more. Shared images are left out.
+	PurgeReceipt(receiptId int) ([]string, error)
+	// DeleteBlob calls remove to delete the object stored under key unless
+	// a receipt or attachment refers to it again. The decision is taken
+	// under the blob's row lock, so a concurrent reference waits for it.
+	DeleteBlob(key string, remove func() error) error
+
+	ListAttachments(receiptId int, userId int) ([]Attachment, error)
+	// ListAttachmentsAfter pages through the attachments of all users by ID.
+	ListAttachmentsAfter(afterId int, limit int) ([]Attachment, error)
+	GetAttachment(receiptId int, userId int, position int) (*Attachment, error)
+	// AddAttachment appends the attachment to the receipt and returns it
+	// with its ID and position set.
+	AddAttachment(attachment Attachment, userId int) (*Attachment, error)
+	// ReplaceAttachment and RemoveAttachment return the key of the previous
+	// image once nothing refers to it any more, or "" while it is shared.
+	ReplaceAttachment(attachment Attachment, userId int) (string, error)
+	RemoveAttachment(receiptId int, userId int, position int) (string, error)
+	// ReorderAttachments moves the attachment at position order[i] to i.
+	ReorderAttachments(receiptId int, userId int, order []int) error
+
+	SetProcessingStatus(receiptId int, status string) error
+	// SetReceiptPHash stores the perceptual hash of the receipt's primary
+	// image, on the receipt and its primary attachment.
+	SetReceiptPHash(receiptId int, phash uint64) error
+	SetReceiptSuggestions(receiptId int, suggestions *ReceiptSuggestions) error
+
+	ListLineItems(receiptId int, userId int) ([]LineItem, error)
+	// CreateLineItem adds the item to a live receipt of the user and returns
+	// its ID.
+	CreateLineItem(item LineItem, userId int) (int, error)
+	UpdateLineItem(item LineItem, userId int) error
+	DeleteLineItem(receiptId int, userId int, itemId int) error
+
+	ListCategories(userId int) ([]Category, error)
+	GetCategory(categoryId int, userId int) (*Category, error)
+	// CreateCategory returns the ID of the new category, whose parent must
+	// be a category of the same user.
+	CreateCategory(category Category) (int, error)
+	UpdateCategory(category Category) error
+	// DeleteCategory leaves its receipts without a category. Categories with
+	// subcategories are kept.
+	DeleteCategory(categoryId int, userId int) error
+
+	// ListTags returns the user's tags with the number of live receipts
+	// carrying them.
+	ListTags(userId int) ([]Tag, error)
+	RenameTag(tagId int, userId int, name string) error
+	DeleteTag(tagId int, userId int) error
 }
 
-type CreateReceiptPayload struct {
+// Category classifies receipts, such as "Travel", and can nest under a
+// parent category, such as "Lodging" under "Travel". Colour is a hex colour
+// such as "#1e88e5" and Icon the name of an icon for clients to show.
+type Category struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"-"`
+	ParentID  *int      `json:"parentId"`
+	Name      string    `json:"name"`
+	Colour    string    `json:"colour"`
+	Icon      string    `json:"icon"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CategoryPayload creates or replaces a category. Without ParentID the
+// category is at the top level.
+type CategoryPayload struct {
+	Name     string `json:"name" validate:"required,max=64"`
+	ParentID *int   `json:"parentId" validate:"omitempty,min=1"`
+	Colour   string `json:"colour" validate:"omitempty,hexcolor,len=7"`
+	Icon     string `json:"icon" validate:"max=64"`
+}
+
+// Tag is a free-form label of receipts, created when first used.
+type Tag struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Receipts int    `json:"receipts"`
+}
+
+type RenameTagPayload struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// Attachment is one of the files of a receipt, such as one part of a long
+// receipt or its invoice. The attachment at position 0 is the primary image
+// and is mirrored in the receipt's own image fields.
+type Attachment struct {
 	ID          int       `json:"id"`
-	UserID      int       `json:"userID"`
-	Name        string    `json:"name" validate:"required"`
-	Amount      float64   `json:"amount" validate:"required,gt=0"`
-	Date        time.Time `json:"date" validate:"required"`
-	Description string    `json:"description"`
+	ReceiptID   int       `json:"receiptId"`
+	Position    int       `json:"position"`
+	ImageKey    string    `json:"-"`
+	ImageDigest string    `json:"sha256"`
+	ImageSize   int64     `json:"size"`
+	ContentType string    `json:"contentType"`
+	PHash       *uint64   `json:"-"`
+	Orientation int       `json:"-"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+}
+
+type ReorderAttachmentsPayload struct {
+	Order []int `json:"order" validate:"required,min=1,dive,min=0"`
+}
+
+// LineItem is one line of a receipt, such as the lodging, meals or parking
+// on a hotel bill. Its prices are in the receipt's currency. UnitPrice
+// excludes tax, which is added at TaxRate percent.
+type LineItem struct {
+	ID          int          `json:"id"`
+	ReceiptID   int          `json:"receiptId"`
+	Description string       `json:"description"`
+	Quantity    float64      `json:"quantity"`
+	UnitPrice   money.Amount `json:"unitPrice"`
+	TaxRate     float64      `json:"taxRate"`
+	Category    string       `json:"category"`
+	// Tax and Amount are worked out from the fields above, Amount being the
+	// line's total including tax
+	Tax       money.Amount `json:"tax"`
+	Amount    money.Amount `json:"amount"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+// LineItemPayload creates or replaces a line item. Quantity defaults to 1.
+// UnitPrice, a string or a number, may be negative for discounts.
+type LineItemPayload struct {
+	Description string      `json:"description" validate:"required,max=255"`
+	Quantity    float64     `json:"quantity" validate:"omitempty,gt=0"`
+	UnitPrice   json.Number `json:"unitPrice" validate:"required"`
+	TaxRate     float64     `json:"taxRate" validate:"gte=0,lte=100"`
+	Category    string      `json:"category" validate:"max=64"`
+}
+
+// LineItemResponse is a created or updated line item, with a warning when
+// the receipt's line items no longer add up to its amount.
+type LineItemResponse struct {
+	LineItem
+	Warning string `json:"warning,omitempty"`
+}
+
+// LineItemList is a receipt's line items and their sum, with a warning when
+// the sum differs from the receipt's amount.
+type LineItemList struct {
+	Items   []LineItem   `json:"items"`
+	Total   money.Amount `json:"total"`
+	Warning string       `json:"warning,omitempty"`
+}
+
+// ReceiptListFilter narrows and orders a receipt listing. Pointer fields and
+// an empty Currency are optional and ignored.
+type ReceiptListFilter struct {
+	UserID    int
+	Cursor    string
+	Limit     int
+	DateFrom  *time.Time
+	DateTo    *time.Time
+	MinAmount *money.Amount
+	MaxAmount *money.Amount
+	Currency  money.Currency
+	// CategoryID includes the category's subcategories
+	CategoryID *int
+	// Tags narrow the listing to receipts with all of them
+	Tags       []string
+	NamePrefix string
+	Sort       string
+}
+
+// ReceiptPage is a single page of a receipt listing. NextCursor is empty on
+// the last page.
+type ReceiptPage struct {
+	Receipts   []Receipt `json:"receipts"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+type CreateReceiptPayload struct {
+	ID          int         `json:"id"`
+	UserID      int         `json:"userID"`
+	Name        string      `json:"name" validate:"required"`
+	Amount      json.Number `json:"amount" validate:"required"`
+	Currency    string      `json:"currency"`
+	Date        time.Time   `json:"date" validate:"required"`
+	Description string      `json:"description"`
+}
+
+// UpdateReceiptPayload is a partial update of a receipt's metadata. Fields
+// left out of the request body are nil and keep their current value. Amount
+// is a string or a number, read exactly either way.
+type UpdateReceiptPayload struct {
+	Name        *string      `json:"name" validate:"omitempty,min=1,max=255"`
+	Amount      *json.Number `json:"amount"`
+	Currency    *string      `json:"currency"`
+	Date        *string      `json:"date" validate:"omitempty,datetime=2006-01-02"`
+	Description *string      `json:"description"`
+	// CategoryID 0 takes the receipt out of its category
+	CategoryID *int `json:"categoryId" validate:"omitempty,min=0"`
+	// Tags replace the receipt's tags
+	Tags *[]string `json:"tags"`
+}
+
+// SimilarReceiptQuery looks for a user's receipts whose perceptual hash is
+// within MaxDistance bits of PHash. Amount, in Currency, and Date optionally
+// narrow the search to likely duplicates.
+type SimilarReceiptQuery struct {
+	UserID      int
+	PHash       uint64
+	MaxDistance int
+	ExcludeID   int
+	Amount      *money.Amount
+	Currency    money.Currency
+	Date        *time.Time
+	Limit       int
+}
+
+type SimilarReceipt struct {
+	Receipt
+	Distance int `json:"distance"`
+}
+
+// ReceiptTotal is the sum of the receipts of one currency and day.
+type ReceiptTotal struct {
+	Currency money.Currency
+	Date     time.Time
+	Total    money.Amount
+	Count    int
+}
+
+// CurrencyTotal is the sum of the receipts of one currency.
+type CurrencyTotal struct {
+	Currency money.Currency `json:"currency"`
+	Total    money.Amount   `json:"total"`
+	Count    int            `json:"count"`
+}
+
+// ReceiptSummary sums up the receipts of a listing in the user's home
+// currency. Unconverted counts the receipts left out of Total for lack of an
+// exchange rate on their date.
+type ReceiptSummary struct {
+	Currency    money.Currency  `json:"currency"`
+	Total       money.Amount    `json:"total"`
+	Count       int             `json:"count"`
+	Unconverted int             `json:"unconverted"`
+	ByCurrency  []CurrencyTotal `json:"byCurrency"`
+}
+
+// UploadReceiptResponse is the created receipt plus the job processing its
+// image, whose status can be followed at /jobs/{id}, and a warning when it
+// looks like a photo of a receipt that was already uploaded.
+type UploadReceiptResponse struct {
+	Receipt
+	JobID       int    `json:"jobId,omitempty"`
+	DuplicateOf *int   `json:"duplicateOf,omitempty"`
+	Warning     string `json:"warning,omitempty"`
+}
+
+// ProcessReceiptResult is the result of a receipt's processing job, with a
+// warning when the receipt looks like a photo of one uploaded before.
+type ProcessReceiptResult struct {
+	DuplicateOf *int   `json:"duplicateOf,omitempty"`
+	Warning     string `json:"warning,omitempty"`
+}
+
+// BulkUploadResult is the outcome for one file of a bulk upload: "created",
+// "skipped" for duplicates, or "failed" with the reason in Error.
+type BulkUploadResult struct {
+	Filename    string `json:"filename"`
+	Status      string `json:"status"`
+	ReceiptID   int    `json:"receiptId,omitempty"`
+	JobID       int    `json:"jobId,omitempty"`
+	DuplicateOf int    `json:"duplicateOf,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type BulkUploadReport struct {
+	Created int                `json:"created"`
+	Skipped int                `json:"skipped"`
+	Failed  int                `json:"failed"`
+	Results []BulkUploadResult `json:"results"`
+}
+
+// UploadStore keeps the state of resumable uploads, whose bytes are written
+// to a file of their own as they arrive.
+type UploadStore interface {
+	CreateUpload(Upload) error
+	GetUpload(id string, userId int) (*Upload, error)
+	UpdateUploadOffset(id string, offset int64) error
+	DeleteUpload(id string, userId int) error
+	// ListExpiredUploads returns up to limit uploads of any user that were
+	// created before createdBefore, oldest first.
+	ListExpiredUploads(createdBefore time.Time, limit int) ([]Upload, error)
+}
+
+// Upload is a resumable upload of a receipt file. Offset is the number of
+// bytes received so far out of Length. Metadata is the tus Upload-Metadata
+// header as sent by the client.
+type Upload struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"userId"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	Metadata  string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// JobStore is the queue of background jobs. Jobs are claimed by one worker
+// at a time, for a lease after which a worker that died is presumed gone and
+// the job is claimed again.
+type JobStore interface {
+	EnqueueJob(Job) (int, error)
+	GetJob(id int, userId int) (*Job, error)
+	// ClaimJob marks the next job due at now as running and returns it, or
+	// nil when no job is due.
+	ClaimJob(now time.Time, lease time.Duration) (*Job, error)
+	CompleteJob(id int, result json.RawMessage) error
+	// RetryJob queues the job again to run at runAt.
+	RetryJob(id int, runAt time.Time, lastError string) error
+	FailJob(id int, lastError string) error
+}
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is a unit of background work of the given Kind. Payload and Result
+// are JSON documents whose shape depends on the kind.
+type Job struct {
+	ID          int             `json:"id"`
+	UserID      int             `json:"-"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"-"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	LastError   string          `json:"lastError,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	RunAt       time.Time       `json:"runAt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// ExchangeRateStore keeps the exchange rates of currency pairs by day.
+type ExchangeRateStore interface {
+	// UpsertExchangeRates stores all of the rates or none of them, replacing
+	// those of the same pair and date.
+	UpsertExchangeRates([]ExchangeRate) error
+	// ListExchangeRates returns the rates dated between from and to, both
+	// included, of the pairs with a base or quote among the currencies.
+	ListExchangeRates(currencies []money.Currency, from, to time.Time) ([]ExchangeRate, error)
+}
+
+// ExchangeRate says one unit of Base buys Rate units of Quote from Date on,
+// until the pair's next rate.
+type ExchangeRate struct {
+	Date  time.Time      `json:"date"`
+	Base  money.Currency `json:"base"`
+	Quote money.Currency `json:"quote"`
+	Rate  money.Rate     `json:"rate"`
+}
+
+// ExchangeRatePayload sets the rate of a pair on a day, given as 2006-01-02.
+type ExchangeRatePayload struct {
+	Date  string      `json:"date" validate:"required"`
+	Base  string      `json:"base" validate:"required"`
+	Quote string      `json:"quote" validate:"required"`
+	Rate  json.Number `json:"rate" validate:"required"`
+}
+
+type UpsertExchangeRatesPayload struct {
+	Rates []ExchangeRatePayload `json:"rates" validate:"required,min=1,dive"`
+}
+
+// ConvertedAmount is an amount converted at the rate effective on RateDate.
+type ConvertedAmount struct {
+	Amount   money.Amount   `json:"amount"`
+	Currency money.Currency `json:"currency"`
+	Rate     money.Rate     `json:"rate"`
+	RateDate time.Time      `json:"rateDate"`
 }
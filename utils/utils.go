@@ -1,91 +1,58 @@
 package utils
 
 import (
-	"image"
-	"io"
-	"log"
-	"mime/multipart"
-	"net/http"
-	"os"
-	"path/filepath"
-	"strconv"
+	"errors"
+	"fmt"
 	"strings"
 
-	"golang.org/x/image/draw"
-
-	"github.com/google/uuid"
+	"github.com/gabriel-vasile/mimetype"
 )
 
 const MaxFileSize = 10 << 20 // 10 MB
 
-func IsValidImageFile(file multipart.File, header *multipart.FileHeader) (bool, string) {
-	// Check file size
-	if header.Size > MaxFileSize {
-		return false, "File size exceeds the limit (10 MB), please upload a smaller file"
-	}
-
-	// Check file extension
-	filename := header.Filename
-	ext := strings.ToLower(filepath.Ext(filename))
-	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
-		return false, "Only JPG and PNG files are allowed, please upload a valid image file"
-	}
-
-	// Check file content type
-	buffer := make([]byte, 512)
-	_, err := file.Read(buffer)
-	if err != nil {
-		return false, "Error reading file"
-	}
-	file.Seek(0, io.SeekStart) // Reset file pointer to beginning
-
-	contentType := http.DetectContentType(buffer)
-	if contentType != "image/jpeg" && contentType != "image/png" {
-		return false, "File is not a valid image, please upload a valid image file"
-	}
+var (
+	ErrFileTooLarge        = errors.New("file size exceeds the limit (10 MB), please upload a smaller file")
+	ErrUnsupportedFileType = errors.New("unsupported file type")
+)
 
-	return true, ""
+// SupportedUploadTypes are the receipt formats accepted for upload.
+var SupportedUploadTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/tiff",
+	"image/heic",
+	"image/heif",
+	"application/pdf",
 }
 
-func GenerateUniqueFilename(originalFilename string) string {
-	// Generate a unique filename using UUID
-	extension := filepath.Ext(originalFilename)
-	return uuid.New().String() + extension
+// DetectContentType identifies a file from its leading bytes, regardless of
+// its name. It returns the MIME type without parameters.
+func DetectContentType(head []byte) string {
+	contentType, _, _ := strings.Cut(mimetype.Detect(head).String(), ";")
+	return contentType
 }
 
-func CreateUploadsDir() {
-	dir := "./uploads"
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err := os.Mkdir(dir, 0755)
-		if err != nil {
-			log.Fatal(err)
+// IsSupportedUploadType reports whether contentType is one of the
+// SupportedUploadTypes.
+func IsSupportedUploadType(contentType string) bool {
+	for _, t := range SupportedUploadTypes {
+		if t == contentType {
+			return true
 		}
 	}
+	return false
 }
 
-func ResizeImage(img image.Image, width, height int) image.Image {
-	if width <= 0 || height <= 0 {
-		return img // Return original image if dimensions are invalid
-	}
-	dst := image.NewRGBA(image.Rect(0, 0, width, height))
-	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
-	return dst
-}
-
-// Helper to get width and height from query parameters
-func GetWidthHeightFromQuery(r *http.Request) (int, int) {
-	widthStr := r.URL.Query().Get("width")
-	heightStr := r.URL.Query().Get("height")
-
-	width, err := strconv.Atoi(widthStr)
-	if err != nil || width <= 0 {
-		width = 100 // default width
-	}
-
-	height, err := strconv.Atoi(heightStr)
-	if err != nil || height <= 0 {
-		height = 100 // default height
+// SniffUploadType detects the type of an uploaded file from its leading
+// bytes, regardless of its name, and returns an error wrapping
+// ErrUnsupportedFileType unless it is one of the SupportedUploadTypes.
+func SniffUploadType(head []byte) (string, error) {
+	contentType := DetectContentType(head)
+	if !IsSupportedUploadType(contentType) {
+		return contentType, fmt.Errorf("%w %s, please upload a JPEG, PNG, GIF, WebP, TIFF, HEIC or PDF file", ErrUnsupportedFileType, contentType)
 	}
 
-	return width, height
+	return contentType, nil
 }